@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WindowStats is what the plot shows, for -api-addr's /stats
+type WindowStats struct {
+	Time        time.Time `json:"time"`
+	Sent        int64     `json:"sent"`
+	Received    int64     `json:"received"`
+	Rate        int64     `json:"rps"` // measured over the last second
+	DesiredRate int64     `json:"desired_rps"`
+	BytesRate   int64     `json:"body_bytes_per_second"`
+
+	// of the requests in the window
+	OK       int64   `json:"ok"`
+	Degraded int64   `json:"degraded"` // of the ok ones, slower than -degraded
+	Errors   int64   `json:"errors"`
+	P50      float64 `json:"p50_ms"`
+	P90      float64 `json:"p90_ms"`
+	P99      float64 `json:"p99_ms"`
+
+	Unit    string        `json:"unit"` // of the bucket labels
+	Buckets []BucketStats `json:"buckets"`
+
+	Labels labelFlags `json:"labels,omitempty"` // from -label
+}
+
+// BucketStats are the responses of the window in one latency bucket
+type BucketStats struct {
+	Label    string  `json:"label"`
+	UpperMs  float64 `json:"upper_ms"`
+	OK       int64   `json:"ok"`
+	Degraded int64   `json:"degraded"`
+	Errors   int64   `json:"errors"`
+}
+
+// buildWindowStats takes a snapshot of the window, the same the reporter
+// draws
+func buildWindowStats(now time.Time) WindowStats {
+	s := WindowStats{
+		Time:        now,
+		Sent:        requestsSent.Load(),
+		Received:    responsesReceived.Load(),
+		Rate:        currentRate.Load(),
+		DesiredRate: desiredRate.Load(),
+		BytesRate:   currentByteRate.Load(),
+		Unit:        displayUnit.name,
+		Labels:      runLabels,
+	}
+
+	layoutMu.RLock()
+	defer layoutMu.RUnlock()
+
+	tOk, tDegraded, tBad := windowTimings()
+	counts := make([]int64, len(tOk))
+	s.Buckets = make([]BucketStats, len(tOk))
+	for bkt := range tOk {
+		ok := tOk[bkt] + tDegraded[bkt]
+		s.Buckets[bkt] = BucketStats{
+			Label:    rangeLabel(uint(bkt), uint(bkt)),
+			UpperMs:  bucketUpperMs(uint(bkt)),
+			OK:       ok,
+			Degraded: tDegraded[bkt],
+			Errors:   tBad[bkt],
+		}
+		s.OK += ok
+		s.Degraded += tDegraded[bkt]
+		s.Errors += tBad[bkt]
+		counts[bkt] = ok + tBad[bkt]
+	}
+	s.P50 = percentile(counts, 0.50)
+	s.P90 = percentile(counts, 0.90)
+	s.P99 = percentile(counts, 0.99)
+
+	return s
+}
+
+// apiHandler serves the live stats for -api-addr
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(buildWindowStats(time.Now()))
+	})
+
+	return mux
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_apiHandler(t *testing.T) {
+	setupTestLayout(t)
+
+	now := time.Now()
+	for i := 0; i < 9; i++ {
+		recordTiming(now, 2*time.Millisecond, true)
+	}
+	recordTiming(now, 50*time.Millisecond, false)
+	requestsSent.Store(12)
+	responsesReceived.Store(10)
+	desiredRate.Store(50)
+	defer desiredRate.Store(0)
+
+	srv := httptest.NewServer(apiHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("/stats Content-Type = %q, want application/json", ct)
+	}
+
+	var got WindowStats
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Sent != 12 || got.Received != 10 || got.DesiredRate != 50 || got.OK != 9 || got.Errors != 1 {
+		t.Errorf("/stats = %+v, want 12 sent, 10 received, 50 desired, 9 ok and 1 error", got)
+	}
+	if len(got.Buckets) != int(buckets) || got.Unit != "ms" {
+		t.Fatalf("/stats has %d buckets in %s, want %d in ms", len(got.Buckets), got.Unit, buckets)
+	}
+
+	layoutMu.RLock()
+	okBkt, badBkt := bucketIndex(2), bucketIndex(50)
+	layoutMu.RUnlock()
+	if got.Buckets[okBkt].OK != 9 || got.Buckets[badBkt].Errors != 1 || got.Buckets[okBkt].Label == "" {
+		t.Errorf("/stats buckets %+v and %+v, want 9 ok and 1 error", got.Buckets[okBkt], got.Buckets[badBkt])
+	}
+	if got.P50 != got.Buckets[okBkt].UpperMs || got.P99 != got.Buckets[badBkt].UpperMs {
+		t.Errorf("/stats p50 %g and p99 %g, want %g and %g", got.P50, got.P99, got.Buckets[okBkt].UpperMs, got.Buckets[badBkt].UpperMs)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitBackoff is set to back off on 429 Too Many Requests
+var rateLimitBackoff *backoff
+
+// backoff halves the desired rate on 429 responses, at most once every
+// interval, then holds it for as long as the server's Retry-After asks, but
+// at least an interval. After that it recovers a tenth of the rate it backed
+// off from every interval, until it is back there or the next 429.
+type backoff struct {
+	hits     chan time.Duration // Retry-After of every 429, 0 if it had none
+	interval time.Duration
+}
+
+func newBackoff(interval time.Duration) *backoff {
+	return &backoff{hits: make(chan time.Duration, 64), interval: interval}
+}
+
+// throttled tells the backoff about a 429 with the given Retry-After header
+func (b *backoff) throttled(retryAfter string) {
+	select {
+	case b.hits <- parseRetryAfter(retryAfter, time.Now()):
+	default: // it is backing off already
+	}
+}
+
+// run changes the rate through rateChanger until quit is closed
+func (b *backoff) run(rateChanger chan<- rateChange, quit <-chan struct{}) {
+	var target int64 // rate to recover to, 0 while not backing off
+	var holdUntil, lastCut time.Time
+
+	tick := time.NewTicker(b.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case wait := <-b.hits:
+			now := time.Now()
+			if wait < b.interval {
+				wait = b.interval
+			}
+			if hold := now.Add(wait); hold.After(holdUntil) {
+				holdUntil = hold
+			}
+
+			if now.Sub(lastCut) < b.interval {
+				continue
+			}
+
+			rate := desiredRate.Load()
+			if target == 0 {
+				target = rate
+			}
+			if cut := rate / 2; cut > 0 {
+				if !changeRate(rateChanger, -cut, quit) {
+					return
+				}
+				lastCut = now
+			}
+		case now := <-tick.C:
+			if target == 0 || now.Before(holdUntil) {
+				continue
+			}
+
+			step := target / 10
+			if step < 1 {
+				step = 1
+			}
+
+			rate := desiredRate.Load()
+			if rate+step >= target {
+				step, target = target-rate, 0
+			}
+			if !changeRate(rateChanger, step, quit) {
+				return
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// changeRate sends delta to rateChanger, unless quit is closed first
+func changeRate(rateChanger chan<- rateChange, delta int64, quit <-chan struct{}) bool {
+	select {
+	case rateChanger <- rateChange{delta, "backoff"}:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, in seconds or an HTTP date, as
+// the time to wait from now. Anything else is no wait.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_backoff(t *testing.T) {
+	defer desiredRate.Store(0)
+
+	quit := make(chan struct{})
+
+	_, rateChanger := ticker(1000, 0, nil, quit)
+	waitForRate := func(cond func(int64) bool) int64 {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			rate := desiredRate.Load()
+			if cond(rate) || time.Now().After(deadline) {
+				return rate
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForRate(func(r int64) bool { return r == 1000 })
+
+	b := newBackoff(10 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.run(rateChanger, quit)
+	}()
+	defer func() {
+		close(quit)
+		<-done
+	}()
+
+	// a stream of 429s, with a Retry-After holding off recovery meanwhile
+	for i := 0; i < 5; i++ {
+		b.throttled("1")
+		time.Sleep(15 * time.Millisecond)
+	}
+
+	if rate := waitForRate(func(r int64) bool { return r <= 1000/16 }); rate > 1000/16 {
+		t.Fatalf("desired rate is %d after five 429s, want it halved every time, at most %d", rate, 1000/16)
+	}
+
+	low := desiredRate.Load()
+	time.Sleep(200 * time.Millisecond)
+	if rate := desiredRate.Load(); rate != low {
+		t.Errorf("desired rate went from %d to %d before the Retry-After was over", low, rate)
+	}
+
+	if rate := waitForRate(func(r int64) bool { return r == 1000 }); rate != 1000 {
+		t.Errorf("desired rate recovered to %d, want 1000", rate)
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 3 ", 3 * time.Second},
+		{"Tue, 02 Jan 2024 15:04:35 GMT", 30 * time.Second},
+		{"Tue, 02 Jan 2024 15:00:00 GMT", 0},
+		{"-1", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
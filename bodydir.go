@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// bodyFiles are the bodies of -body-dir-cycle, one per file of a directory,
+// sent instead of the targets' bodies: in turn, or at random
+type bodyFiles struct {
+	bodies [][]byte
+	random bool
+	next   counter
+}
+
+// loadBodyDir reads every regular file in dir, in the order of their names.
+// Subdirectories and hidden files are skipped.
+func loadBodyDir(dir string, random bool) (*bodyFiles, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	b := &bodyFiles{random: random}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		body, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		b.bodies = append(b.bodies, body)
+	}
+
+	if len(b.bodies) == 0 {
+		return nil, errors.New("no body files in " + dir)
+	}
+
+	return b, nil
+}
+
+// pick is the body of the next request
+func (b *bodyFiles) pick() []byte {
+	if b.random {
+		return b.bodies[rand.Intn(len(b.bodies))]
+	}
+
+	return b.bodies[int((b.next.Add(1)-1)%int64(len(b.bodies)))]
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBodyDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func Test_loadBodyDir(t *testing.T) {
+	dir := writeBodyDir(t, map[string]string{
+		"b.json":   `{"b":1}`,
+		"a.json":   `{"a":1}`,
+		"c.bin":    "\x00\xff",
+		".swp":     "hidden",
+		"empty":    "",
+		"zz.large": "zzz",
+	})
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := loadBodyDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`{"a":1}`, `{"b":1}`, "\x00\xff", "", "zzz"}
+	if len(b.bodies) != len(want) {
+		t.Fatalf("loadBodyDir() read %d bodies, want %d", len(b.bodies), len(want))
+	}
+	for i, body := range b.bodies {
+		if string(body) != want[i] {
+			t.Errorf("loadBodyDir() body %d = %q, want %q", i, body, want[i])
+		}
+	}
+
+	if _, err := loadBodyDir(t.TempDir(), false); err == nil {
+		t.Error("loadBodyDir() of an empty directory, want an error")
+	}
+	if _, err := loadBodyDir(filepath.Join(dir, "missing"), false); err == nil {
+		t.Error("loadBodyDir() of a missing directory, want an error")
+	}
+}
+
+func TestNextRequestBodyFiles(t *testing.T) {
+	dir := writeBodyDir(t, map[string]string{"1": "one", "2": "two", "3": "three"})
+
+	tests := []struct {
+		name   string
+		random bool
+	}{
+		{"in turn", false},
+		{"at random", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := loadBodyDir(dir, tt.random)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trgt := targeter{
+				requests:  []request{{method: "POST", url: "http://127.0.0.1:5000/", body: []byte("ignored")}},
+				bodyFiles: b,
+			}
+
+			seen := make(map[string]int)
+			for i := 0; i < 300; i++ {
+				req, err := trgt.nextRequest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := ioutil.ReadAll(req.Body)
+				if !tt.random {
+					if want := []string{"one", "two", "three"}[i%3]; string(body) != want {
+						t.Fatalf("request %d body = %q, want %q", i, body, want)
+					}
+				}
+				seen[string(body)]++
+			}
+
+			for _, body := range []string{"one", "two", "three"} {
+				if seen[body] == 0 {
+					t.Errorf("no request sent %q, got %v", body, seen)
+				}
+			}
+			if seen["ignored"] > 0 {
+				t.Error("requests sent the target's body instead of the files")
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"text/template"
+	"time"
+)
+
+// bodyData is what a -body-template is executed with, once per request
+type bodyData struct {
+	Seq       int64     // as {{seq}}, the same as anywhere else in the request
+	UUID      string    // a random UUID, the same everywhere in the template
+	Timestamp time.Time // when the request was made
+	Method    string    // of the target
+	URL       string    // of the target, after expanding tokens
+}
+
+var bodyTemplateFuncs = template.FuncMap{
+	"uuid": randomUUID,
+	"rand": rand.Int63,
+	"randInt": func(min, max int64) int64 {
+		if max < min {
+			return min
+		}
+		return min + int64(randomUpTo(uint64(max-min)))
+	},
+}
+
+// loadBodyTemplate parses the template in path, and tries it out so that
+// mistakes show up at startup rather than as requests never sent
+func loadBodyTemplate(path string) (*template.Template, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(path).Funcs(bodyTemplateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+
+	sample := bodyData{UUID: randomUUID(), Timestamp: time.Now(), Method: "POST", URL: "http://localhost/"}
+	if _, err := renderBody(tmpl, sample); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+func renderBody(tmpl *template.Template, data bodyData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
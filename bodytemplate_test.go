@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func writeBodyTemplate(t *testing.T, text string) string {
+	path := filepath.Join(t.TempDir(), "body.tmpl")
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestNextRequestBodyTemplate(t *testing.T) {
+	tmpl, err := loadBodyTemplate(writeBodyTemplate(t,
+		`{"seq":{{.Seq}},"id":"{{.UUID}}","again":"{{.UUID}}","other":"{{uuid}}","at":"{{.Timestamp.Format "2006"}}","shard":{{randInt 1 4}},"method":"{{.Method}}","url":"{{.URL}}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trgt := targeter{
+		requests:     []request{{method: "PUT", url: "http://127.0.0.1:5000/{{seq}}", body: []byte("ignored")}},
+		bodyTemplate: tmpl,
+	}
+	trgt.seq.Store(7)
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for i := int64(7); i < 10; i++ {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, _ := ioutil.ReadAll(req.Body)
+		var body struct {
+			Seq              int64
+			ID, Again, Other string
+			At, Method, URL  string
+			Shard            int
+		}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Fatalf("%v in %s", err, raw)
+		}
+
+		if body.Seq != i || body.URL != req.URL.String() || req.URL.Path != "/"+strconv.FormatInt(i, 10) {
+			t.Errorf("Expected seq %d in body and url, got %d and %s", i, body.Seq, req.URL)
+		}
+		if !uuid.MatchString(body.ID) || body.Again != body.ID || !uuid.MatchString(body.Other) || body.Other == body.ID {
+			t.Errorf("Expected one UUID field and a fresh uuid, got %q, %q and %q", body.ID, body.Again, body.Other)
+		}
+		if len(body.At) != 4 || body.Method != "PUT" || body.Shard < 1 || body.Shard > 4 {
+			t.Errorf("Unexpected body %s", raw)
+		}
+	}
+}
+
+func TestLoadBodyTemplateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"syntax", `{{.Seq`},
+		{"unknown function", `{{nope}}`},
+		{"unknown field", `{{.Row.name}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadBodyTemplate(writeBodyTemplate(t, tt.text)); err == nil {
+				t.Errorf("Expected an error for %q", tt.text)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// captureBuffer is how many dumps can wait for the writer before more are
+// dropped, so that a slow disk doesn't slow down the workers
+const captureBuffer = 256
+
+// exchangeCapture writes a sampled fraction of the exchanges, request and
+// response in full, to a writer from a goroutine of its own
+type exchangeCapture struct {
+	rate    float64 // of exchanges captured, 0 to 1
+	dumps   chan []byte
+	done    chan struct{} // closed once every dump is written
+	dropped counter       // dumps the writer didn't keep up with
+}
+
+// exchangeCaptures is set by -capture-rate
+var exchangeCaptures *exchangeCapture
+
+// newExchangeCapture starts writing the captured exchanges to w, until
+// close is called
+func newExchangeCapture(w io.Writer, rate float64) *exchangeCapture {
+	c := &exchangeCapture{
+		rate:  rate,
+		dumps: make(chan []byte, captureBuffer),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(c.done)
+		for dump := range c.dumps {
+			w.Write(dump)
+		}
+	}()
+
+	return c
+}
+
+// sample decides whether to capture an exchange, before any work is done
+// to dump it
+func (c *exchangeCapture) sample() bool {
+	return rand.Float64() < c.rate
+}
+
+// record dumps an exchange that ended at now after elapsed: the request as
+// sent, and either the error or the response with as much of its body as
+// was read. It is dropped if the writer is behind.
+func (c *exchangeCapture) record(req *http.Request, resp *http.Response, body []byte, err error, now time.Time, elapsed time.Duration) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "=== %s %s %s %s\n", now.Format(time.RFC3339Nano), req.Method, req.URL, elapsed)
+
+	// dumping goes through a transport of its own, which must neither see the
+	// request canceled nor report to its trace
+	out := req.WithContext(context.Background())
+	if req.GetBody != nil {
+		if reqBody, bodyErr := req.GetBody(); bodyErr == nil {
+			out.Body = reqBody
+		}
+	}
+	if dump, dumpErr := httputil.DumpRequestOut(out, req.GetBody != nil); dumpErr == nil {
+		b.Write(dump)
+	} else {
+		fmt.Fprintf(&b, "%s %s\n", req.Method, req.URL)
+	}
+	b.WriteString("\n\n")
+
+	if err != nil {
+		fmt.Fprintf(&b, "error: %s\n\n", err)
+	} else {
+		if dump, dumpErr := httputil.DumpResponse(resp, false); dumpErr == nil {
+			b.Write(dump)
+		}
+		b.Write(body)
+		b.WriteString("\n\n")
+	}
+
+	select {
+	case c.dumps <- b.Bytes():
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// close waits for the captured exchanges to be written. Nothing may be
+// recorded after it.
+func (c *exchangeCapture) close() {
+	close(c.dumps)
+	<-c.done
+}
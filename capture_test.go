@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_exchangeCaptureRecord(t *testing.T) {
+	var buf bytes.Buffer
+	c := newExchangeCapture(&buf, 1)
+
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:5000/orders", strings.NewReader(`{"qty":1}`))
+	req.Header.Set("X-Test", "yes")
+	resp := &http.Response{
+		Status:     "201 Created",
+		StatusCode: 201,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Location": {"/orders/7"}},
+		Body:       http.NoBody,
+	}
+	now := time.Date(2026, 10, 14, 9, 12, 3, 0, time.UTC)
+	c.record(req, resp, []byte(`{"id":7}`), nil, now, 12*time.Millisecond)
+
+	failed, _ := http.NewRequest("GET", "http://127.0.0.1:5000/down", nil)
+	c.record(failed, nil, nil, errors.New("connection refused"), now, time.Second)
+	c.close()
+
+	got := buf.String()
+	for _, want := range []string{
+		"=== 2026-10-14T09:12:03Z POST http://127.0.0.1:5000/orders 12ms\n",
+		"POST /orders HTTP/1.1\r\nHost: 127.0.0.1:5000\r\n",
+		"X-Test: yes\r\n",
+		`{"qty":1}`,
+		"HTTP/1.1 201 Created\r\n",
+		"Location: /orders/7\r\n",
+		`{"id":7}`,
+		"=== 2026-10-14T09:12:03Z GET http://127.0.0.1:5000/down 1s\n",
+		"error: connection refused\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("capture = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func Test_attackCaptureRate(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	const requests, rate = 1000, 0.2
+
+	var buf bytes.Buffer
+	exchangeCaptures = newExchangeCapture(&buf, rate)
+	defer func() { exchangeCaptures = nil }()
+
+	trgt := &targeter{requests: []request{{method: "GET", url: srv.URL}}}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, client, ch, quit)
+	}()
+	for i := 0; i < requests; i++ {
+		ch <- time.Now()
+	}
+	close(quit)
+	<-done
+	exchangeCaptures.close()
+
+	// the captures are a binomial sample, so allow about 5 standard deviations
+	got := strings.Count(buf.String(), "=== ") + int(exchangeCaptures.dropped.Load())
+	if got < 135 || got > 265 {
+		t.Errorf("captured %d of %d exchanges, want about %d", got, requests, int(requests*rate))
+	}
+	if strings.Count(buf.String(), "HTTP/1.1 200 OK") != strings.Count(buf.String(), "=== ") {
+		t.Errorf("captures without their response: %q", buf.String())
+	}
+}
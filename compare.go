@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+)
+
+// Comparison is how one metric of a run compares to a baseline run
+type Comparison struct {
+	Metric    string
+	Baseline  float64
+	Candidate float64
+	Regressed bool
+}
+
+// comparedMetrics are the metrics -compare checks, and which way they
+// regress: up or down by more than the tolerance in percent, or up by more
+// than the error tolerance in percentage points
+var comparedMetrics = []struct {
+	name    string
+	value   func(s Summary) float64
+	regress string // up, down or points
+}{
+	{"p50", func(s Summary) float64 { return s.P50 }, "up"},
+	{"p90", func(s Summary) float64 { return s.P90 }, "up"},
+	{"p99", func(s Summary) float64 { return s.P99 }, "up"},
+	{"rps", func(s Summary) float64 { return s.RPS }, "down"},
+	{"error_rate", func(s Summary) float64 { return s.ErrorRate }, "points"},
+}
+
+// compareSummaries compares candidate to baseline. Latencies regress when
+// they grow by more than tolerance percent, the rate when it drops by
+// more, and the error rate when it grows by more than errorTolerance
+// percentage points.
+func compareSummaries(baseline, candidate Summary, tolerance, errorTolerance float64) []Comparison {
+	comparisons := make([]Comparison, len(comparedMetrics))
+	for i, m := range comparedMetrics {
+		c := Comparison{Metric: m.name, Baseline: m.value(baseline), Candidate: m.value(candidate)}
+
+		switch m.regress {
+		case "up":
+			c.Regressed = c.Candidate > c.Baseline*(1+tolerance/100)
+		case "down":
+			c.Regressed = c.Candidate < c.Baseline*(1-tolerance/100)
+		case "points":
+			c.Regressed = (c.Candidate-c.Baseline)*100 > errorTolerance
+		}
+
+		comparisons[i] = c
+	}
+
+	return comparisons
+}
+
+// regressions counts the regressed comparisons
+func regressions(comparisons []Comparison) int {
+	n := 0
+	for _, c := range comparisons {
+		if c.Regressed {
+			n++
+		}
+	}
+
+	return n
+}
+
+// writeComparison writes comparisons as a table, latencies in displayUnit
+func writeComparison(w io.Writer, comparisons []Comparison) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	u := displayUnit
+	fmt.Fprintf(tw, "metric\tbaseline\tcandidate\tchange\t\n")
+	for _, c := range comparisons {
+		var base, cand, change string
+		switch c.Metric {
+		case "error_rate":
+			base, cand = fmt.Sprintf("%.2f%%", c.Baseline*100), fmt.Sprintf("%.2f%%", c.Candidate*100)
+			change = fmt.Sprintf("%+.2fpp", (c.Candidate-c.Baseline)*100)
+		case "rps":
+			base, cand, change = fmt.Sprintf("%.1f", c.Baseline), fmt.Sprintf("%.1f", c.Candidate), relativeChange(c)
+		default:
+			base, cand = u.format(c.Baseline), u.format(c.Candidate)
+			change = relativeChange(c)
+		}
+
+		mark := ""
+		if c.Regressed {
+			mark = "REGRESSION"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Metric, base, cand, change, mark)
+	}
+	tw.Flush()
+}
+
+// relativeChange is the change from baseline to candidate in percent
+func relativeChange(c Comparison) string {
+	if c.Baseline == 0 {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%+.1f%%", (c.Candidate-c.Baseline)/c.Baseline*100)
+}
+
+// loadSummary reads a summary saved with -save-summary
+func loadSummary(file string) (Summary, error) {
+	var s Summary
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("reading summary %s: %s", file, err)
+	}
+
+	return s, nil
+}
+
+// saveSummary writes s to file as JSON, for -compare in a later run
+func saveSummary(file string, s Summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_compareSummaries(t *testing.T) {
+	baseline := Summary{P50: 10, P90: 20, P99: 50, RPS: 100, ErrorRate: 0.001}
+
+	tests := []struct {
+		name      string
+		candidate Summary
+		want      []string // regressed metrics
+	}{
+		{"same", baseline, nil},
+		{"within tolerance", Summary{P50: 11, P90: 21, P99: 54, RPS: 91, ErrorRate: 0.0015}, nil},
+		{"slower p99", Summary{P50: 10, P90: 20, P99: 80, RPS: 100, ErrorRate: 0.001}, []string{"p99"}},
+		{"faster is fine", Summary{P50: 5, P90: 10, P99: 20, RPS: 200, ErrorRate: 0}, nil},
+		{"lower rate", Summary{P50: 10, P90: 20, P99: 50, RPS: 80, ErrorRate: 0.001}, []string{"rps"}},
+		{"more errors", Summary{P50: 10, P90: 20, P99: 50, RPS: 100, ErrorRate: 0.005}, []string{"error_rate"}},
+		{"everything worse", Summary{P50: 20, P90: 40, P99: 100, RPS: 50, ErrorRate: 0.1}, []string{"p50", "p90", "p99", "rps", "error_rate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comparisons := compareSummaries(baseline, tt.candidate, 10, 0.1)
+
+			var got []string
+			for _, c := range comparisons {
+				if c.Regressed {
+					got = append(got, c.Metric)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareSummaries() regressed %v, want %v", got, tt.want)
+			}
+			if n := regressions(comparisons); n != len(tt.want) {
+				t.Errorf("regressions() = %d, want %d", n, len(tt.want))
+			}
+		})
+	}
+}
+
+func Test_writeComparison(t *testing.T) {
+	baseline := Summary{P50: 10, P90: 20, P99: 50, RPS: 100, ErrorRate: 0.001}
+	candidate := Summary{P50: 10, P90: 20, P99: 80, RPS: 100, ErrorRate: 0.002}
+
+	var buf bytes.Buffer
+	writeComparison(&buf, compareSummaries(baseline, candidate, 10, 0.1))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("writeComparison() wrote %d lines, want a header and 5 metrics:\n%s", len(lines), buf.String())
+	}
+	if f := strings.Fields(lines[3]); !reflect.DeepEqual(f, []string{"p99", "50.0ms", "80.0ms", "+60.0%", "REGRESSION"}) {
+		t.Errorf("writeComparison() p99 line = %q", lines[3])
+	}
+	if f := strings.Fields(lines[5]); !reflect.DeepEqual(f, []string{"error_rate", "0.10%", "0.20%", "+0.10pp"}) {
+		t.Errorf("writeComparison() error_rate line = %q", lines[5])
+	}
+}
+
+func Test_saveSummary(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "baseline.json")
+	want := Summary{Sent: 100, Received: 99, OK: 98, Errors: 1, ErrorRate: 0.0101, RPS: 50, P50: 12.6, P90: 25.1, P99: 63.1}
+
+	if err := saveSummary(file, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSummary(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadSummary() = %+v, want %+v", got, want)
+	}
+
+	if _, err := loadSummary(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loadSummary() of a missing file didn't fail")
+	}
+}
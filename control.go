@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// serveControl adds the targets sent over every connection to ln to trgt,
+// in format like the targets file, while the run goes on. A text target is
+// added once the line after it arrives, so a blank line sends off the last
+// one, and a jsonl one with its line. An invalid target is written back,
+// and ends the connection.
+func serveControl(ln net.Listener, trgt *targeter, format string, base64body bool) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := trgt.readFormat(conn, format, base64body); err != nil {
+				fmt.Fprintf(conn, "error: %s\n", err)
+			}
+		}(conn)
+	}
+}
+
+// addRequests appends requests to the ones trgt sends, after admit has
+// prepared them if it is set
+func (trgt *targeter) addRequests(requests []request) error {
+	if trgt.admit != nil {
+		var err error
+		if requests, err = trgt.admit(requests); err != nil {
+			return err
+		}
+	}
+
+	trgt.mu.Lock()
+	trgt.requests = append(trgt.requests, requests...)
+	trgt.mu.Unlock()
+
+	return nil
+}
+
+// admitTargets prepares the targets sent over -control-addr the way
+// newTargeter and checkRequests do the ones read at the start: only those
+// with one of tags are kept, if any are given, and sent to every one of
+// hosts, if any are given
+func admitTargets(tags, hosts []string) func(requests []request) ([]request, error) {
+	return func(requests []request) ([]request, error) {
+		if len(tags) > 0 {
+			requests = selectTags(requests, tags)
+		}
+
+		if len(hosts) > 0 {
+			var err error
+			if requests, err = crossHosts(requests, hosts); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := checkRequests(requests); err != nil {
+			return nil, err
+		}
+
+		return requests, nil
+	}
+}
+
+// pick is the request the idx'th call to nextRequest sends, false if there
+// are none yet
+func (trgt *targeter) pick(idx int64) (request, bool) {
+	trgt.mu.RLock()
+	defer trgt.mu.RUnlock()
+
+	if len(trgt.requests) == 0 {
+		return request{}, false
+	}
+
+	return trgt.requests[int(idx%int64(len(trgt.requests)))], true
+}
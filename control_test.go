@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_serveControl(t *testing.T) {
+	setupTestLayout(t)
+
+	hit := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit <- r.Method + " " + r.URL.Path
+	}))
+	defer srv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	trgt := &targeter{}
+	go serveControl(ln, trgt, "text", false)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the blank line sends off the target, with the connection still open
+	if _, err := conn.Write([]byte("POST " + srv.URL + "/pushed\n$ {}\n\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := trgt.pick(0); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("target sent over the control connection never added")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, http.DefaultClient, ch, quit)
+	}()
+	ch <- time.Now()
+	close(quit)
+	<-done
+
+	select {
+	case got := <-hit:
+		if got != "POST /pushed" {
+			t.Errorf("attack() sent %q, want the pushed target", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushed target not sent")
+	}
+
+	// an invalid target is written back
+	if _, err := conn.Write([]byte("GET " + srv.URL + "/${SLAPPER_TEST_UNSET}\n")); err != nil {
+		t.Fatal(err)
+	}
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	if !strings.HasPrefix(reply, "error: ") {
+		t.Errorf("invalid target got %q back, want an error", reply)
+	}
+}
+
+func Test_admitTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    []string
+		hosts   []string
+		targets string
+		want    []string
+		wantErr bool
+	}{
+		{"as sent", nil, nil, "GET http://a.test/x\n\n", []string{"http://a.test/x"}, false},
+		{"tagged", []string{"smoke"}, nil, "#tag=smoke\nGET http://a.test/x\nGET http://a.test/y\n\n", []string{"http://a.test/x"}, false},
+		{"none tagged", []string{"smoke"}, nil, "GET http://a.test/y\n\n", nil, false},
+		{"hosts", nil, []string{"http://a.test", "http://b.test"}, "GET /x\n\n", []string{"http://a.test/x", "http://b.test/x"}, false},
+		{"hosts need paths", nil, []string{"http://a.test"}, "GET http://c.test/x\n\n", nil, true},
+		{"checked", nil, nil, "GET ftp://a.test/x\n\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trgt := &targeter{admit: admitTargets(tt.tags, tt.hosts)}
+			err := trgt.readFormat(strings.NewReader(tt.targets), "text", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, req := range trgt.requests {
+				got = append(got, req.url)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("added %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
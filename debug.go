@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+)
+
+// failureStop ends the run at the first failing request, keeping a
+// description of it to print once the screen is restored
+type failureStop struct {
+	once   sync.Once
+	stop   func()
+	detail string
+}
+
+// stopOnFailure is set by -stop-on-error
+var stopOnFailure *failureStop
+
+// fail records the first failure and stops the run, later ones are ignored
+func (f *failureStop) fail(detail string) {
+	f.once.Do(func() {
+		f.detail = detail
+		f.stop()
+	})
+}
+
+// describeFailure dumps a failed exchange: the request as sent, and either
+// the error or the response with as much of its body as was read
+func describeFailure(req *http.Request, resp *http.Response, body []byte, err error) string {
+	var b strings.Builder
+
+	b.WriteString("request:\n")
+	if req.GetBody != nil {
+		if reqBody, bodyErr := req.GetBody(); bodyErr == nil {
+			req.Body = reqBody
+		}
+	}
+	if dump, dumpErr := httputil.DumpRequest(req, req.GetBody != nil); dumpErr == nil {
+		b.Write(dump)
+	} else {
+		fmt.Fprintf(&b, "%s %s\n", req.Method, req.URL)
+	}
+
+	if err != nil {
+		fmt.Fprintf(&b, "\n\nerror: %s\n", err)
+		return b.String()
+	}
+
+	b.WriteString("\n\nresponse:\n")
+	if dump, dumpErr := httputil.DumpResponse(resp, false); dumpErr == nil {
+		b.Write(dump)
+	}
+	b.Write(body)
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// responseTee writes out the first response of the run in full, to check
+// that the targets and credentials are right
+type responseTee struct {
+	once sync.Once
+	w    io.Writer
+	done chan struct{} // closed once the first response is written
+}
+
+// teeFirst is set by -tee
+var teeFirst *responseTee
+
+func newResponseTee(w io.Writer) *responseTee {
+	return &responseTee{w: w, done: make(chan struct{})}
+}
+
+// dump writes the first response it is given, headers and body, or the
+// error its request failed with. The body is read into memory and put back
+// for the caller to read again.
+func (t *responseTee) dump(req *http.Request, resp *http.Response, err error) {
+	t.once.Do(func() {
+		defer close(t.done)
+
+		if err != nil {
+			fmt.Fprintf(t.w, "%s %s failed: %s\n", req.Method, req.URL, err)
+			return
+		}
+
+		dump, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			fmt.Fprintf(t.w, "%s %s: reading response: %s\n", req.Method, req.URL, err)
+			return
+		}
+
+		fmt.Fprintf(t.w, "%s %s\n", req.Method, req.URL)
+		t.w.Write(dump)
+		fmt.Fprintln(t.w)
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_stopOnError(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+		}
+	}))
+	defer srv.Close()
+
+	quit := make(chan struct{})
+	var once sync.Once
+	stopOnFailure = &failureStop{stop: func() { once.Do(func() { close(quit) }) }}
+	defer func() { stopOnFailure = nil }()
+
+	trgt := &targeter{requests: []request{
+		{method: "GET", url: srv.URL + "/ok"},
+		{method: "POST", url: srv.URL + "/fail", body: []byte("payload")},
+	}}
+	trgt.idx.Store(-1)
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	done := make(chan struct{})
+	go func() {
+		attack(trgt, client, ch, quit)
+		close(done)
+	}()
+
+	// the first request succeeds, the second one fails and stops the run
+	for i := 0; i < 2; i++ {
+		select {
+		case ch <- time.Now():
+		case <-done:
+			t.Fatalf("attack() stopped after %d requests, want 2", i)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("attack() never stopped after the failing request")
+	}
+
+	for _, want := range []string{"POST /fail", "payload", "500 Internal Server Error", "boom"} {
+		if !strings.Contains(stopOnFailure.detail, want) {
+			t.Errorf("failure detail is missing %q:\n%s", want, stopOnFailure.detail)
+		}
+	}
+}
+
+func Test_describeFailureError(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:1/", nil)
+	_, err := http.DefaultClient.Do(req)
+	if err == nil {
+		t.Skip("something listens on port 1")
+	}
+
+	detail := describeFailure(req, nil, nil, err)
+	if !strings.Contains(detail, "GET / HTTP/1.1") || !strings.Contains(detail, "error: ") {
+		t.Errorf("describeFailure() = %q, want the request and the error", detail)
+	}
+}
+
+func Test_responseTee(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Served-By", "test")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer srv.Close()
+
+	var buf strings.Builder
+	tee := newResponseTee(&buf)
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", srv.URL+"/items", nil)
+		resp, err := http.DefaultClient.Do(req)
+		tee.dump(req, resp, err)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the body is still there to be consumed
+		body, err := consumeBody(resp.Body, bodyRead)
+		if err != nil || string(body) != `{"id": 1}` {
+			t.Errorf("body after dump() = %q, %v, want it left for reading", body, err)
+		}
+	}
+
+	select {
+	case <-tee.done:
+	default:
+		t.Error("dump() didn't close done")
+	}
+
+	got := buf.String()
+	for _, want := range []string{"POST " + srv.URL + "/items\n", "HTTP/1.1 201 Created\r\n", "X-Served-By: test\r\n", `{"id": 1}`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("dump() = %q, want it to contain %q", got, want)
+		}
+	}
+	if n := strings.Count(got, "201 Created"); n != 1 {
+		t.Errorf("dump() wrote %d responses, want only the first", n)
+	}
+}
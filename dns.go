@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// hostResolver is the part of net.Resolver the DNS cache uses
+type hostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// contextDialer is the part of net.Dialer the DNS cache dials with
+type contextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// dnsCache remembers resolved addresses for ttl, so that new connections at
+// high rates don't all go to the resolver
+type dnsCache struct {
+	resolver hostResolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	// spread makes every new connection to a host start at the next of its
+	// addresses, instead of always preferring the first one
+	spread bool
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+	next    map[string]int
+}
+
+type dnsEntry struct {
+	ready   chan struct{} // closed once the lookup is done
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+func newDNSCache(resolver hostResolver, ttl time.Duration) *dnsCache {
+	return &dnsCache{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]*dnsEntry),
+		next:     make(map[string]int),
+	}
+}
+
+// newResolver sends all queries to server, a host:port or just a host for
+// port 53, instead of the system's resolvers
+func newResolver(server string) *net.Resolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// lookup resolves host, from the cache if it was resolved less than ttl ago.
+// Concurrent lookups of the same host share a single query. Failures are not cached.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok {
+		select {
+		case <-entry.ready:
+			if entry.err != nil || !c.now().Before(entry.expires) {
+				ok = false
+			}
+		default: // in flight
+		}
+	}
+
+	if !ok {
+		entry = &dnsEntry{ready: make(chan struct{})}
+		c.entries[host] = entry
+		c.mu.Unlock()
+
+		entry.addrs, entry.err = c.resolver.LookupIPAddr(ctx, host)
+		entry.expires = c.now().Add(c.ttl)
+		close(entry.ready)
+
+		return entry.addrs, entry.err
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.addrs, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// rotation returns the index of the address the next connection to host should try first
+func (c *dnsCache) rotation(host string) int {
+	if !c.spread {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := c.next[host]
+	c.next[host] = n + 1
+
+	return n
+}
+
+// dialContext dials using cached addresses, trying each one in turn until a connection succeeds
+func (c *dnsCache) dialContext(dialer contextDialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addrs = familyAddrs(addrs, network)
+
+		var conn net.Conn
+		first := c.rotation(host)
+		for i := range addrs {
+			ip := addrs[(first+i)%len(addrs)]
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+
+		if err == nil {
+			err = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+
+		return nil, err
+	}
+}
+
+// familyAddrs keeps the addresses usable over network, tcp4 or tcp6, and all of them for tcp
+func familyAddrs(addrs []net.IPAddr, network string) []net.IPAddr {
+	if network != "tcp4" && network != "tcp6" {
+		return addrs
+	}
+
+	var kept []net.IPAddr
+	for _, ip := range addrs {
+		if (ip.IP.To4() != nil) == (network == "tcp4") {
+			kept = append(kept, ip)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	calls int64
+	addrs []net.IPAddr
+	err   error
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt64(&r.calls, 1)
+	return r.addrs, r.err
+}
+
+func Test_dnsCache_lookup(t *testing.T) {
+	resolver := &fakeResolver{addrs: []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}}
+	cache := newDNSCache(resolver, time.Minute)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.lookup(context.Background(), "example.com"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	now = now.Add(59 * time.Second)
+	cache.lookup(context.Background(), "example.com")
+	if calls := atomic.LoadInt64(&resolver.calls); calls != 1 {
+		t.Errorf("lookup() called the resolver %d times within the TTL, want 1", calls)
+	}
+
+	cache.lookup(context.Background(), "example.org")
+	if calls := atomic.LoadInt64(&resolver.calls); calls != 2 {
+		t.Errorf("lookup() called the resolver %d times for two hosts, want 2", calls)
+	}
+
+	now = now.Add(time.Second)
+	cache.lookup(context.Background(), "example.com")
+	if calls := atomic.LoadInt64(&resolver.calls); calls != 3 {
+		t.Errorf("lookup() called the resolver %d times after the TTL, want 3", calls)
+	}
+}
+
+func Test_dnsCache_lookupErrorsNotCached(t *testing.T) {
+	resolver := &fakeResolver{err: &net.DNSError{Err: "no such host", Name: "example.com"}}
+	cache := newDNSCache(resolver, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.lookup(context.Background(), "example.com"); err == nil {
+			t.Error("lookup() expected an error")
+		}
+	}
+	if calls := atomic.LoadInt64(&resolver.calls); calls != 2 {
+		t.Errorf("lookup() called the resolver %d times, want 2", calls)
+	}
+}
+
+func Test_dnsCache_dialContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	resolver := &fakeResolver{addrs: []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}}
+	cfg := transportConfig{dial: newDNSCache(resolver, time.Minute).dialContext(&net.Dialer{})}
+
+	// without keep-alive every request dials
+	tr := newTransport(defaultIdleConnsPerHost, cfg)
+	tr.DisableKeepAlives = true
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://slapper.test:" + port + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	if calls := atomic.LoadInt64(&resolver.calls); calls != 1 {
+		t.Errorf("dialContext() called the resolver %d times, want 1", calls)
+	}
+}
+
+type recordingDialer struct {
+	mu    sync.Mutex
+	addrs []string
+	fail  map[string]bool
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.addrs = append(d.addrs, addr)
+	if d.fail[addr] {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: context.DeadlineExceeded}
+	}
+
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func Test_dnsCache_spread(t *testing.T) {
+	resolver := &fakeResolver{addrs: []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("10.0.0.2")},
+		{IP: net.ParseIP("10.0.0.3")},
+	}}
+
+	tests := []struct {
+		name   string
+		spread bool
+		fail   map[string]bool
+		want   []string
+	}{
+		{
+			name: "first address without spreading",
+			want: []string{"10.0.0.1:80", "10.0.0.1:80", "10.0.0.1:80", "10.0.0.1:80"},
+		},
+		{
+			name:   "rotates with spreading",
+			spread: true,
+			want:   []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.1:80"},
+		},
+		{
+			name:   "skips addresses that fail",
+			spread: true,
+			fail:   map[string]bool{"10.0.0.2:80": true},
+			want:   []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.3:80", "10.0.0.1:80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newDNSCache(resolver, time.Minute)
+			cache.spread = tt.spread
+
+			dialer := &recordingDialer{fail: tt.fail}
+			dial := cache.dialContext(dialer)
+			for i := 0; i < 4; i++ {
+				conn, err := dial(context.Background(), "tcp", "example.com:80")
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.Close()
+			}
+
+			if !reflect.DeepEqual(dialer.addrs, tt.want) {
+				t.Errorf("dialContext() dialed %v, want %v", dialer.addrs, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dnsCache_family(t *testing.T) {
+	resolver := &fakeResolver{addrs: []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("10.0.0.2")},
+	}}
+
+	tests := []struct {
+		network string
+		want    []string
+	}{
+		{"tcp", []string{"10.0.0.1:80", "[2001:db8::1]:80", "10.0.0.2:80"}},
+		{"tcp4", []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{"tcp6", []string{"[2001:db8::1]:80", "[2001:db8::1]:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			cache := newDNSCache(resolver, time.Minute)
+			cache.spread = true
+
+			dialer := &recordingDialer{}
+			dial := cache.dialContext(dialer)
+			for range tt.want {
+				conn, err := dial(context.Background(), tt.network, "example.com:80")
+				if err != nil {
+					t.Fatal(err)
+				}
+				conn.Close()
+			}
+
+			if !reflect.DeepEqual(dialer.addrs, tt.want) {
+				t.Errorf("dialContext(%q) dialed %v, want %v", tt.network, dialer.addrs, tt.want)
+			}
+		})
+	}
+}
+
+// stubDNS answers A queries for any name with ip over UDP, and AAAA queries
+// with no records, counting the queries it gets
+type stubDNS struct {
+	conn    net.PacketConn
+	ip      net.IP
+	queries counter
+}
+
+func newStubDNS(t *testing.T, ip string) *stubDNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &stubDNS{conn: conn, ip: net.ParseIP(ip).To4()}
+	go s.serve()
+
+	return s
+}
+
+func (s *stubDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.queries.Add(1)
+
+		// header, then the question: a name of labels, its type and class
+		query := buf[:n]
+		end := 12
+		for end < len(query) && query[end] != 0 {
+			end += int(query[end]) + 1
+		}
+		end += 5
+		if end > len(query) {
+			continue
+		}
+		qtype := binary.BigEndian.Uint16(query[end-4:])
+
+		resp := append([]byte{}, query[:end]...)
+		binary.BigEndian.PutUint16(resp[2:], 0x8180) // response, recursion available
+		binary.BigEndian.PutUint16(resp[6:], 0)      // answers
+		binary.BigEndian.PutUint16(resp[8:], 0)      // authority records
+		binary.BigEndian.PutUint16(resp[10:], 0)     // additional records
+		if qtype == 1 {
+			binary.BigEndian.PutUint16(resp[6:], 1)
+			resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+			resp = append(resp, s.ip...)
+		}
+		s.conn.WriteTo(resp, addr)
+	}
+}
+
+func Test_newResolver(t *testing.T) {
+	stub := newStubDNS(t, "10.1.2.3")
+	defer stub.conn.Close()
+
+	resolver := newResolver(stub.conn.LocalAddr().String())
+	addrs, err := resolver.LookupIPAddr(context.Background(), "api.test.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("10.1.2.3")) {
+		t.Errorf("LookupIPAddr() = %v, want the stub's 10.1.2.3", addrs)
+	}
+	if stub.queries.Load() == 0 {
+		t.Error("the stub DNS server got no queries")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// endpointKey is the request context key of the endpoint a request is for
+type endpointKey struct{}
+
+// endpoints holds the latency buckets of every endpoint, like timingsTotal
+// does for all of them together and in the same totalBuckets. The map is
+// guarded by mu.
+var endpoints struct {
+	mu      sync.Mutex
+	timings map[string][]counter
+}
+
+// endpointName identifies requests by method and target url as written in
+// the targets file, before expanding ranges, random parts and tokens
+func endpointName(method, url string) string {
+	return method + " " + url
+}
+
+// withEndpoint tags req with the endpoint it is for
+func withEndpoint(req *http.Request, endpoint string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), endpointKey{}, endpoint))
+}
+
+// endpointOf is the endpoint req was tagged with, if any
+func endpointOf(req *http.Request) string {
+	endpoint, _ := req.Context().Value(endpointKey{}).(string)
+	return endpoint
+}
+
+// recordEndpoint counts a response that took elapsed for endpoint
+func recordEndpoint(endpoint string, elapsed time.Duration) {
+	if endpoint == "" {
+		return
+	}
+
+	endpoints.mu.Lock()
+	if endpoints.timings == nil {
+		endpoints.timings = make(map[string][]counter)
+	}
+	timings, ok := endpoints.timings[endpoint]
+	if !ok {
+		timings = make([]counter, totalBuckets)
+		endpoints.timings[endpoint] = timings
+	}
+	endpoints.mu.Unlock()
+
+	timings[totalIndex(float64(elapsed)/float64(time.Millisecond))].Add(1)
+}
+
+// resetEndpoints forgets all endpoints
+func resetEndpoints() {
+	endpoints.mu.Lock()
+	endpoints.timings = make(map[string][]counter)
+	endpoints.mu.Unlock()
+}
+
+// EndpointSummary are the latencies of one endpoint
+type EndpointSummary struct {
+	Endpoint string  `json:"endpoint"`
+	Count    int64   `json:"count"`
+	P50      float64 `json:"p50_ms"`
+	P99      float64 `json:"p99_ms"`
+}
+
+// endpointSummaries summarizes every endpoint, sorted by name
+func endpointSummaries() []EndpointSummary {
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+
+	summaries := make([]EndpointSummary, 0, len(endpoints.timings))
+	for endpoint, timings := range endpoints.timings {
+		counts := make([]int64, len(timings))
+		var total int64
+		for i := range timings {
+			counts[i] = timings[i].Load()
+			total += counts[i]
+		}
+
+		summaries = append(summaries, EndpointSummary{
+			Endpoint: endpoint,
+			Count:    total,
+			P50:      totalPercentile(counts, 0.50),
+			P99:      totalPercentile(counts, 0.99),
+		})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Endpoint < summaries[j].Endpoint })
+
+	return summaries
+}
+
+// writeEndpointTable writes the endpoint summaries as a table, in displayUnit
+func writeEndpointTable(w io.Writer, summaries []EndpointSummary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	u := displayUnit
+	fmt.Fprintf(tw, "endpoint\tcount\tp50_%s\tp99_%s\n", u.name, u.name)
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%.*f\t%.*f\n", s.Endpoint, s.Count, u.decimals, s.P50*u.perMs, u.decimals, s.P99*u.perMs)
+	}
+	tw.Flush()
+}
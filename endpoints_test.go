@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_endpointStats(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			time.Sleep(40 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{}
+	targets := "GET " + srv.URL + "/fast/[1-3]\nPOST " + srv.URL + "/slow\n"
+	if err := trgt.readTargets(strings.NewReader(targets), false); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, client, ch, quit)
+	}()
+
+	before := responsesReceived.Load()
+	for i := 0; i < 8; i++ {
+		ch <- time.Now()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for responsesReceived.Load()-before < 8 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(quit)
+	<-done
+
+	layoutMu.RLock()
+	got := endpointSummaries()
+	layoutMu.RUnlock()
+
+	if len(got) != 2 {
+		t.Fatalf("endpointSummaries() = %+v, want 2 endpoints", got)
+	}
+
+	fast, slow := got[0], got[1]
+	if fast.Endpoint != "GET "+srv.URL+"/fast/[1-3]" || fast.Count != 6 {
+		t.Errorf("endpointSummaries()[0] = %+v, want GET .../fast/[1-3] with a count of 6", fast)
+	}
+	if slow.Endpoint != "POST "+srv.URL+"/slow" || slow.Count != 2 {
+		t.Errorf("endpointSummaries()[1] = %+v, want POST .../slow with a count of 2", slow)
+	}
+	if fast.P99 >= slow.P50 {
+		t.Errorf("fast endpoint p99 %.1fms not below slow endpoint p50 %.1fms", fast.P99, slow.P50)
+	}
+
+	resetStats()
+	layoutMu.RLock()
+	got = endpointSummaries()
+	layoutMu.RUnlock()
+	if len(got) != 0 {
+		t.Errorf("endpointSummaries() after resetStats() = %+v, want none", got)
+	}
+}
+
+func Test_writeEndpointTable(t *testing.T) {
+	var buf bytes.Buffer
+	writeEndpointTable(&buf, []EndpointSummary{
+		{Endpoint: "GET http://example.com/[1-3]", Count: 120, P50: 1.5, P99: 12},
+		{Endpoint: "POST http://example.com/b", Count: 7, P50: 40, P99: 80.25},
+	})
+
+	want := "endpoint                      count  p50_ms  p99_ms\n" +
+		"GET http://example.com/[1-3]  120    1.5     12.0\n" +
+		"POST http://example.com/b     7      40.0    80.2\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeEndpointTable() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// allowUnsetEnv makes expandEnv replace unset variables with nothing
+// instead of failing
+var allowUnsetEnv bool
+
+// envRef is a ${NAME} reference. Bare $NAME isn't expanded, as bodies are
+// full of dollar signs that aren't meant as variables.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv substitutes the ${NAME} references in s from the environment
+func expandEnv(s string) (string, error) {
+	var missing string
+	expanded := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && !allowUnsetEnv && missing == "" {
+			missing = name
+		}
+
+		return value
+	})
+
+	if missing != "" {
+		return "", fmt.Errorf("environment variable %s is not set", missing)
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func Test_expandEnv(t *testing.T) {
+	t.Setenv("SLAPPER_BASE_URL", "https://api.example.com")
+	t.Setenv("SLAPPER_EMPTY", "")
+
+	tests := []struct {
+		in         string
+		allowUnset bool
+		want       string
+		wantErr    bool
+	}{
+		{"${SLAPPER_BASE_URL}/items", false, "https://api.example.com/items", false},
+		{"x${SLAPPER_EMPTY}y", false, "xy", false},
+		{`{"$ref": "$SLAPPER_BASE_URL"}`, false, `{"$ref": "$SLAPPER_BASE_URL"}`, false},
+		{"${SLAPPER_UNSET}/items", false, "", true},
+		{"${SLAPPER_UNSET}/items", true, "/items", false},
+		{"${not a name}", false, "${not a name}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			allowUnsetEnv = tt.allowUnset
+			defer func() { allowUnsetEnv = false }()
+
+			got, err := expandEnv(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// findMaxPrecision is how close, as a fraction of the rate found, the
+// search gets to the lowest failing rate before it stops
+const findMaxPrecision = 0.05
+
+// rateSearch binary searches for the highest rate up to ceiling that the
+// target sustains, trying the ceiling first
+type rateSearch struct {
+	ceiling int64
+	low     int64 // highest rate that passed, 0 if none did
+	high    int64 // lowest rate that failed, ceiling+1 if none did
+	steps   []searchStep
+}
+
+// searchStep is a rate tried and how it went
+type searchStep struct {
+	rate   int64
+	passed bool
+	reason string // why it failed
+}
+
+func newRateSearch(ceiling int64) *rateSearch {
+	return &rateSearch{ceiling: ceiling, high: ceiling + 1}
+}
+
+// next is the rate to try next, false once the search is done
+func (s *rateSearch) next() (int64, bool) {
+	if len(s.steps) == 0 {
+		return s.ceiling, s.ceiling > 0
+	}
+
+	if s.high-s.low <= 1 || float64(s.high-s.low) <= findMaxPrecision*float64(s.low) {
+		return 0, false
+	}
+
+	return s.low + (s.high-s.low)/2, true
+}
+
+// observe narrows the search with how a window at rate went, reason being
+// why it failed
+func (s *rateSearch) observe(rate int64, passed bool, reason string) {
+	s.steps = append(s.steps, searchStep{rate: rate, passed: passed, reason: reason})
+	if passed {
+		s.low = rate
+	} else {
+		s.high = rate
+	}
+}
+
+// windowVerdict is whether a window at rate, summarized by s, kept to the
+// objectives: nearly the rate achieved, no more than maxErrorRate errors,
+// and a p99 within sloMs if set. If not it says why.
+func windowVerdict(s Summary, rate int64, maxErrorRate, sloMs float64) (bool, string) {
+	switch {
+	case s.Received == 0:
+		return false, "no responses"
+	case s.RPS < lagThreshold*float64(rate):
+		return false, fmt.Sprintf("only %.0f requests/s sent", s.RPS)
+	case s.ErrorRate > maxErrorRate:
+		return false, fmt.Sprintf("%.2f%% errors", s.ErrorRate*100)
+	case sloMs > 0 && s.P99 > sloMs:
+		return false, "p99 " + displayUnit.format(s.P99) + " above -slo"
+	}
+
+	return true, ""
+}
+
+// findMax runs the search from the rate the ticker starts at, changing the
+// rate to every candidate, and giving each a window of its own: after
+// settling for a tenth of the window, the totals are taken, and once the
+// window is over judge says how it went from their summary since. The stats
+// themselves go on across windows. It returns true when the search is done,
+// false if quit is closed first.
+func findMax(search *rateSearch, start int64, window time.Duration, rateChanger chan<- rateChange, judge func(rate int64, s Summary) (bool, string), quit <-chan struct{}) bool {
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+
+	current := start
+	for {
+		rate, ok := search.next()
+		if !ok {
+			return true
+		}
+
+		select {
+		case rateChanger <- rateChange{delta: rate - current, reason: "find-max"}:
+			current = rate
+		case <-quit:
+			return false
+		}
+
+		if !wait(window / 10) {
+			return false
+		}
+		from := takeTotals(time.Now())
+		if !wait(window) {
+			return false
+		}
+
+		passed, reason := judge(rate, takeTotals(time.Now()).since(from))
+		search.observe(rate, passed, reason)
+	}
+}
+
+// writeSearch writes out the rates tried and the highest one that passed
+func writeSearch(w io.Writer, s *rateSearch) {
+	for _, step := range s.steps {
+		if step.passed {
+			fmt.Fprintf(w, "find-max: %d requests/s passed\n", step.rate)
+		} else {
+			fmt.Fprintf(w, "find-max: %d requests/s failed: %s\n", step.rate, step.reason)
+		}
+	}
+
+	switch {
+	case len(s.steps) == 0:
+		fmt.Fprintln(w, "find-max: stopped before the first window was over")
+	case s.low == 0:
+		fmt.Fprintln(w, "find-max: no rate tried was sustained")
+	case s.low == s.ceiling:
+		fmt.Fprintf(w, "find-max: sustained %d requests/s, the most tried; raise -rate to search higher\n", s.low)
+	default:
+		fmt.Fprintf(w, "find-max: sustained %d requests/s, not %d\n", s.low, s.high)
+	}
+}
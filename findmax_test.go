@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rateSearch(t *testing.T) {
+	tests := []struct {
+		name     string
+		ceiling  int64
+		capacity int64 // highest rate the simulated target sustains
+		maxSteps int
+	}{
+		{"below the ceiling", 10000, 3210, 20},
+		{"at the ceiling", 500, 500, 1},
+		{"above the ceiling", 500, 100000, 1},
+		{"just above nothing", 1000, 1, 20},
+		{"nothing", 1000, 0, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRateSearch(tt.ceiling)
+			for {
+				rate, ok := s.next()
+				if !ok {
+					break
+				}
+				if rate <= s.low || rate >= s.high {
+					t.Fatalf("next() = %d, outside of the range left %d-%d", rate, s.low, s.high)
+				}
+				s.observe(rate, rate <= tt.capacity, "too fast")
+				if len(s.steps) > tt.maxSteps {
+					t.Fatalf("search took more than %d steps: %+v", tt.maxSteps, s.steps)
+				}
+			}
+
+			want := tt.capacity
+			if want > tt.ceiling {
+				want = tt.ceiling
+			}
+			got := s.low
+			if got > want || float64(want-got) > findMaxPrecision*float64(want) {
+				t.Errorf("search found %d, want %d or up to %.0f%% below", got, want, findMaxPrecision*100)
+			}
+			if s.steps[0].rate != tt.ceiling {
+				t.Errorf("search tried %d first, want the ceiling %d", s.steps[0].rate, tt.ceiling)
+			}
+		})
+	}
+}
+
+func Test_windowVerdict(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      Summary
+		slo    float64
+		want   bool
+		reason string
+	}{
+		{"passed", Summary{Received: 100, RPS: 100, ErrorRate: 0.005, P99: 80}, 100, true, ""},
+		{"no slo", Summary{Received: 100, RPS: 100, P99: 8000}, 0, true, ""},
+		{"no responses", Summary{RPS: 100}, 0, false, "no responses"},
+		{"lagging", Summary{Received: 100, RPS: 80}, 0, false, "only 80 requests/s sent"},
+		{"errors", Summary{Received: 100, RPS: 100, ErrorRate: 0.05}, 0, false, "5.00% errors"},
+		{"slow", Summary{Received: 100, RPS: 100, P99: 250}, 100, false, "p99 250.0ms above -slo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := windowVerdict(tt.s, 100, 0.01, tt.slo)
+			if got != tt.want || reason != tt.reason {
+				t.Errorf("windowVerdict() = %v, %q, want %v, %q", got, reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func Test_findMax(t *testing.T) {
+	const capacity = 700
+
+	rateChanger := make(chan rateChange)
+	rates := make(chan int64, 100)
+	go func() {
+		rate := int64(1000)
+		for c := range rateChanger {
+			rate += c.delta
+			if c.reason != "find-max" {
+				t.Errorf("rate changed for %q, want find-max", c.reason)
+			}
+			rates <- rate
+		}
+	}()
+
+	// responses from before a window don't count in it
+	base := responsesReceived.Load()
+	responsesReceived.Add(1000)
+	defer responsesReceived.Store(base)
+
+	var judged []int64
+	s := newRateSearch(1000)
+	start := time.Now()
+	done := findMax(s, 1000, 10*time.Millisecond, rateChanger, func(rate int64, window Summary) (bool, string) {
+		judged = append(judged, rate)
+		if window.Received != 0 || window.Elapsed < 0.01 {
+			t.Errorf("window at %d requests/s judged on %d responses in %.3fs, want none in at least 10ms", rate, window.Received, window.Elapsed)
+		}
+		return rate <= capacity, "too fast"
+	}, make(chan struct{}))
+	elapsed := time.Since(start)
+	close(rateChanger)
+
+	if !done {
+		t.Fatal("findMax() = false, want the search done")
+	}
+	for i, rate := range judged {
+		if got := <-rates; got != rate {
+			t.Errorf("window %d judged at %d requests/s, but the rate was %d", i, rate, got)
+		}
+	}
+	if min := time.Duration(len(judged)) * 11 * time.Millisecond; elapsed < min {
+		t.Errorf("findMax() took %s for %d windows, want at least %s", elapsed, len(judged), min)
+	}
+	if s.low > capacity || s.low < capacity*95/100 {
+		t.Errorf("findMax() found %d, want about %d", s.low, capacity)
+	}
+
+	var buf bytes.Buffer
+	writeSearch(&buf, s)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if lines[0] != "find-max: 1000 requests/s failed: too fast" {
+		t.Errorf("writeSearch() starts with %q, want the ceiling failing", lines[0])
+	}
+	if want := "find-max: sustained "; !strings.HasPrefix(lines[len(lines)-1], want) {
+		t.Errorf("writeSearch() ends with %q, want the rate sustained", lines[len(lines)-1])
+	}
+
+	quit := make(chan struct{})
+	close(quit)
+	if findMax(newRateSearch(1000), 1000, time.Minute, make(chan rateChange), nil, quit) {
+		t.Error("findMax() after quit = true, want false")
+	}
+}
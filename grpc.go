@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// grpcCodes is the number of gRPC status codes, OK (0) through UNAUTHENTICATED (16)
+const grpcCodes = 17
+
+// grpcStatuses counts gRPC responses by status code
+var grpcStatuses [grpcCodes]counter
+
+// grpcFrame wraps a serialized message in the length-prefixed, uncompressed
+// framing of a gRPC request
+func grpcFrame(message []byte) []byte {
+	frame := make([]byte, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(message)))
+	copy(frame[5:], message)
+
+	return frame
+}
+
+// setGRPCHeaders makes req a gRPC unary call
+func setGRPCHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+}
+
+// grpcStatus reads the status of a gRPC response, whose body must have been
+// read to the end for the trailers to be there. Servers failing a call
+// right away send the status in the headers instead.
+func grpcStatus(resp *http.Response) (int, error) {
+	value := resp.Trailer.Get("Grpc-Status")
+	if value == "" {
+		value = resp.Header.Get("Grpc-Status")
+	}
+	if value == "" {
+		return 0, fmt.Errorf("no grpc-status in response (HTTP %d)", resp.StatusCode)
+	}
+
+	return parseGRPCCode(value)
+}
+
+func parseGRPCCode(value string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || code < 0 || code >= grpcCodes {
+		return 0, fmt.Errorf("invalid grpc-status %q", value)
+	}
+
+	return code, nil
+}
+
+// grpcWebFrame frames a serialized message as a gRPC-Web request body, the
+// same as gRPC does, base64-encoded for the text format
+func grpcWebFrame(message []byte, text bool) []byte {
+	frame := grpcFrame(message)
+	if !text {
+		return frame
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(frame)))
+	base64.StdEncoding.Encode(encoded, frame)
+	return encoded
+}
+
+// setGRPCWebHeaders makes req a gRPC-Web unary call, in the text format or
+// the binary one
+func setGRPCWebHeaders(req *http.Request, text bool) {
+	contentType := "application/grpc-web+proto"
+	if text {
+		contentType = "application/grpc-web-text+proto"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+}
+
+// grpcWebStatus reads the status of a gRPC-Web response from the trailer
+// frame ending body, which is base64 in the text format. Like over gRPC, a
+// call failed right away has it in the headers instead.
+func grpcWebStatus(resp *http.Response, body []byte, text bool) (int, error) {
+	if text {
+		var err error
+		if body, err = decodeGRPCWebText(body); err != nil {
+			return 0, err
+		}
+	}
+
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return 0, errors.New("truncated gRPC-Web frame")
+		}
+		flags, n := body[0], binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(n) {
+			return 0, errors.New("truncated gRPC-Web frame")
+		}
+		payload := body[5 : 5+n]
+		body = body[5+n:]
+
+		if flags&0x80 == 0 {
+			continue
+		}
+		for _, line := range strings.Split(string(payload), "\r\n") {
+			if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(key), "grpc-status") {
+				return parseGRPCCode(value)
+			}
+		}
+	}
+
+	if value := resp.Header.Get("Grpc-Status"); value != "" {
+		return parseGRPCCode(value)
+	}
+
+	return 0, fmt.Errorf("no grpc-status in response (HTTP %d)", resp.StatusCode)
+}
+
+// decodeGRPCWebText decodes a text format body, which servers may write as
+// several base64 chunks, each padded on its own
+func decodeGRPCWebText(body []byte) ([]byte, error) {
+	var decoded []byte
+	body = bytes.TrimSpace(body)
+	for len(body) > 0 {
+		// a chunk ends after its padding, or at the end
+		end := len(body)
+		if i := bytes.IndexByte(body, '='); i >= 0 {
+			end = i
+			for end < len(body) && body[end] == '=' {
+				end++
+			}
+		}
+
+		chunk := make([]byte, base64.StdEncoding.DecodedLen(end))
+		n, err := base64.StdEncoding.Decode(chunk, body[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid gRPC-Web text body: %s", err)
+		}
+		decoded = append(decoded, chunk[:n]...)
+		body = body[end:]
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// grpcHandler answers unary calls with OK for the message "ok" and NOT_FOUND
+// for anything else, echoing the message back
+func grpcHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Method != "POST" ||
+			r.Header.Get("Content-Type") != "application/grpc" || r.Header.Get("TE") != "trailers" {
+			t.Errorf("not a gRPC call: %s %s, content-type %q, te %q",
+				r.Proto, r.Method, r.Header.Get("Content-Type"), r.Header.Get("TE"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		frame, _ := ioutil.ReadAll(r.Body)
+		if len(frame) < 5 || frame[0] != 0 || int(binary.BigEndian.Uint32(frame[1:5])) != len(frame)-5 {
+			t.Errorf("bad gRPC frame %q", frame)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		message := frame[5:]
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(grpcFrame(message))
+
+		status := "5"
+		if bytes.Equal(message, []byte("ok")) {
+			status = "0"
+		}
+		w.Header().Set("Grpc-Status", status)
+	})
+}
+
+func Test_grpcFrame(t *testing.T) {
+	got := grpcFrame([]byte("hi"))
+	want := []byte{0, 0, 0, 0, 2, 'h', 'i'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("grpcFrame() = %v, want %v", got, want)
+	}
+}
+
+func Test_attackGRPC(t *testing.T) {
+	tests := []struct {
+		name  string
+		start func(*httptest.Server)
+	}{
+		{"tls", func(srv *httptest.Server) {
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+		}},
+		{"h2c", func(srv *httptest.Server) {
+			srv.Config.Protocols = &http.Protocols{}
+			srv.Config.Protocols.SetUnencryptedHTTP2(true)
+			srv.Start()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestLayout(t)
+
+			srv := httptest.NewUnstartedServer(grpcHandler(t))
+			tt.start(srv)
+			defer srv.Close()
+
+			trgt := &targeter{
+				requests: []request{
+					{method: "GET", url: srv.URL + "/echo.Echo/Say", body: []byte("ok")},
+					{method: "GET", url: srv.URL + "/echo.Echo/Say", body: []byte("missing")},
+				},
+				grpc: true,
+			}
+			client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{http2: true})}
+
+			ch := make(chan time.Time)
+			quit := make(chan struct{})
+			defer close(quit)
+			go attack(trgt, client, ch, quit)
+
+			ch <- time.Now()
+			ch <- time.Now()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if ok, bad := windowTotals(); ok+bad == 2 {
+					if ok != 1 || bad != 1 {
+						t.Errorf("attack() recorded %d ok and %d bad calls, want 1 of each", ok, bad)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("attack() never recorded the calls")
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if ok, notFound := grpcStatuses[0].Load(), grpcStatuses[5].Load(); ok != 1 || notFound != 1 {
+				t.Errorf("attack() counted grpc statuses 0: %d and 5: %d, want 1 of each", ok, notFound)
+			}
+		})
+	}
+}
+
+func Test_grpcStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    *http.Response
+		want    int
+		wantErr bool
+	}{
+		{"trailer", &http.Response{Header: http.Header{}, Trailer: http.Header{"Grpc-Status": {"0"}}}, 0, false},
+		{"trailers only", &http.Response{Header: http.Header{"Grpc-Status": {"14"}}}, 14, false},
+		{"missing", &http.Response{StatusCode: 502, Header: http.Header{}}, 0, true},
+		{"out of range", &http.Response{Header: http.Header{"Grpc-Status": {"17"}}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := grpcStatus(tt.resp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("grpcStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("grpcStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// grpcWebTrailer is a gRPC-Web trailer frame carrying status
+func grpcWebTrailer(status string) []byte {
+	frame := grpcFrame([]byte("grpc-status: " + status + "\r\ngrpc-message: \r\n"))
+	frame[0] = 0x80
+	return frame
+}
+
+// grpcWebHandler answers unary calls like grpcHandler, over HTTP/1.1 with
+// the status in a trailer frame at the end of the body, in the format of
+// the call
+func grpcWebHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		text := contentType == "application/grpc-web-text+proto"
+		if r.ProtoMajor != 1 || r.Method != "POST" || r.Header.Get("X-Grpc-Web") != "1" ||
+			!text && contentType != "application/grpc-web+proto" {
+			t.Errorf("not a gRPC-Web call: %s %s, content-type %q", r.Proto, r.Method, contentType)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		frame, _ := ioutil.ReadAll(r.Body)
+		if text {
+			frame, _ = base64.StdEncoding.DecodeString(string(frame))
+		}
+		if len(frame) < 5 || frame[0] != 0 || int(binary.BigEndian.Uint32(frame[1:5])) != len(frame)-5 {
+			t.Errorf("bad gRPC-Web frame %q", frame)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		message := frame[5:]
+
+		status := "5"
+		if bytes.Equal(message, []byte("ok")) {
+			status = "0"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if text {
+			// chunks encoded one at a time, as servers stream them
+			io.WriteString(w, base64.StdEncoding.EncodeToString(grpcFrame(message)))
+			io.WriteString(w, base64.StdEncoding.EncodeToString(grpcWebTrailer(status)))
+			return
+		}
+		w.Write(grpcFrame(message))
+		w.Write(grpcWebTrailer(status))
+	})
+}
+
+func Test_attackGRPCWeb(t *testing.T) {
+	for _, format := range []string{"binary", "text"} {
+		t.Run(format, func(t *testing.T) {
+			setupTestLayout(t)
+
+			srv := httptest.NewServer(grpcWebHandler(t))
+			defer srv.Close()
+
+			trgt := &targeter{
+				requests: []request{
+					{method: "GET", url: srv.URL + "/echo.Echo/Say", body: []byte("ok")},
+					{method: "GET", url: srv.URL + "/echo.Echo/Say", body: []byte("missing")},
+				},
+				grpcWeb: format,
+			}
+			client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+			ch := make(chan time.Time)
+			quit := make(chan struct{})
+			defer close(quit)
+			go attack(trgt, client, ch, quit)
+
+			ch <- time.Now()
+			ch <- time.Now()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if ok, bad := windowTotals(); ok+bad == 2 {
+					if ok != 1 || bad != 1 {
+						t.Errorf("attack() recorded %d ok and %d bad calls, want 1 of each", ok, bad)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("attack() never recorded the calls")
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if ok, notFound := grpcStatuses[0].Load(), grpcStatuses[5].Load(); ok != 1 || notFound != 1 {
+				t.Errorf("attack() counted grpc statuses 0: %d and 5: %d, want 1 of each", ok, notFound)
+			}
+		})
+	}
+}
+
+func Test_grpcWebStatus(t *testing.T) {
+	body := append(grpcFrame([]byte("reply")), grpcWebTrailer("7")...)
+	text := base64.StdEncoding.EncodeToString(grpcFrame([]byte("reply"))) + base64.StdEncoding.EncodeToString(grpcWebTrailer("7"))
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    string
+		text    bool
+		want    int
+		wantErr bool
+	}{
+		{name: "trailer frame", body: string(body), want: 7},
+		{name: "text", body: text, text: true, want: 7},
+		{name: "trailers only", header: http.Header{"Grpc-Status": {"16"}}, want: 16},
+		{name: "truncated", body: string(body[:len(body)-3]), wantErr: true},
+		{name: "no trailer frame", body: string(grpcFrame([]byte("reply"))), wantErr: true},
+		{name: "bad text", body: "not base64!", text: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: 200, Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			got, err := grpcWebStatus(resp, []byte(tt.body), tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("grpcWebStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("grpcWebStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
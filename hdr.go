@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// latencyHDR records every latency for -hdr, when set
+var latencyHDR *hdrHistogram
+
+const (
+	hdrHighestMicros = int64(time.Hour / time.Microsecond)
+
+	// 3 significant digits: values below 2048 are exact, larger ones are
+	// within 1/1024 of the value
+	hdrSubBucketHalfCountMagnitude = 10
+	hdrSubBucketHalfCount          = 1 << hdrSubBucketHalfCountMagnitude
+	hdrSubBucketCount              = 2 * hdrSubBucketHalfCount
+	hdrSubBucketMask               = hdrSubBucketCount - 1
+
+	// percentiles reported per halving of the distance to 100%
+	hdrTicksPerHalfDistance = 5
+)
+
+// hdrHistogram is an HdrHistogram of latencies in microseconds, from 1µs to
+// an hour at 3 significant digits. Buckets double in range, and each is
+// split into hdrSubBucketHalfCount linear sub-buckets, so that resolution
+// follows the magnitude of the value. Safe to record from many goroutines.
+type hdrHistogram struct {
+	bucketCount int
+	counts      []counter
+	total       counter
+}
+
+func newHDRHistogram() *hdrHistogram {
+	// enough buckets that the last one holds hdrHighestMicros
+	bucketCount := 1
+	for smallestUntrackable := int64(hdrSubBucketCount); smallestUntrackable <= hdrHighestMicros; smallestUntrackable <<= 1 {
+		bucketCount++
+	}
+
+	return &hdrHistogram{
+		bucketCount: bucketCount,
+		counts:      make([]counter, (bucketCount+1)*hdrSubBucketHalfCount),
+	}
+}
+
+// record counts a latency of d, clamped to the range of the histogram
+func (h *hdrHistogram) record(d time.Duration) {
+	v := int64(d / time.Microsecond)
+	if v < 0 {
+		v = 0
+	} else if v > hdrHighestMicros {
+		v = hdrHighestMicros
+	}
+
+	h.counts[hdrCountsIndex(v)].Add(1)
+	h.total.Add(1)
+}
+
+// reset empties the histogram
+func (h *hdrHistogram) reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+	h.total.Store(0)
+}
+
+// hdrCountsIndex is the index in counts of the sub-bucket holding v
+func hdrCountsIndex(v int64) int {
+	bucket := (64 - hdrSubBucketHalfCountMagnitude - 1) - bits.LeadingZeros64(uint64(v)|hdrSubBucketMask)
+	sub := int(v >> uint(bucket))
+
+	return (bucket+1)<<hdrSubBucketHalfCountMagnitude + sub - hdrSubBucketHalfCount
+}
+
+// hdrValueRange is the lowest value counted at index i of counts, and how
+// many values from it on share the index
+func hdrValueRange(i int) (lowest, size int64) {
+	bucket := i>>hdrSubBucketHalfCountMagnitude - 1
+	sub := int64(i&(hdrSubBucketHalfCount-1)) + hdrSubBucketHalfCount
+	if bucket < 0 {
+		sub -= hdrSubBucketHalfCount
+		bucket = 0
+	}
+
+	return sub << uint(bucket), 1 << uint(bucket)
+}
+
+// valueAtPercentile is the highest value equivalent to the one q percent of
+// the recorded values are at or below, in microseconds
+func (h *hdrHistogram) valueAtPercentile(q float64) int64 {
+	total := h.total.Load()
+	want := int64(q/100*float64(total) + 0.5)
+	if want < 1 {
+		want = 1
+	}
+
+	var seen int64
+	for i := range h.counts {
+		if seen += h.counts[i].Load(); seen >= want {
+			lowest, size := hdrValueRange(i)
+			return lowest + size - 1
+		}
+	}
+
+	return 0
+}
+
+// writeDistribution writes the percentile distribution of the histogram in
+// milliseconds, in the text format of HdrHistogram's
+// outputPercentileDistribution, that its plotter and other tools read
+func (h *hdrHistogram) writeDistribution(w io.Writer) {
+	const scale = 1000 // microseconds per millisecond
+
+	counts := make([]int64, len(h.counts))
+	var total int64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+
+	fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+
+	var seen int64
+	var sum, max float64
+	next := 0.0 // percentile to report next
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		seen += c
+
+		lowest, size := hdrValueRange(i)
+		highest := float64(lowest+size-1) / scale
+		sum += float64(c) * float64(lowest+size/2) / scale
+		max = highest
+
+		for seen < total && next <= 100*float64(seen)/float64(total) {
+			fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", highest, next/100, seen, 1/(1-next/100))
+
+			halfDistance := math.Trunc(math.Pow(2, math.Trunc(math.Log2(100/(100-next)))+1))
+			next += 100 / (hdrTicksPerHalfDistance * halfDistance)
+		}
+	}
+	if total > 0 {
+		fmt.Fprintf(w, "%12.3f %2.12f %10d\n", max, 1.0, total)
+	}
+
+	mean, variance := 0.0, 0.0
+	if total > 0 {
+		mean = sum / float64(total)
+		for i, c := range counts {
+			if c > 0 {
+				lowest, size := hdrValueRange(i)
+				d := float64(lowest+size/2)/scale - mean
+				variance += float64(c) * d * d
+			}
+		}
+		variance /= float64(total)
+	}
+
+	fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean, math.Sqrt(variance))
+	fmt.Fprintf(w, "#[Max     = %12.3f, Total count    = %12d]\n", max, total)
+	fmt.Fprintf(w, "#[Buckets = %12d, SubBuckets     = %12d]\n", h.bucketCount, hdrSubBucketCount)
+}
+
+// writeHDR writes the distribution of h to file
+func writeHDR(file string, h *hdrHistogram) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	h.writeDistribution(f)
+
+	return f.Close()
+}
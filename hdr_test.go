@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_hdrCountsIndex(t *testing.T) {
+	tests := []struct {
+		v            int64
+		lowest, size int64
+	}{
+		{0, 0, 1},
+		{1, 1, 1},
+		{2047, 2047, 1},
+		{2048, 2048, 2},
+		{2049, 2048, 2},
+		{5000, 5000, 4},
+		{5003, 5000, 4},
+		{hdrHighestMicros, hdrHighestMicros &^ (1<<21 - 1), 1 << 21},
+	}
+	for _, tt := range tests {
+		lowest, size := hdrValueRange(hdrCountsIndex(tt.v))
+		if lowest != tt.lowest || size != tt.size {
+			t.Errorf("value %d counted as %d, %d wide, want %d, %d wide", tt.v, lowest, size, tt.lowest, tt.size)
+		}
+	}
+}
+
+func Test_hdrHistogramDistribution(t *testing.T) {
+	h := newHDRHistogram()
+
+	// 1ms to 10s, log-uniformly, so that every magnitude is used
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, 100000)
+	for i := range values {
+		values[i] = math.Pow(10, 4*r.Float64()) // ms
+		h.record(time.Duration(values[i] * float64(time.Millisecond)))
+	}
+
+	var buf bytes.Buffer
+	h.writeDistribution(&buf)
+	out := buf.String()
+
+	// the first line at or past the 99th percentile, as the HdrHistogram
+	// tools read it
+	var p99 float64
+	lines := 0
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] == "Value" || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		lines++
+
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		percentile, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p99 == 0 && percentile >= 0.99 {
+			p99 = value
+		}
+	}
+	if lines < 50 {
+		t.Fatalf("writeDistribution() wrote %d percentile lines, want more\n%s", lines, out)
+	}
+
+	// the exact p99 is 10^(4*0.99), about 9120ms
+	want := math.Pow(10, 4*0.99)
+	if math.Abs(p99-want)/want > 0.01 {
+		t.Errorf("p99 of the distribution = %.3fms, want %.3fms within 1%%", p99, want)
+	}
+	if got := float64(h.valueAtPercentile(99)) / 1000; math.Abs(got-want)/want > 0.01 {
+		t.Errorf("valueAtPercentile(99) = %.3fms, want %.3fms within 1%%", got, want)
+	}
+	if !strings.Contains(out, "Total count    =       100000]") {
+		t.Errorf("writeDistribution() footer lacks the total count\n%s", out)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// heatmapExtraWidth is how much wider the heatmap is than the histogram's
+// bars, taking the place of its counts
+const heatmapExtraWidth = len("[000000/000000] ") - len("||")
+
+// heatShades are the cells of the heatmap, from fewest requests to most
+var heatShades = []rune(" ░▒▓█")
+
+// heatmapShown is non-zero to draw the window as a heatmap over time instead
+// of a histogram
+var heatmapShown counter
+
+// timingSlots copies the ring buffer of timing slots and gives the one
+// being filled at now. Degraded responses count as ok. Must be called with
+// layoutMu held.
+func timingSlots(now time.Time) (ok, bad [][]int64, cur int) {
+	ok, bad = make([][]int64, len(timingsOk)), make([][]int64, len(timingsBad))
+	for slot := range timingsOk {
+		ok[slot], bad[slot] = make([]int64, len(timingsOk[slot])), make([]int64, len(timingsBad[slot]))
+		for bkt := range timingsOk[slot] {
+			ok[slot][bkt] = timingsOk[slot][bkt].Load() + timingsDegraded[slot][bkt].Load()
+			bad[slot][bkt] = timingsBad[slot][bkt].Load()
+		}
+	}
+
+	return ok, bad, timingsSlotIndex(now)
+}
+
+// heatmapCells sums slots, counts by ring buffer slot and latency bucket,
+// into cells by the runs of buckets in bars and of slots in cols. Columns go
+// from the oldest slot, the one after cur, to cur, the one being filled.
+func heatmapCells(slots [][]int64, cur int, bars, cols [][2]int) [][]int64 {
+	cells := make([][]int64, len(bars))
+	for row, bkts := range bars {
+		cells[row] = make([]int64, len(cols))
+		for col, ages := range cols {
+			for age := ages[0]; age <= ages[1]; age++ {
+				slot := slots[(cur+1+age)%len(slots)]
+				for bkt := bkts[0]; bkt <= bkts[1]; bkt++ {
+					cells[row][col] += slot[bkt]
+				}
+			}
+		}
+	}
+
+	return cells
+}
+
+// heatShade is the shade of a cell of count, next to the busiest cell of max.
+// Any request at all shows, however few.
+func heatShade(count, max int64) rune {
+	if count <= 0 || max <= 0 {
+		return heatShades[0]
+	}
+
+	levels := int64(len(heatShades) - 1)
+	return heatShades[(count*levels+max-1)/max]
+}
+
+// renderHeatmap draws the window as rows of latency buckets, merged into at
+// most rows like the histogram's, by columns of time, at most width of them,
+// the most recent on the right. Cells with errors are drawn in the palette's
+// bad color. The last line is the time axis. Must be called with layoutMu
+// held.
+func renderHeatmap(w io.Writer, p *palette, okSlots, badSlots [][]int64, cur, rows, width int) {
+	if rows > 1 {
+		rows-- // keep a line for the axis
+	}
+	bars := plotRows(len(okSlots[0]), rows)
+	cols := plotRows(len(okSlots), width)
+	ok := heatmapCells(okSlots, cur, bars, cols)
+	bad := heatmapCells(badSlots, cur, bars, cols)
+
+	max := int64(0)
+	for row := range ok {
+		for col := range ok[row] {
+			if sum := ok[row][col] + bad[row][col]; sum > max {
+				max = sum
+			}
+		}
+	}
+
+	for row, bkts := range bars {
+		var line strings.Builder
+		for col := range cols {
+			color := p.bucketColor(uint(row), uint(len(bars)))
+			if bad[row][col] > 0 {
+				color = p.bad
+			}
+			line.WriteString(paint(color, string(heatShade(ok[row][col]+bad[row][col], max))))
+		}
+
+		fmt.Fprintf(w, "%10s %2s: |%s| \r\n", rangeLabel(uint(bkts[0]), uint(bkts[1])), displayUnit.name, line.String())
+	}
+
+	window := fmt.Sprintf("-%.0fs", (time.Duration(len(okSlots)) * screenRefreshInterval).Seconds())
+	if gap := len(cols) - len(window) - len("now"); gap > 0 {
+		fmt.Fprintf(w, "%15s%s%s%s  \r\n", "", window, strings.Repeat(" ", gap), "now")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_heatmapCells(t *testing.T) {
+	// 6 slots of 4 buckets, slot 2 being filled, so slot 3 is the oldest
+	slots := [][]int64{
+		{1, 0, 0, 0}, // age 3
+		{0, 2, 0, 0}, // age 4
+		{0, 0, 3, 4}, // age 5, now
+		{5, 0, 0, 0}, // age 0, oldest
+		{0, 6, 0, 0}, // age 1
+		{0, 0, 0, 7}, // age 2
+	}
+
+	tests := []struct {
+		name string
+		bars [][2]int
+		cols [][2]int
+		want [][]int64
+	}{
+		{
+			name: "a cell per bucket and slot",
+			bars: plotRows(4, 4),
+			cols: plotRows(6, 6),
+			want: [][]int64{
+				{5, 0, 0, 1, 0, 0},
+				{0, 6, 0, 0, 2, 0},
+				{0, 0, 0, 0, 0, 3},
+				{0, 0, 7, 0, 0, 4},
+			},
+		},
+		{
+			name: "merged buckets and slots",
+			bars: plotRows(4, 2),
+			cols: plotRows(6, 3),
+			want: [][]int64{
+				{11, 1, 2},
+				{0, 7, 7},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := heatmapCells(slots, 2, tt.bars, tt.cols); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("heatmapCells() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_heatShade(t *testing.T) {
+	tests := []struct {
+		count, max int64
+		want       rune
+	}{
+		{0, 100, ' '},
+		{1, 100, '░'},
+		{25, 100, '░'},
+		{26, 100, '▒'},
+		{75, 100, '▓'},
+		{100, 100, '█'},
+		{0, 0, ' '},
+	}
+	for _, tt := range tests {
+		if got := heatShade(tt.count, tt.max); got != tt.want {
+			t.Errorf("heatShade(%d, %d) = %q, want %q", tt.count, tt.max, got, tt.want)
+		}
+	}
+}
+
+func Test_renderHeatmap(t *testing.T) {
+	setupTestLayout(t)
+
+	okSlots := make([][]int64, 100)
+	badSlots := make([][]int64, 100)
+	for slot := range okSlots {
+		okSlots[slot], badSlots[slot] = make([]int64, buckets), make([]int64, buckets)
+	}
+	okSlots[10][0] = 4
+	badSlots[10][buckets-1] = 1
+
+	var buf bytes.Buffer
+	renderHeatmap(&buf, palettes["mono"], okSlots, badSlots, 9, 5, 50)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 5 {
+		t.Fatalf("renderHeatmap() drew %d lines, want 4 rows and the axis:\n%s", len(lines), buf.String())
+	}
+	// slot 10 is the oldest, in the first column
+	if !strings.Contains(lines[0], ": |█ ") || !strings.Contains(lines[3], ": |░ ") {
+		t.Errorf("renderHeatmap() drew %q and %q, want the oldest column filled", lines[0], lines[3])
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[4]), "now") || !strings.Contains(lines[4], "-10s") {
+		t.Errorf("renderHeatmap() axis = %q", lines[4])
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// keyBindings are the keys keyPressListener handles, as listed in the help overlay
+var keyBindings = []struct {
+	keys string
+	help string
+}{
+	{"q, ctrl-c", "quit"},
+	{"r", "reset stats"},
+	{"c", "reset totals only"},
+	{"w", "reset window only"},
+	{"k", fmt.Sprintf("increase rate by %d RPS", rateIncreaseStep)},
+	{"j", fmt.Sprintf("decrease rate by %d RPS", -rateDecreaseStep)},
+	{"+", fmt.Sprintf("add %d workers", workersStep)},
+	{"-", fmt.Sprintf("stop %d workers", workersStep)},
+	{"p", "counts or percentages"},
+	{"h", "histogram or heatmap"},
+	{"s", "latency or body sizes"},
+	{"?", "show or hide this help"},
+}
+
+// helpShown is non-zero while the help overlay is up
+var helpShown counter
+
+// toggleHelp shows or hides the help overlay. Either way the screen is
+// redrawn from scratch, so nothing of the overlay is left behind.
+func toggleHelp() {
+	helpShown.Store(1 - helpShown.Load())
+	layoutGen.Add(1)
+}
+
+// helpLines is the boxed help panel, every line of the same width
+func helpLines() []string {
+	width := 0
+	for _, kb := range keyBindings {
+		if n := len(kb.keys) + len(" - ") + len(kb.help); n > width {
+			width = n
+		}
+	}
+
+	title := " keys (any key to close) "
+	if len(title) > width {
+		width = len(title)
+	}
+
+	lines := []string{"+" + title + strings.Repeat("-", width-len(title)+2) + "+"}
+	for _, kb := range keyBindings {
+		lines = append(lines, fmt.Sprintf("| %-*s |", width, kb.keys+" - "+kb.help))
+	}
+
+	return append(lines, "+"+strings.Repeat("-", width+2)+"+")
+}
+
+// renderHelp draws the help panel with its top left corner at row and col, counted from 1
+func renderHelp(w io.Writer, row, col int) {
+	for i, line := range helpLines() {
+		fmt.Fprintf(w, "\033[%d;%dH%s", row+i, col, line)
+	}
+}
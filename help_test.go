@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_renderHelp(t *testing.T) {
+	var buf bytes.Buffer
+	renderHelp(&buf, 4, 3)
+
+	cursor := regexp.MustCompile("\033\\[(\\d+);3H")
+	positions := cursor.FindAllStringSubmatch(buf.String(), -1)
+	lines := cursor.Split(buf.String(), -1)[1:]
+
+	want := []string{
+		"+ keys (any key to close) -----+",
+		"| q, ctrl-c - quit             |",
+		"| r - reset stats              |",
+		"| c - reset totals only        |",
+		"| w - reset window only        |",
+		"| k - increase rate by 100 RPS |",
+		"| j - decrease rate by 100 RPS |",
+		"| + - add 4 workers            |",
+		"| - - stop 4 workers           |",
+		"| p - counts or percentages    |",
+		"| h - histogram or heatmap     |",
+		"| s - latency or body sizes    |",
+		"| ? - show or hide this help   |",
+		"+------------------------------+",
+	}
+
+	if len(lines) != len(want) {
+		t.Fatalf("renderHelp() drew %d lines, want %d:\n%s", len(lines), len(want), strings.Join(lines, "\n"))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("renderHelp() line %d = %q, want %q", i, lines[i], want[i])
+		}
+		if row := fmt.Sprint(4 + i); positions[i][1] != row {
+			t.Errorf("renderHelp() line %d drawn at row %s, want %s", i, positions[i][1], row)
+		}
+	}
+}
+
+func Test_toggleHelp(t *testing.T) {
+	gen := layoutGen.Load()
+
+	toggleHelp()
+	if helpShown.Load() == 0 {
+		t.Error("toggleHelp() didn't show the help")
+	}
+
+	toggleHelp()
+	if helpShown.Load() != 0 {
+		t.Error("toggleHelp() didn't hide the help")
+	}
+
+	if got := layoutGen.Load(); got != gen+2 {
+		t.Errorf("toggleHelp() bumped layoutGen by %d, want a redraw on every toggle", got-gen)
+	}
+}
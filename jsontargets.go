@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// jsonTarget is a target in a -format json or jsonl targets file
+type jsonTarget struct {
+	Method     string            `json:"method"` // GET if empty
+	URL        string            `json:"url"`    // expanded like in the text format, count included
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`        // base64 with -base64body
+	BodyBase64 string            `json:"body_base64"` // always base64, instead of body
+	Tags       []string          `json:"tags"`
+	Proto      string            `json:"proto"` // h1, h2 or h2c
+}
+
+// readJSONTargets reads a JSON array of targets, the same as readTargets
+// does the text format
+func (trgt *targeter) readJSONTargets(reader io.Reader, base64body bool) error {
+	var targets []jsonTarget
+	dec := json.NewDecoder(reader)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&targets); err != nil {
+		return fmt.Errorf("reading JSON targets: %s", err)
+	}
+
+	for i, t := range targets {
+		requests, err := t.requests(base64body)
+		if err != nil {
+			return fmt.Errorf("target %d: %s", i, err)
+		}
+		if err := trgt.addRequests(requests); err != nil {
+			return fmt.Errorf("target %d: %s", i, err)
+		}
+	}
+
+	return nil
+}
+
+// readJSONLTargets reads targets as JSON objects, one per line, adding each
+// as soon as its line is read, so that reader may be a stream that goes on
+// while the run does. Blank lines are skipped.
+func (trgt *targeter) readJSONLTargets(reader io.Reader, base64body bool) error {
+	r := bufio.NewReader(reader)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var t jsonTarget
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.DisallowUnknownFields()
+			if decodeErr := dec.Decode(&t); decodeErr != nil {
+				return fmt.Errorf("line %d: %s", n, decodeErr)
+			}
+
+			requests, reqErr := t.requests(base64body)
+			if reqErr != nil {
+				return fmt.Errorf("line %d: %s", n, reqErr)
+			}
+			if addErr := trgt.addRequests(requests); addErr != nil {
+				return fmt.Errorf("line %d: %s", n, addErr)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// requests are the requests of t, one per url it expands to
+func (t jsonTarget) requests(base64body bool) ([]request, error) {
+	req, err := t.request(base64body)
+	if err != nil {
+		return nil, err
+	}
+
+	urls, err := parseUrl(req.url)
+	if err != nil {
+		return nil, err
+	}
+	req.endpoint = endpointName(req.method, strings.SplitN(req.url, " ", 2)[0])
+
+	requests := make([]request, len(urls))
+	for i, url := range urls {
+		requests[i] = req
+		requests[i].url = url
+	}
+
+	return requests, nil
+}
+
+// request is t as a request, before expanding its url
+func (t jsonTarget) request(base64body bool) (request, error) {
+	req := request{method: strings.ToUpper(t.Method), tags: t.Tags, body: []byte{}}
+	if req.method == "" {
+		req.method = http.MethodGet
+	}
+
+	var err error
+	if t.URL == "" {
+		return req, fmt.Errorf("no url")
+	}
+	if req.url, err = expandEnv(t.URL); err != nil {
+		return req, err
+	}
+
+	if t.Proto != "" {
+		if req.proto, err = lookupProto(t.Proto); err != nil {
+			return req, err
+		}
+	}
+
+	switch {
+	case t.BodyBase64 != "" && t.Body != "":
+		return req, fmt.Errorf("both body and body_base64")
+	case t.BodyBase64 != "" || base64body && t.Body != "":
+		encoded := t.BodyBase64 + t.Body
+		if req.body, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return req, err
+		}
+	case t.Body != "":
+		body, err := expandEnv(t.Body)
+		if err != nil {
+			return req, err
+		}
+		req.body = []byte(body)
+	}
+
+	if len(t.Headers) > 0 {
+		req.header = make(http.Header, len(t.Headers))
+		for key, value := range t.Headers {
+			if value, err = expandEnv(value); err != nil {
+				return req, err
+			}
+			req.header.Set(key, value)
+		}
+	}
+
+	return req, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSONTargets(t *testing.T) {
+	setupTestLayout(t)
+
+	tests := []struct {
+		name       string
+		targets    string
+		base64body bool
+		want       []request
+		wantErr    bool
+	}{
+		{
+			name: "header and base64 body",
+			targets: `[
+				{"method": "post", "url": "http://a/items", "headers": {"Authorization": "Bearer x"}, "body_base64": "aGVsbG8="},
+				{"url": "http://a/health", "tags": ["smoke"]}
+			]`,
+			want: []request{
+				{method: "POST", url: "http://a/items", body: []byte("hello"), header: http.Header{"Authorization": {"Bearer x"}}, endpoint: "POST http://a/items"},
+				{method: "GET", url: "http://a/health", body: []byte{}, tags: []string{"smoke"}, endpoint: "GET http://a/health"},
+			},
+		},
+		{
+			name:       "base64body",
+			targets:    `[{"method": "PUT", "url": "http://a/", "body": "e30="}]`,
+			base64body: true,
+			want: []request{
+				{method: "PUT", url: "http://a/", body: []byte("{}"), endpoint: "PUT http://a/"},
+			},
+		},
+		{
+			name:    "range and proto",
+			targets: `[{"url": "http://a/[1-2]", "proto": "h2c", "body": "x"}]`,
+			want: []request{
+				{method: "GET", url: "http://a/1", body: []byte("x"), proto: "h2", endpoint: "GET http://a/[1-2]"},
+				{method: "GET", url: "http://a/2", body: []byte("x"), proto: "h2", endpoint: "GET http://a/[1-2]"},
+			},
+		},
+		{
+			name:    "no url",
+			targets: `[{"method": "GET"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "both bodies",
+			targets: `[{"url": "http://a/", "body": "x", "body_base64": "eA=="}]`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			targets: `[{"url": "http://a/", "header": {"A": "b"}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "not an array",
+			targets: `GET http://a/`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trgt := &targeter{}
+			err := trgt.readJSONTargets(strings.NewReader(tt.targets), tt.base64body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readJSONTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(trgt.requests, tt.want) {
+				t.Errorf("readJSONTargets() = %+v, want %+v", trgt.requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSONLTargets(t *testing.T) {
+	setupTestLayout(t)
+
+	tests := []struct {
+		name    string
+		targets string
+		want    []request
+		wantErr bool
+	}{
+		{
+			name: "records",
+			targets: `{"method": "post", "url": "http://a/items", "body": "{\"id\": 1}"}
+
+{"url": "http://a/[1-2]", "tags": ["smoke"]}
+{"method": "DELETE", "url": "http://a/items/1", "headers": {"If-Match": "v1"}}`,
+			want: []request{
+				{method: "POST", url: "http://a/items", body: []byte(`{"id": 1}`), endpoint: "POST http://a/items"},
+				{method: "GET", url: "http://a/1", body: []byte{}, tags: []string{"smoke"}, endpoint: "GET http://a/[1-2]"},
+				{method: "GET", url: "http://a/2", body: []byte{}, tags: []string{"smoke"}, endpoint: "GET http://a/[1-2]"},
+				{method: "DELETE", url: "http://a/items/1", body: []byte{}, header: http.Header{"If-Match": {"v1"}}, endpoint: "DELETE http://a/items/1"},
+			},
+		},
+		{
+			name:    "bad record",
+			targets: "{\"url\": \"http://a/\"}\n{\"url\": \"http://a/\", \"header\": {}}\n",
+			want:    []request{{method: "GET", url: "http://a/", body: []byte{}, endpoint: "GET http://a/"}},
+			wantErr: true,
+		},
+		{
+			name:    "array",
+			targets: `[{"url": "http://a/"}]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trgt := &targeter{}
+			err := trgt.readJSONLTargets(strings.NewReader(tt.targets), false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readJSONLTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(trgt.requests, tt.want) {
+				t.Errorf("readJSONLTargets() = %+v, want %+v", trgt.requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSONLTargetsStream(t *testing.T) {
+	setupTestLayout(t)
+
+	r, w := io.Pipe()
+	trgt := &targeter{}
+	done := make(chan error)
+	go func() { done <- trgt.readJSONLTargets(r, false) }()
+
+	// the first record is added while the stream is still open
+	io.WriteString(w, `{"url": "http://a/first"}`+"\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st, ok := trgt.pick(0); ok {
+			if st.url != "http://a/first" {
+				t.Errorf("first streamed target = %s, want http://a/first", st.url)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("readJSONLTargets() never added the first record")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	io.WriteString(w, `{"url": "http://a/second"}`)
+	w.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(trgt.requests) != 2 {
+		t.Errorf("readJSONLTargets() added %d targets, want 2, the last without a newline", len(trgt.requests))
+	}
+}
+
+func Test_nextRequestTargetHeader(t *testing.T) {
+	setupTestLayout(t)
+
+	trgt := &targeter{
+		requests: []request{{method: "GET", url: "http://a/", body: []byte{}, header: http.Header{"X-Env": {"prod"}}}},
+		header:   http.Header{"X-Env": {"dev"}, "Accept": {"*/*"}},
+	}
+
+	req, err := trgt.nextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Values("X-Env"); !reflect.DeepEqual(got, []string{"prod"}) {
+		t.Errorf("X-Env = %q, target header should replace -H", got)
+	}
+	if got := req.Header.Get("Accept"); got != "*/*" {
+		t.Errorf("Accept = %q, want the -H header", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// runLabels are the -label pairs of the run, attached to its summaries and
+// stats. Read only once the run starts.
+var runLabels labelFlags
+
+// labelKey is what label keys look like, as in most metrics systems
+var labelKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedLabels are the keys of summary lines and the columns of the CSV
+// report and summary, which labels can't take
+var reservedLabels = map[string]bool{
+	"elapsed": true, "sent": true, "recv": true, "ok": true, "err": true, "err_rate": true,
+	"rps": true, "p50": true, "p90": true, "p99": true, "ended": true, "timestamp": true,
+	"p50_ms": true, "p90_ms": true, "p99_ms": true, "error_rate": true,
+	"elapsed_seconds": true, "received": true, "errors": true, "invalid": true, "canceled": true,
+}
+
+// labelFlags are repeated key=value labels
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, key := range l.keys() {
+		pairs = append(pairs, key+"="+l[key])
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+func (l labelFlags) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || !labelKey.MatchString(key) {
+		return fmt.Errorf("expected key=value with a key of letters, digits and _, got %q", value)
+	}
+	if reservedLabels[key] {
+		return fmt.Errorf("label %s is taken by the summary", key)
+	}
+	if val == "" || strings.ContainsAny(val, " \t\r\n") {
+		return fmt.Errorf("label %s needs a value without spaces, got %q", key, val)
+	}
+
+	l[key] = val
+	return nil
+}
+
+// keys are the label keys, sorted
+func (l labelFlags) keys() []string {
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_labelFlags(t *testing.T) {
+	l := labelFlags{}
+	for _, valid := range []string{"env=staging", "build=1.4.2-rc1", "query=a=b"} {
+		if err := l.Set(valid); err != nil {
+			t.Errorf("Set(%q) error = %v", valid, err)
+		}
+	}
+	if want := (labelFlags{"env": "staging", "build": "1.4.2-rc1", "query": "a=b"}); !reflect.DeepEqual(l, want) {
+		t.Errorf("labelFlags = %v, want %v", l, want)
+	}
+	if got, want := l.String(), "build=1.4.2-rc1 env=staging query=a=b"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	for _, invalid := range []string{"env", "=staging", "env=", "my-env=staging", "env=staging area", "p99=fast", "1env=x"} {
+		if err := l.Set(invalid); err == nil {
+			t.Errorf("Set(%q) expected an error", invalid)
+		}
+	}
+}
+
+func Test_reservedLabelsColumns(t *testing.T) {
+	for _, header := range [][]string{csvReportHeader, csvSummaryHeader} {
+		for _, column := range header {
+			if !reservedLabels[column] {
+				t.Errorf("column %s can be taken by a label", column)
+			}
+		}
+	}
+}
+
+func Test_labelsPropagate(t *testing.T) {
+	setupTestLayout(t)
+	runLabels = labelFlags{"env": "staging", "scenario": "checkout"}
+	defer func() { runLabels = nil }()
+
+	s := buildSummary(time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC))
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"labels":{"env":"staging","scenario":"checkout"}`) {
+		t.Errorf("summary JSON = %s, want the labels", data)
+	}
+
+	if line := s.String(); !strings.HasSuffix(line, " env=staging scenario=checkout") {
+		t.Errorf("summary line = %q, want it to end in the labels", line)
+	}
+	s.Ended = "duration"
+	if line := s.String(); !strings.HasSuffix(line, " env=staging scenario=checkout ended=duration") {
+		t.Errorf("summary line = %q, want the labels before ended=", line)
+	}
+
+	var out lockedBuffer
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		csvReporter(&out, true, 10*time.Millisecond, quit)
+		close(done)
+	}()
+	time.Sleep(25 * time.Millisecond)
+	close(quit)
+	<-done
+
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]string{}, csvReportHeader...), "env", "scenario"); !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("csvReporter() header = %v, want %v", rows[0], want)
+	}
+	if len(rows) < 2 || !reflect.DeepEqual(rows[1][len(csvReportHeader):], []string{"staging", "checkout"}) {
+		t.Errorf("csvReporter() rows = %v, want the labels in every row", rows[1:])
+	}
+
+	if got := buildWindowStats(time.Now()).Labels; !reflect.DeepEqual(got, runLabels) {
+		t.Errorf("/stats labels = %v, want %v", got, runLabels)
+	}
+
+	runLabels = nil
+	if data, _ := json.Marshal(buildSummary(time.Now())); strings.Contains(string(data), "labels") {
+		t.Errorf("summary JSON without labels = %s, want them left out", data)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runLimits ends a run at the first of its limits it reaches
+type runLimits struct {
+	duration time.Duration   // 0 for no time limit
+	requests <-chan struct{} // closed once -requests are sent, nil for no limit
+	errors   int64           // 0 for no limit on the errors
+	failed   func() int64    // errors so far
+	poll     time.Duration   // how often failed is checked
+}
+
+// requestBudget is what is left of -requests. Every request takes its share
+// of it right before going out, so no more than -requests are ever sent.
+type requestBudget struct {
+	left counter
+	once sync.Once
+	done chan struct{} // closed once the last request is taken
+}
+
+// requestsLeft is the budget of -requests, nil for no limit
+var requestsLeft *requestBudget
+
+func newRequestBudget(n int64) *requestBudget {
+	b := &requestBudget{done: make(chan struct{})}
+	b.left.Store(n)
+
+	return b
+}
+
+// take takes a request from the budget, and reports whether there was one
+// left
+func (b *requestBudget) take() bool {
+	left := b.left.Add(-1)
+	if left == 0 {
+		b.once.Do(func() { close(b.done) })
+	}
+
+	return left >= 0
+}
+
+// runEnd is the limit that ended the run, if any
+var runEnd atomic.Value
+
+// endReason is the limit that ended the run, or "" if none did
+func endReason() string {
+	reason, _ := runEnd.Load().(string)
+	return reason
+}
+
+// supervise waits for the first limit to be reached, and gives its name, or
+// "" if quit is closed first
+func (l runLimits) supervise(quit <-chan struct{}) string {
+	var deadline <-chan time.Time
+	if l.duration > 0 {
+		timer := time.NewTimer(l.duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	var poll <-chan time.Time
+	if l.errors > 0 {
+		tck := time.NewTicker(l.poll)
+		defer tck.Stop()
+		poll = tck.C
+	}
+
+	for {
+		select {
+		case <-deadline:
+			return "duration"
+		case <-l.requests:
+			return "requests"
+		case <-poll:
+			if l.failed() >= l.errors {
+				return "errors"
+			}
+		case <-quit:
+			return ""
+		}
+	}
+}
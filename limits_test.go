@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_runLimits(t *testing.T) {
+	sent := func(n int) <-chan struct{} {
+		b := newRequestBudget(5)
+		for i := 0; i < n; i++ {
+			b.take()
+		}
+		return b.done
+	}
+
+	tests := []struct {
+		name   string
+		limits runLimits
+		want   string
+	}{
+		{"duration first", runLimits{duration: 50 * time.Millisecond, requests: sent(4)}, "duration"},
+		{"requests first", runLimits{duration: time.Minute, requests: sent(5)}, "requests"},
+		{"duration alone", runLimits{duration: 20 * time.Millisecond}, "duration"},
+		{"requests alone", runLimits{requests: sent(5)}, "requests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.supervise(make(chan struct{})); got != tt.want {
+				t.Errorf("supervise() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	quit := make(chan struct{})
+	close(quit)
+	if got := (runLimits{duration: time.Minute}).supervise(quit); got != "" {
+		t.Errorf("supervise() after quit = %q, want no limit reached", got)
+	}
+}
+
+func Test_requestBudget(t *testing.T) {
+	const workers, limit = 16, 1000
+
+	b := newRequestBudget(limit)
+	var taken counter
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 2*limit/workers; j++ {
+				if b.take() {
+					taken.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := taken.Load(); got != limit {
+		t.Errorf("take() gave %d requests, want %d", got, limit)
+	}
+	select {
+	case <-b.done:
+	default:
+		t.Error("done not closed once the budget is spent")
+	}
+}
+
+func Test_attackRequestBudget(t *testing.T) {
+	setupTestLayout(t)
+	defer func() { requestsLeft = nil }()
+
+	var served counter
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.Add(1)
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{requests: []request{{method: "GET", url: srv.URL}}}
+	requestsLeft = newRequestBudget(7)
+
+	ch := make(chan time.Time, 20)
+	for i := 0; i < cap(ch); i++ {
+		ch <- time.Now()
+	}
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attack(trgt, srv.Client(), ch, quit)
+		}()
+	}
+
+	for len(ch) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	<-requestsLeft.done
+	close(quit)
+	wg.Wait()
+
+	if got := served.Load(); got != 7 {
+		t.Errorf("server got %d requests, want -requests 7", got)
+	}
+}
+
+func Test_runLimitsErrors(t *testing.T) {
+	setupTestLayout(t)
+
+	trgt := &targeter{}
+	request := httptest.NewRequest("GET", "http://127.0.0.1/", nil)
+	base := errorsTotal.Load()
+	limits := runLimits{
+		duration: time.Minute,
+		errors:   5,
+		failed:   func() int64 { return errorsTotal.Load() - base },
+		poll:     time.Millisecond,
+	}
+
+	reason := make(chan string, 1)
+	go func() { reason <- limits.supervise(make(chan struct{})) }()
+
+	// fewer errors than the limit, and responses that are ok, don't end the run
+	ok := &http.Response{StatusCode: http.StatusOK}
+	for i := 0; i < 4; i++ {
+		countResponse(trgt, request, nil, nil, errors.New("connection refused"), time.Now(), time.Millisecond)
+		countResponse(trgt, request, ok, nil, nil, time.Now(), time.Millisecond)
+	}
+	select {
+	case got := <-reason:
+		t.Fatalf("supervise() = %q after 4 errors, want it to go on", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	countResponse(trgt, request, &http.Response{StatusCode: http.StatusBadGateway}, nil, nil, time.Now(), time.Millisecond)
+	select {
+	case got := <-reason:
+		if got != "errors" {
+			t.Errorf("supervise() = %q, want errors", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("supervise() didn't end the run after 5 errors")
+	}
+}
+
+func Test_summaryEnded(t *testing.T) {
+	s := Summary{Time: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), Ended: "requests"}
+	if got := s.String(); got[len(got)-len(" ended=requests"):] != " ended=requests" {
+		t.Errorf("String() = %q, want it to end in ended=requests", got)
+	}
+}
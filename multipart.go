@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// multipartForm is a multipart/form-data body, built once so that every
+// request carries the same boundary in its body and Content-Type
+type multipartForm struct {
+	body        []byte
+	contentType string
+}
+
+// newMultipartForm builds a form from name=value fields and name=path files
+func newMultipartForm(fields, files []string) (*multipartForm, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, field := range fields {
+		name, value, err := splitFormPair(field)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.WriteField(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, file := range files {
+		name, path, err := splitFormPair(file)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		part, err := w.CreateFormFile(name, filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		part.Write(content)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &multipartForm{body: buf.Bytes(), contentType: w.FormDataContentType()}, nil
+}
+
+// urlencodedForm is an application/x-www-form-urlencoded body, encoded anew
+// for every request so that tokens in its values can be expanded
+type urlencodedForm struct {
+	names, values []string // in the order given
+}
+
+// newURLEncodedForm builds a form from name=value fields
+func newURLEncodedForm(fields []string) (*urlencodedForm, error) {
+	f := &urlencodedForm{}
+	for _, field := range fields {
+		name, value, err := splitFormPair(field)
+		if err != nil {
+			return nil, err
+		}
+		f.names = append(f.names, name)
+		f.values = append(f.values, value)
+	}
+
+	return f, nil
+}
+
+// encode is the body of a request, with the tokens in the values expanded
+// by tokens. Fields keep their order, unlike with url.Values.
+func (f *urlencodedForm) encode(tokens *tokenExpander) []byte {
+	var b strings.Builder
+	for i, name := range f.names {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(name))
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(tokens.expand(f.values[i])))
+	}
+
+	return []byte(b.String())
+}
+
+func splitFormPair(pair string) (string, string, error) {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("expected name=value, got %q", pair)
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_multipartForm(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "avatar.png")
+	if err := os.WriteFile(path, []byte("\x89PNG fake"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := newMultipartForm([]string{"user=jane", "note=a=b"}, []string{"avatar=" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type parsed struct {
+		user, note, filename, content string
+		err                           error
+	}
+	got := make(chan parsed, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p parsed
+		if p.err = r.ParseMultipartForm(1 << 20); p.err == nil {
+			p.user, p.note = r.FormValue("user"), r.FormValue("note")
+
+			f, header, err := r.FormFile("avatar")
+			if err == nil {
+				content, _ := ioutil.ReadAll(f)
+				p.filename, p.content = header.Filename, string(content)
+				f.Close()
+			}
+			p.err = err
+		}
+		got <- p
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{requests: []request{{method: "POST", url: srv.URL, body: []byte("ignored")}}, form: form}
+	for i := 0; i < 2; i++ {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		p := <-got
+		if p.err != nil {
+			t.Fatalf("server couldn't parse the form: %s", p.err)
+		}
+		if p.user != "jane" || p.note != "a=b" {
+			t.Errorf("server got fields user=%q note=%q, want jane and a=b", p.user, p.note)
+		}
+		if p.filename != "avatar.png" || p.content != "\x89PNG fake" {
+			t.Errorf("server got file %q with %q, want avatar.png with its content", p.filename, p.content)
+		}
+	}
+}
+
+func Test_newMultipartFormErrors(t *testing.T) {
+	if _, err := newMultipartForm([]string{"novalue"}, nil); err == nil {
+		t.Error("newMultipartForm() accepted a field without '='")
+	}
+	if _, err := newMultipartForm(nil, []string{"file=/does/not/exist"}); err == nil {
+		t.Error("newMultipartForm() accepted a missing file")
+	}
+}
+
+func Test_urlencodedForm(t *testing.T) {
+	form, err := newURLEncodedForm([]string{"user=jane doe", "id={{seq}}", "note=a=b&c", "token={{uuid}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trgt := &targeter{requests: []request{{method: "POST", url: "http://127.0.0.1:5000/login", body: []byte("ignored")}}, urlencoded: form}
+	trgt.seq.Store(41)
+	for i := 0; i < 2; i++ {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ct := req.Header.Get("Content-Type"); ct != "application/x-www-form-urlencoded" {
+			t.Errorf("Content-Type = %q, want application/x-www-form-urlencoded", ct)
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+		if req.ContentLength != int64(len(body)) {
+			t.Errorf("ContentLength = %d, want the %d of the body", req.ContentLength, len(body))
+		}
+		want := "user=jane+doe&id=" + strconv.Itoa(41+i) + "&note=a%3Db%26c&token="
+		if !strings.HasPrefix(string(body), want) || len(body) != len(want)+36 {
+			t.Errorf("body = %q, want %q and a UUID", body, want)
+		}
+
+		// and it reads back as the fields given
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err := req.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if req.PostForm.Get("user") != "jane doe" || req.PostForm.Get("note") != "a=b&c" {
+			t.Errorf("form parses to %v, want the fields given", req.PostForm)
+		}
+	}
+
+	if _, err := newURLEncodedForm([]string{"=value"}); err == nil {
+		t.Error("newURLEncodedForm() accepted a field without a name")
+	}
+}
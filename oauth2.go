@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauth2Retry is how long to wait before trying again after a failed
+// token refresh, keeping the current token meanwhile
+const oauth2Retry = time.Second
+
+// oauth2Source gets bearer tokens from an OAuth2 token endpoint with the
+// client credentials grant, and refreshes them before they expire. Every
+// worker reads the current token, so it is guarded by mu.
+type oauth2Source struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu      sync.RWMutex
+	current string
+}
+
+// oauth2Token is the response of a token endpoint
+type oauth2Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"` // seconds, 0 if it doesn't say
+}
+
+// token is the current access token
+func (s *oauth2Source) token() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current
+}
+
+// refresh fetches a new token, replacing the current one, and returns when
+// to refresh it next: at nine tenths of its lifetime, or the zero time if it
+// doesn't expire
+func (s *oauth2Source) refresh(now time.Time) (time.Time, error) {
+	t, err := s.fetch()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var next time.Time
+	if t.ExpiresIn > 0 {
+		next = now.Add(time.Duration(t.ExpiresIn) * time.Second * 9 / 10)
+	}
+
+	s.mu.Lock()
+	s.current = t.AccessToken
+	s.mu.Unlock()
+
+	return next, nil
+}
+
+// fetch asks the token endpoint for a token, authenticating as the client
+// with HTTP basic auth
+func (s *oauth2Source) fetch() (oauth2Token, error) {
+	var t oauth2Token
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return t, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return t, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return t, fmt.Errorf("token endpoint %s: %s: %s", s.tokenURL, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return t, fmt.Errorf("token endpoint %s: %s", s.tokenURL, err)
+	}
+	if t.AccessToken == "" {
+		return t, errors.New("token endpoint " + s.tokenURL + " returned no access_token")
+	}
+	if t.TokenType != "" && !strings.EqualFold(t.TokenType, "bearer") {
+		return t, fmt.Errorf("token endpoint %s returned a %s token, want a bearer token", s.tokenURL, t.TokenType)
+	}
+
+	return t, nil
+}
+
+// run refreshes the token at next, and then before every expiry, until quit
+// is closed. A failed refresh is retried after oauth2Retry, while requests
+// go on with the token they have.
+func (s *oauth2Source) run(next time.Time, quit <-chan struct{}) {
+	if next.IsZero() {
+		return
+	}
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+
+	for {
+		select {
+		case now := <-timer.C:
+			next, err := s.refresh(now)
+			if err != nil {
+				timer.Reset(oauth2Retry)
+				continue
+			}
+			if next.IsZero() {
+				return
+			}
+			timer.Reset(time.Until(next))
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_oauth2SourceRefresh(t *testing.T) {
+	var issued counter
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"Bearer","expires_in":1}`, issued.Add(1))
+	}))
+	defer srv.Close()
+
+	s := &oauth2Source{tokenURL: srv.URL, clientID: "client", clientSecret: "s3cret", client: http.DefaultClient}
+	now := time.Now()
+	next, err := s.refresh(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(900 * time.Millisecond); !next.Equal(want) {
+		t.Errorf("refresh() = next refresh at %s, want %s, before the token expires", next, want)
+	}
+
+	trgt := &targeter{requests: []request{{method: "GET", url: "http://a/"}}, oauth2: s}
+	req, err := trgt.nextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer tok-1" {
+		t.Errorf("Authorization = %q, want the first token", got)
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(next, quit)
+	}()
+
+	// workers read the token while it is refreshed
+	deadline := time.Now().Add(5 * time.Second)
+	for s.token() == "tok-1" && time.Now().Before(deadline) {
+		if _, err := trgt.nextRequest(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(quit)
+	<-done
+
+	if got := s.token(); got != "tok-2" {
+		t.Errorf("token() = %q after the first one expired, want it refreshed to tok-2", got)
+	}
+}
+
+func Test_oauth2SourceFetchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		status   int
+	}{
+		{"rejected", `{"error":"invalid_client"}`, http.StatusUnauthorized},
+		{"no token", `{"token_type":"Bearer"}`, http.StatusOK},
+		{"not bearer", `{"access_token":"x","token_type":"mac"}`, http.StatusOK},
+		{"not json", `access_token=x`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.response)
+			}))
+			defer srv.Close()
+
+			s := &oauth2Source{tokenURL: srv.URL, clientID: "client", clientSecret: "s3cret", client: http.DefaultClient}
+			if _, err := s.refresh(time.Now()); err == nil {
+				t.Errorf("refresh() = %q, want an error", s.token())
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// palette holds the escape sequences the screen is drawn with. Empty
+// sequences draw plain text.
+type palette struct {
+	gradient []string // bucket colors, from fastest to slowest
+
+	ok, bad, rate, warn, invalid string
+	degraded                     string // ok, but slower than -degraded
+	slo                          string // the row of the SLO bucket
+}
+
+var palettes = map[string]*palette{
+	"256": {
+		gradient: []string{
+			"\033[38;5;46m", "\033[38;5;47m", "\033[38;5;48m", "\033[38;5;49m", // green
+			"\033[38;5;149m", "\033[38;5;148m", "\033[38;5;179m", "\033[38;5;176m", // yellow
+			"\033[38;5;169m", "\033[38;5;168m", "\033[38;5;197m", "\033[38;5;196m", // red
+		},
+		ok: "\033[32m", bad: "\033[31m", rate: "\033[96m", warn: "\033[33m", invalid: "\033[35m", slo: "\033[4m",
+		degraded: "\033[38;5;214m",
+	},
+	"16": {
+		gradient: []string{
+			"\033[92m", "\033[32m", // green
+			"\033[93m", "\033[33m", // yellow
+			"\033[91m", "\033[31m", // red
+		},
+		ok: "\033[32m", bad: "\033[31m", rate: "\033[96m", warn: "\033[33m", invalid: "\033[35m", slo: "\033[4m",
+		degraded: "\033[93m",
+	},
+	"mono": {},
+}
+
+// screenPalette is the palette selected with -palette
+var screenPalette = palettes["256"]
+
+func lookupPalette(name string) (*palette, error) {
+	p, ok := palettes[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid -palette %q, must be one of 256, 16 or mono", name)
+	}
+
+	return p, nil
+}
+
+// paint wraps text in color, resetting afterwards
+func paint(color, text string) string {
+	if color == "" {
+		return text
+	}
+
+	return color + text + "\033[0m"
+}
+
+// bucketColor spreads the gradient over the buckets
+func (p *palette) bucketColor(bkt, buckets uint) string {
+	if len(p.gradient) == 0 {
+		return ""
+	}
+
+	colorMultiplier := float64(len(p.gradient)) / float64(buckets)
+	return p.gradient[int(float64(bkt)*colorMultiplier)]
+}
+
+// degradedCellWidth is the room the degraded count takes in histogram rows,
+// which only show it with -degraded
+const degradedCellWidth = len("/000000")
+
+// renderBucket writes one histogram row: its label, the ok/bad counts, as
+// percentages of total if it is set, and a bar of widthOk '*' and widthBad
+// 'E', padded to barWidth. With -degraded, the degraded count goes between
+// them, and widthDegraded '~' in its own color between the bar's. The row of
+// the SLO bucket is highlighted and marked, separating it from the slower
+// ones.
+func renderBucket(w io.Writer, p *palette, label string, bkt, buckets uint, ok, degraded, bad, total int64, widthOk, widthDegraded, widthBad, barWidth int, slo bool) {
+	cell := func(n int64) string {
+		if total > 0 {
+			return fmt.Sprintf("%5.1f%%", 100*float64(n)/float64(total))
+		}
+		return fmt.Sprintf("%6d", n)
+	}
+
+	counts := paint(p.ok, cell(ok))
+	if degradedMs > 0 {
+		counts += "/" + paint(p.degraded, cell(degraded))
+	}
+	counts += "/" + paint(p.bad, cell(bad))
+
+	color := p.bucketColor(bkt, buckets)
+	bar := ""
+	if widthBad > 0 {
+		bar += paint(color, strings.Repeat("E", widthBad))
+	}
+	if widthDegraded > 0 {
+		bar += paint(p.degraded, strings.Repeat("~", widthDegraded))
+	}
+	bar += paint(color, strings.Repeat("*", widthOk)+strings.Repeat(" ", barWidth-widthOk-widthDegraded-widthBad))
+
+	row := fmt.Sprintf("%10s %2s: [%s] %s", label, displayUnit.name, counts, bar)
+
+	if slo {
+		fmt.Fprintf(w, "%s < SLO\r\n", paint(p.slo, row))
+		return
+	}
+
+	fmt.Fprintf(w, "%s \r\n", row)
+}
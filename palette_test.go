@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_renderMonochrome(t *testing.T) {
+	defer func(p *palette) { screenPalette = p }(screenPalette)
+
+	st := statusLine{sent: 10, recv: 8, rate: 5, desired: 10, dropped: 1, invalid: 2, workers: 20}
+	st.responses[200] = 6
+	st.responses[500] = 2
+
+	tests := []struct {
+		palette   string
+		wantColor bool
+	}{
+		{"256", true},
+		{"16", true},
+		{"mono", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.palette, func(t *testing.T) {
+			p, err := lookupPalette(tt.palette)
+			if err != nil {
+				t.Fatal(err)
+			}
+			screenPalette = p
+
+			var buf bytes.Buffer
+			renderStatusLine(&buf, st, 200, false)
+			for bkt := uint(0); bkt < 10; bkt++ {
+				renderBucket(&buf, p, "1-2", bkt, 10, 6, 1, 2, 0, 3, 1, 1, 20, bkt == 4)
+			}
+
+			if got := strings.Contains(buf.String(), "\033"); got != tt.wantColor {
+				t.Errorf("palette %s emits escape codes: %v, want %v\n%q", tt.palette, got, tt.wantColor, buf.String())
+			}
+		})
+	}
+}
+
+func Test_renderBucket(t *testing.T) {
+	var buf bytes.Buffer
+	renderBucket(&buf, palettes["mono"], "1-2", 0, 10, 6, 0, 2, 0, 3, 0, 1, 6, false)
+	renderBucket(&buf, palettes["mono"], "2-4", 1, 10, 1, 0, 0, 0, 1, 0, 0, 6, true)
+
+	want := "       1-2 ms: [     6/     2] E***   \r\n" +
+		"       2-4 ms: [     1/     0] *      < SLO\r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("renderBucket() = %q, want %q", got, want)
+	}
+
+	degradedMs = 100
+	defer func() { degradedMs = 0 }()
+
+	buf.Reset()
+	renderBucket(&buf, palettes["mono"], "1-2", 0, 10, 6, 4, 2, 0, 3, 2, 1, 8, false)
+
+	want = "       1-2 ms: [     6/     4/     2] E~~***   \r\n"
+	if got := buf.String(); got != want {
+		t.Errorf("renderBucket() with -degraded = %q, want %q", got, want)
+	}
+}
+
+func Test_bucketColor(t *testing.T) {
+	p := palettes["256"]
+	const buckets = 20
+
+	if got := p.bucketColor(0, buckets); got != p.gradient[0] {
+		t.Errorf("bucketColor(0) = %q, want the first color %q", got, p.gradient[0])
+	}
+	if got := p.bucketColor(buckets-1, buckets); got != p.gradient[len(p.gradient)-1] {
+		t.Errorf("bucketColor(%d) = %q, want the last color %q", buckets-1, got, p.gradient[len(p.gradient)-1])
+	}
+	if got := palettes["mono"].bucketColor(5, buckets); got != "" {
+		t.Errorf("mono bucketColor() = %q, want none", got)
+	}
+}
+
+func Test_lookupPalette(t *testing.T) {
+	if _, err := lookupPalette("8"); err == nil {
+		t.Error("lookupPalette(\"8\") succeeded, want an error")
+	}
+}
+
+func Test_renderHistogram(t *testing.T) {
+	setupTestLayout(t)
+
+	tOk := make([]int64, buckets)
+	tBad := make([]int64, buckets)
+	tOk[1] = 30
+	tOk[2], tBad[2] = 10, 10
+
+	tests := []struct {
+		name     string
+		percent  bool
+		wantRow1 string
+		wantRow2 string
+	}{
+		{
+			name:     "counts scaled to the longest bar",
+			wantRow1: "[    30/     0] ********************",
+			wantRow2: "[    10/    10] EEEEEE******        ",
+		},
+		{
+			name:     "percentages of all requests",
+			percent:  true,
+			wantRow1: "[ 60.0%/  0.0%] ************        ",
+			wantRow2: "[ 20.0%/ 20.0%] EEEE****            ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			layoutMu.RLock()
+			renderHistogram(&buf, palettes["mono"], tOk, make([]int64, len(tOk)), tBad, len(tOk), 20, -1, tt.percent)
+			layoutMu.RUnlock()
+
+			rows := strings.Split(buf.String(), "\r\n")
+			if len(rows) != int(buckets)+1 {
+				t.Fatalf("renderHistogram() wrote %d rows, want %d", len(rows)-1, buckets)
+			}
+			if !strings.Contains(rows[1], tt.wantRow1) {
+				t.Errorf("renderHistogram() row 1 = %q, want it to contain %q", rows[1], tt.wantRow1)
+			}
+			if !strings.Contains(rows[2], tt.wantRow2) {
+				t.Errorf("renderHistogram() row 2 = %q, want it to contain %q", rows[2], tt.wantRow2)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"time"
+)
+
+// uploads of requests with a body: the time spent writing them and their
+// whole latency, in nanoseconds, and how many were timed
+var uploadNanos, uploadLatencyNanos, uploadsTimed counter
+
+// requestPhases are the times a request got to each phase, as UnixNano, 0
+// until it did. The transport calls the trace hooks from its own goroutines,
+// hence the counters.
+type requestPhases struct {
+	gotConn, wroteRequest, firstByte counter
+}
+
+// durations splits a request that started at start and ended at end into
+// writing it out, waiting for the response and reading that, false if it
+// didn't get that far. Connecting and waiting for a connection count as
+// waiting.
+func (p *requestPhases) durations(start, end time.Time) (upload, wait, download time.Duration, ok bool) {
+	gotConn, wrote, first := p.gotConn.Load(), p.wroteRequest.Load(), p.firstByte.Load()
+	if gotConn == 0 || wrote == 0 || first == 0 {
+		return 0, 0, 0, false
+	}
+
+	// servers may answer before they read the whole request
+	if first < wrote {
+		first = wrote
+	}
+
+	upload = time.Duration(wrote - gotConn)
+	wait = time.Duration(first-start.UnixNano()) - upload
+	download = time.Duration(end.UnixNano() - first)
+
+	return upload, wait, download, true
+}
+
+// recordUpload adds the upload of a request with a body, from start to end,
+// to the totals
+func recordUpload(p *requestPhases, start, end time.Time) {
+	upload, _, _, ok := p.durations(start, end)
+	if !ok {
+		return
+	}
+
+	uploadNanos.Add(int64(upload))
+	uploadLatencyNanos.Add(int64(end.Sub(start)))
+	uploadsTimed.Add(1)
+}
+
+// uploadStats is the mean upload time in ms of timed requests and its
+// percentage of their latency
+func uploadStats(nanos, latencyNanos, timed int64) (meanMs, percent float64) {
+	if timed == 0 {
+		return 0, 0
+	}
+
+	meanMs = float64(nanos) / float64(timed) / float64(time.Millisecond)
+	if latencyNanos > 0 {
+		percent = 100 * float64(nanos) / float64(latencyNanos)
+	}
+
+	return meanMs, percent
+}
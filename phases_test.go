@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_attackUploadTime(t *testing.T) {
+	setupTestLayout(t)
+
+	// a server reading the body slowly, then answering right away
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 64<<10)
+		for {
+			if _, err := r.Body.Read(buf); err != nil {
+				break
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{requests: []request{
+		{method: "POST", url: srv.URL, body: bytes.Repeat([]byte("x"), 16<<20)},
+		{method: "GET", url: srv.URL},
+	}}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, http.DefaultClient, ch, quit)
+	}()
+	for range trgt.requests {
+		ch <- time.Now()
+	}
+	close(quit)
+	<-done
+
+	// only the POST has a body to upload
+	if got := uploadsTimed.Load(); got != 1 {
+		t.Fatalf("attack() timed %d uploads, want 1", got)
+	}
+	ms, pct := uploadStats(uploadNanos.Load(), uploadLatencyNanos.Load(), uploadsTimed.Load())
+	if ms < 100 || pct < 50 {
+		t.Errorf("upload took %.1fms, %.0f%% of the latency, want most of the 16MB sent at 64KB per 2ms", ms, pct)
+	}
+}
+
+func Test_requestPhasesDurations(t *testing.T) {
+	start := time.Unix(0, 0)
+	at := func(ms int64) counter {
+		var c counter
+		c.Store(start.Add(time.Duration(ms) * time.Millisecond).UnixNano())
+		return c
+	}
+
+	p := &requestPhases{gotConn: at(5), wroteRequest: at(30), firstByte: at(80)}
+	upload, wait, download, ok := p.durations(start, start.Add(100*time.Millisecond))
+	if !ok || upload != 25*time.Millisecond || wait != 55*time.Millisecond || download != 20*time.Millisecond {
+		t.Errorf("durations() = %s, %s, %s, %v, want 25ms writing, 55ms waiting with connecting, 20ms reading", upload, wait, download, ok)
+	}
+
+	// an early answer doesn't make waiting negative
+	p = &requestPhases{gotConn: at(0), wroteRequest: at(50), firstByte: at(10)}
+	if _, wait, _, _ := p.durations(start, start.Add(60*time.Millisecond)); wait != 0 {
+		t.Errorf("durations() waited %s for a response before the upload ended, want 0", wait)
+	}
+
+	if _, _, _, ok := (&requestPhases{gotConn: at(0)}).durations(start, start); ok {
+		t.Error("durations() of a request never written = ok, want false")
+	}
+
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// pipelineGather is how long a pipelining worker waits for more ticks to
+// fill a batch once it has the first one
+const pipelineGather = 10 * time.Millisecond
+
+// pipeliner sends GETs pipelined over HTTP/1.1: a batch of up to depth
+// requests is written to a host's connection at once, before reading any of
+// the responses. Go's client never pipelines, so like rawLengthTransport this
+// writes HTTP/1.1 by hand, but keeps one connection per host for the next
+// batch. Every worker has a pipeliner of its own.
+type pipeliner struct {
+	depth int
+	cfg   transportConfig
+	conns map[string]*pipelineConn // by host
+}
+
+type pipelineConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newPipeliner(depth int, cfg transportConfig) *pipeliner {
+	return &pipeliner{depth: depth, cfg: cfg, conns: make(map[string]*pipelineConn)}
+}
+
+// pipelineable checks that all requests can be pipelined, which is only
+// safe for GETs
+func pipelineable(requests []request) error {
+	for _, req := range requests {
+		if req.method != http.MethodGet {
+			return fmt.Errorf("-pipeline only sends GETs, got %s %s", req.method, req.url)
+		}
+		if req.proto == "h2" {
+			return fmt.Errorf("-pipeline only speaks HTTP/1.1, got #proto=h2 for %s", req.url)
+		}
+	}
+
+	return nil
+}
+
+// pipelineAttack is attack for -pipeline. Every tick is still one request,
+// but the requests of the ticks a worker gathers go out as one batch. Their
+// latencies are all counted from when the batch was written.
+func pipelineAttack(trgt *targeter, p *pipeliner, ch <-chan time.Time, quit <-chan struct{}) {
+	defer p.close()
+
+	for {
+		select {
+		case <-ch:
+		case <-quit:
+			return
+		}
+
+		n := 1 + p.gather(ch, quit)
+		var batch []*http.Request
+		for i := 0; i < n; i++ {
+			if request, err := trgt.nextRequest(); err == nil && (requestsLeft == nil || requestsLeft.take()) {
+				batch = append(batch, request)
+			}
+		}
+
+		sent := p.send(trgt, batch)
+		ticksHandled.Add(int64(n))
+		if !sent {
+			return
+		}
+
+		if pause := thinkTime.random(); pause > 0 {
+			select {
+			case <-time.After(pause):
+			case <-quit:
+				return
+			}
+		}
+	}
+}
+
+// gather takes up to depth-1 more ticks, waiting at most pipelineGather
+// for them, and returns how many it got
+func (p *pipeliner) gather(ch <-chan time.Time, quit <-chan struct{}) int {
+	timer := time.NewTimer(pipelineGather)
+	defer timer.Stop()
+
+	n := 0
+	for n < p.depth-1 {
+		select {
+		case <-ch:
+			n++
+		case <-timer.C:
+			return n
+		case <-quit:
+			return n
+		}
+	}
+
+	return n
+}
+
+// send pipelines batch to the hosts it is for, one host after the other. It
+// returns false if the requests were abandoned after quitting.
+func (p *pipeliner) send(trgt *targeter, batch []*http.Request) bool {
+	var hosts []string
+	byHost := make(map[string][]*http.Request)
+	for _, request := range batch {
+		host := request.URL.Host
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], request)
+	}
+
+	for _, host := range hosts {
+		if !p.sendHost(trgt, byHost[host]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sendHost writes all requests to the connection to their host, then reads
+// their responses in order. A failure fails the requests still waiting for
+// a response, and the connection is dropped for a new one next time.
+func (p *pipeliner) sendHost(trgt *targeter, requests []*http.Request) bool {
+	requestsSent.Add(int64(len(requests)))
+	requestsTotal.Add(int64(len(requests)))
+
+	u := requests[0].URL
+	ctx, cancel := context.WithTimeout(inflightCtx, timeoutFor(http.MethodGet))
+	defer cancel()
+
+	conn, err := p.conn(ctx, u)
+	if err == nil {
+		// the requests after the first one share its connection
+		connsReused.Add(int64(len(requests) - 1))
+
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+	}
+
+	start := time.Now()
+	if err == nil {
+		for _, request := range requests {
+			if err = request.Write(conn.w); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = conn.w.Flush()
+		}
+	}
+
+	keep := err == nil
+	for i, request := range requests {
+		var response *http.Response
+		var body []byte
+		if err == nil {
+			response, err = http.ReadResponse(conn.r, request)
+			if err == nil {
+				body, err = consumeBody(response.Body, responseBodyMode)
+				keep = !response.Close
+			}
+		}
+		now := time.Now()
+
+		if err != nil && inflightCtx.Err() != nil {
+			abandoned.Add(int64(len(requests) - i))
+			p.drop(u.Host)
+			return false
+		}
+
+		countResponse(trgt, request, response, body, err, now, now.Sub(start))
+	}
+
+	if !keep || err != nil {
+		p.drop(u.Host)
+	}
+
+	return true
+}
+
+// conn is the connection to the host of u, dialing a new one if there is none
+func (p *pipeliner) conn(ctx context.Context, u *url.URL) (*pipelineConn, error) {
+	if conn, ok := p.conns[u.Host]; ok {
+		connsReused.Add(1)
+		return conn, nil
+	}
+
+	c, err := dialHTTP1(ctx, p.cfg, u)
+	if err != nil {
+		return nil, err
+	}
+	connsNew.Add(1)
+
+	conn := &pipelineConn{Conn: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+	p.conns[u.Host] = conn
+
+	return conn, nil
+}
+
+func (p *pipeliner) drop(host string) {
+	if conn, ok := p.conns[host]; ok {
+		conn.Close()
+		delete(p.conns, host)
+	}
+}
+
+func (p *pipeliner) close() {
+	for host := range p.conns {
+		p.drop(host)
+	}
+}
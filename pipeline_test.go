@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// runPipelineAttack sends ticks through pipelineAttack against url, and
+// returns once they are handled
+func runPipelineAttack(t *testing.T, url string, depth, ticks int) {
+	trgt := &targeter{requests: []request{{method: "GET", url: url + "/{{seq}}"}}}
+
+	ch := make(chan time.Time, ticks)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pipelineAttack(trgt, newPipeliner(depth, transportConfig{}), ch, quit)
+	}()
+
+	handled := ticksHandled.Load()
+	for i := 0; i < ticks; i++ {
+		ch <- time.Now()
+	}
+	for deadline := time.Now().Add(5 * time.Second); ticksHandled.Load()-handled < int64(ticks); {
+		if time.Now().After(deadline) {
+			t.Fatalf("pipelineAttack() handled %d of %d ticks", ticksHandled.Load()-handled, ticks)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(quit)
+	<-done
+}
+
+func Test_pipelineAttack(t *testing.T) {
+	setupTestLayout(t)
+
+	// a server that reads all the requests of a batch before answering any,
+	// which only works if they really are written at once
+	const depth = 4
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var conns int64
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&conns, 1)
+
+			go func(c net.Conn) {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					var paths []string
+					for i := 0; i < depth; i++ {
+						req, err := http.ReadRequest(r)
+						if err != nil {
+							return
+						}
+						paths = append(paths, req.URL.Path)
+					}
+					for _, path := range paths {
+						fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(path), path)
+					}
+				}
+			}(c)
+		}
+	}()
+
+	received, ok := responsesReceived.Load(), responsesOk.Load()
+	runPipelineAttack(t, "http://"+l.Addr().String(), depth, 2*depth)
+
+	if got := responsesReceived.Load() - received; got != 2*depth {
+		t.Errorf("pipelineAttack() got %d responses, want %d", got, 2*depth)
+	}
+	if got := responsesOk.Load() - ok; got != 2*depth {
+		t.Errorf("pipelineAttack() got %d ok responses, want %d", got, 2*depth)
+	}
+	if got := atomic.LoadInt64(&conns); got != 1 {
+		t.Errorf("pipelineAttack() opened %d connections, want 1", got)
+	}
+}
+
+func Test_pipelineAttackHTTPServer(t *testing.T) {
+	setupTestLayout(t)
+
+	var conns, requests int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	ok := responsesOk.Load()
+	runPipelineAttack(t, srv.URL, 8, 20)
+
+	if got := atomic.LoadInt64(&requests); got != 20 {
+		t.Errorf("server handled %d pipelined requests, want 20", got)
+	}
+	if got := responsesOk.Load() - ok; got != 20 {
+		t.Errorf("pipelineAttack() got %d ok responses, want 20", got)
+	}
+	if got := atomic.LoadInt64(&conns); got != 1 {
+		t.Errorf("pipelineAttack() opened %d connections, want 1", got)
+	}
+}
+
+func Test_pipelineable(t *testing.T) {
+	if err := pipelineable([]request{{method: "GET", url: "http://a/"}, {method: "GET", url: "http://b/"}}); err != nil {
+		t.Errorf("pipelineable() = %v for GETs", err)
+	}
+	if err := pipelineable([]request{{method: "GET", url: "http://a/"}, {method: "POST", url: "http://a/"}}); err == nil {
+		t.Error("pipelineable() accepted a POST")
+	}
+}
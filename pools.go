@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultIdleConnsPerHost = 100
+
+// connections requests were sent on, reused from the idle pool or new
+var connsReused, connsNew counter
+
+// withConnTrace counts the connection req gets in connsReused or connsNew,
+// and the TLS version of a new one, and notes the times of its phases
+func withConnTrace(req *http.Request) (*http.Request, *requestPhases) {
+	phases := &requestPhases{}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			phases.gotConn.Store(time.Now().UnixNano())
+			if info.Reused {
+				connsReused.Add(1)
+			} else {
+				connsNew.Add(1)
+			}
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				countTLSVersion(state.Version)
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				phases.wroteRequest.Store(time.Now().UnixNano())
+			}
+		},
+		GotFirstResponseByte: func() {
+			phases.firstByte.Store(time.Now().UnixNano())
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), phases
+}
+
+// reusePercent is the share of connections that were reused, -1 before any
+func reusePercent(reused, fresh int64) int64 {
+	if reused+fresh == 0 {
+		return -1
+	}
+
+	return reused * 100 / (reused + fresh)
+}
+
+// transportConfig holds the settings shared by the transports of all hosts
+type transportConfig struct {
+	tls  *tls.Config // nil for the defaults
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// speak only HTTP/2, over TLS for https and cleartext (h2c) for http
+	http2 bool
+
+	// tcp4 or tcp6 to connect over that address family only, empty for either
+	network string
+
+	// clear TCP_NODELAY on new connections, for Nagle's algorithm to batch
+	// small writes
+	nagle bool
+
+	// most connections open to a host at once, idle or not, 0 for no limit
+	maxConns int
+}
+
+// dialer is cfg.dial connecting over cfg.network, with Nagle's algorithm if
+// cfg.nagle is set, nil if none of them are set
+func (cfg transportConfig) dialer() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if cfg.network == "" && !cfg.nagle {
+		return cfg.dial
+	}
+
+	dial := cfg.dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if cfg.network != "" {
+			network = cfg.network
+		}
+
+		conn, err := dial(ctx, network, addr)
+		if err != nil || !cfg.nagle {
+			return conn, err
+		}
+
+		// Go sets TCP_NODELAY on every TCP connection
+		if tcp, ok := conn.(interface{ SetNoDelay(bool) error }); ok {
+			if err := tcp.SetNoDelay(false); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		return conn, nil
+	}
+}
+
+func newTransport(idleConnsPerHost int, cfg transportConfig) *http.Transport {
+	tlsConfig := cfg.tls
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	tr := &http.Transport{
+		DisableKeepAlives:   false,
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: idleConnsPerHost,
+		MaxConnsPerHost:     cfg.maxConns,
+		IdleConnTimeout:     30 * time.Second,
+		TLSClientConfig:     tlsConfig,
+		DialContext:         cfg.dialer(),
+	}
+
+	if cfg.http2 {
+		tr.Protocols = &http.Protocols{}
+		tr.Protocols.SetHTTP2(true)
+		tr.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return tr
+}
+
+// hostPools is a RoundTripper keeping a separate transport, and so a
+// separately sized idle connection pool, for every host in the targets
+type hostPools struct {
+	pools    map[string]*http.Transport
+	fallback *http.Transport // for hosts not in the targets, e.g. redirects
+
+	// pools for the targets asking for the other protocol with #proto=,
+	// h1 or h2, nil if none do
+	other      *hostPools
+	otherProto string
+}
+
+// protoKey is the request context key of the protocol a request asks for
+type protoKey struct{}
+
+// lookupProto is the protocol of a #proto= line, h1 or h2. h2c is h2, as
+// both are HTTP/2 only: cleartext with prior knowledge for http, negotiated
+// over TLS for https.
+func lookupProto(name string) (string, error) {
+	switch name {
+	case "h1":
+		return "h1", nil
+	case "h2", "h2c":
+		return "h2", nil
+	}
+
+	return "", fmt.Errorf("invalid #proto=%s, must be h1, h2 or h2c", name)
+}
+
+// withProto tags req with the protocol it asks for
+func withProto(req *http.Request, proto string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), protoKey{}, proto))
+}
+
+// protoOf is the protocol req asks for, "" for the default
+func protoOf(req *http.Request) string {
+	proto, _ := req.Context().Value(protoKey{}).(string)
+	return proto
+}
+
+// addProtoPools gives the requests asking for another protocol than cfg
+// speaks pools of their own, sized by their share of the workers like the
+// rest
+func (p *hostPools) addProtoPools(requests []request, workers uint, overrides map[string]int, cfg transportConfig) {
+	proto := "h1"
+	if cfg.http2 {
+		proto = "h2"
+	}
+
+	var other []request
+	for _, req := range requests {
+		if req.proto != "" && req.proto != proto {
+			other = append(other, req)
+		}
+	}
+	if len(other) == 0 {
+		return
+	}
+
+	cfg.http2 = !cfg.http2
+	share := uint(math.Ceil(float64(workers) * float64(len(other)) / float64(len(requests))))
+	p.other = newHostPools(poolSizes(other, share, overrides), cfg)
+	p.otherProto = other[0].proto
+}
+
+func newHostPools(sizes map[string]int, cfg transportConfig) *hostPools {
+	p := &hostPools{
+		pools:    make(map[string]*http.Transport, len(sizes)),
+		fallback: newTransport(defaultIdleConnsPerHost, cfg),
+	}
+
+	for host, size := range sizes {
+		p.pools[host] = newTransport(size, cfg)
+	}
+
+	return p
+}
+
+func (p *hostPools) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p.other != nil && protoOf(req) == p.otherProto {
+		return p.other.RoundTrip(req)
+	}
+
+	if tr, ok := p.pools[req.URL.Host]; ok {
+		return tr.RoundTrip(req)
+	}
+
+	return p.fallback.RoundTrip(req)
+}
+
+func (p *hostPools) CloseIdleConnections() {
+	for _, tr := range p.pools {
+		tr.CloseIdleConnections()
+	}
+	p.fallback.CloseIdleConnections()
+	if p.other != nil {
+		p.other.CloseIdleConnections()
+	}
+}
+
+// poolSizes gives each host an idle pool matching its share of the
+// requests: no more than that share of the workers can be talking to it at
+// once. overrides, keyed by host, take precedence.
+func poolSizes(requests []request, workers uint, overrides map[string]int) map[string]int {
+	counts := make(map[string]int)
+	for _, req := range requests {
+		u, err := url.Parse(req.url)
+		if err != nil {
+			continue
+		}
+		counts[u.Host]++
+	}
+
+	sizes := make(map[string]int, len(counts))
+	for host, count := range counts {
+		share := float64(count) / float64(len(requests))
+		sizes[host] = int(math.Max(1, math.Ceil(share*float64(workers))))
+	}
+
+	for host, size := range overrides {
+		sizes[host] = size
+	}
+
+	return sizes
+}
+
+// hostConnsFlags are repeated host=N idle pool size overrides
+type hostConnsFlags map[string]int
+
+func (h hostConnsFlags) String() string {
+	var pairs []string
+	for host, size := range h {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", host, size))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (h hostConnsFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected host=N, got %q", value)
+	}
+
+	size, err := strconv.Atoi(parts[1])
+	if err != nil || size < 1 {
+		return fmt.Errorf("invalid pool size %q", parts[1])
+	}
+
+	h[parts[0]] = size
+	return nil
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_poolSizes(t *testing.T) {
+	requests := []request{
+		{method: "GET", url: "http://a.example.com/1"},
+		{method: "GET", url: "http://a.example.com/2"},
+		{method: "GET", url: "http://a.example.com/3"},
+		{method: "GET", url: "http://b.example.com:8080/"},
+	}
+
+	tests := []struct {
+		name      string
+		workers   uint
+		overrides map[string]int
+		want      map[string]int
+	}{
+		{
+			name:    "sized by share",
+			workers: 16,
+			want:    map[string]int{"a.example.com": 12, "b.example.com:8080": 4},
+		},
+		{
+			name:    "at least one connection",
+			workers: 1,
+			want:    map[string]int{"a.example.com": 1, "b.example.com:8080": 1},
+		},
+		{
+			name:      "overrides",
+			workers:   16,
+			overrides: map[string]int{"b.example.com:8080": 50, "c.example.com": 2},
+			want:      map[string]int{"a.example.com": 12, "b.example.com:8080": 50, "c.example.com": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := poolSizes(requests, tt.workers, tt.overrides); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("poolSizes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_hostConnsFlags(t *testing.T) {
+	h := hostConnsFlags{}
+	for _, valid := range []string{"a.example.com=10", "b.example.com:8080=2"} {
+		if err := h.Set(valid); err != nil {
+			t.Errorf("Set(%q) error = %v", valid, err)
+		}
+	}
+	if want := (hostConnsFlags{"a.example.com": 10, "b.example.com:8080": 2}); !reflect.DeepEqual(h, want) {
+		t.Errorf("hostConnsFlags = %v, want %v", h, want)
+	}
+
+	for _, invalid := range []string{"a.example.com", "=10", "a.example.com=0", "a.example.com=many"} {
+		if err := h.Set(invalid); err == nil {
+			t.Errorf("Set(%q) expected an error", invalid)
+		}
+	}
+}
+
+// benchmarkTwoHosts sends three quarters of the traffic to one host and the
+// rest to another, reporting how many new connections each request needed.
+// Every worker gets its round tripper from newRT.
+func benchmarkTwoHosts(b *testing.B, newRT func(trgt *targeter) http.RoundTripper) {
+	const workers = 32
+
+	var conns int64
+	newServer := func() *httptest.Server {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(time.Millisecond)
+			io.WriteString(w, "ok")
+		}))
+		srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt64(&conns, 1)
+			}
+		}
+		srv.Start()
+		return srv
+	}
+
+	busy, quiet := newServer(), newServer()
+	defer busy.Close()
+	defer quiet.Close()
+
+	trgt := &targeter{
+		requests: []request{
+			{method: "GET", url: busy.URL},
+			{method: "GET", url: busy.URL},
+			{method: "GET", url: busy.URL},
+			{method: "GET", url: quiet.URL},
+		},
+	}
+	var sent int64
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for w := 0; w < workers; w++ {
+		client := &http.Client{Transport: newRT(trgt)}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.AddInt64(&sent, 1) <= int64(b.N) {
+				req, err := trgt.nextRequest()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+
+				resp, err := client.Do(req)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N), "conns/op")
+}
+
+// BenchmarkPoolsPerWorker has every worker keep its own pool for every host
+func BenchmarkPoolsPerWorker(b *testing.B) {
+	benchmarkTwoHosts(b, func(*targeter) http.RoundTripper {
+		return newTransport(defaultIdleConnsPerHost, transportConfig{})
+	})
+}
+
+// BenchmarkPoolsPerHost has all workers share a pool per host, sized by its share of the traffic
+func BenchmarkPoolsPerHost(b *testing.B) {
+	var once sync.Once
+	var pools *hostPools
+	benchmarkTwoHosts(b, func(trgt *targeter) http.RoundTripper {
+		once.Do(func() { pools = newHostPools(poolSizes(trgt.requests, 32, nil), transportConfig{}) })
+		return pools
+	})
+}
+
+func Test_transportConfigDialer(t *testing.T) {
+	if (transportConfig{}).dialer() != nil {
+		t.Error("dialer() without dial or network should leave the transport's default")
+	}
+
+	var got string
+	cfg := transportConfig{
+		network: "tcp6",
+		dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			got = network
+			return nil, io.EOF
+		},
+	}
+
+	cfg.dialer()(context.Background(), "tcp", "example.com:80")
+	if got != "tcp6" {
+		t.Errorf("dialer() dialed over %q, want tcp6", got)
+	}
+}
+
+// noDelayConn records the last TCP_NODELAY setting of the connection
+type noDelayConn struct {
+	net.Conn
+	noDelay *bool
+}
+
+func (c noDelayConn) SetNoDelay(noDelay bool) error {
+	*c.noDelay = noDelay
+	return nil
+}
+
+func Test_transportConfigNagle(t *testing.T) {
+	tests := []struct {
+		name        string
+		nagle       bool
+		wantNoDelay bool
+	}{
+		{"default", false, true},
+		{"nagle", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noDelay := true // as Go dials them
+			cfg := transportConfig{
+				nagle: tt.nagle,
+				dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					client, server := net.Pipe()
+					server.Close()
+					return noDelayConn{Conn: client, noDelay: &noDelay}, nil
+				},
+			}
+
+			conn, err := cfg.dialer()(context.Background(), "tcp", "example.com:80")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			if noDelay != tt.wantNoDelay {
+				t.Errorf("dialed connection has TCP_NODELAY %v, want %v", noDelay, tt.wantNoDelay)
+			}
+		})
+	}
+
+	// and on real connections, through the transport
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	conn, err := transportConfig{nagle: true}.dialer()(context.Background(), "tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*net.TCPConn); !ok {
+		t.Errorf("dialer() = %T, want a *net.TCPConn", conn)
+	}
+}
+
+func Test_attackConnReuse(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{requests: []request{{method: "GET", url: srv.URL}}}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, client, ch, quit)
+	}()
+
+	reused, fresh := connsReused.Load(), connsNew.Load()
+	// one worker sends one request at a time, so all but the first reuse its connection
+	for i := 0; i < 10; i++ {
+		ch <- time.Now()
+	}
+	close(quit)
+	<-done
+
+	if got := connsNew.Load() - fresh; got != 1 {
+		t.Errorf("attack() opened %d connections, want 1", got)
+	}
+	if got := connsReused.Load() - reused; got < 8 {
+		t.Errorf("attack() reused connections %d times, want at least 8", got)
+	}
+}
+
+func Test_reusePercent(t *testing.T) {
+	tests := []struct {
+		reused, fresh, want int64
+	}{
+		{0, 0, -1},
+		{0, 4, 0},
+		{49, 1, 98},
+		{10, 0, 100},
+	}
+	for _, tt := range tests {
+		if got := reusePercent(tt.reused, tt.fresh); got != tt.want {
+			t.Errorf("reusePercent(%d, %d) = %d, want %d", tt.reused, tt.fresh, got, tt.want)
+		}
+	}
+}
+
+func Test_hostPoolsProto(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	srv.Config.Protocols = &http.Protocols{}
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	defer srv.Close()
+
+	trgt := &targeter{}
+	targets := "#proto=h2c\nGET " + srv.URL + "/h2\nGET " + srv.URL + "/default\n#proto=h1\nGET " + srv.URL + "/h1\n"
+	if err := trgt.readTargets(strings.NewReader(targets), false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		http2 bool              // of the default transport
+		want  map[string]string // protocol by path
+	}{
+		{"h1 by default", false, map[string]string{"/h2": "HTTP/2.0", "/default": "HTTP/1.1", "/h1": "HTTP/1.1"}},
+		{"h2 by default", true, map[string]string{"/h2": "HTTP/2.0", "/default": "HTTP/2.0", "/h1": "HTTP/1.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := transportConfig{http2: tt.http2}
+			pools := newHostPools(poolSizes(trgt.requests, 4, nil), cfg)
+			pools.addProtoPools(trgt.requests, 4, nil, cfg)
+			defer pools.CloseIdleConnections()
+			client := &http.Client{Transport: pools}
+
+			for i := 0; i < len(trgt.requests); i++ {
+				req, err := trgt.nextRequest()
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+
+				if want := tt.want[req.URL.Path]; string(body) != want || resp.Proto != want {
+					t.Errorf("request %d to %s went over %s, answered over %s, want %s", i, req.URL.Path, body, resp.Proto, want)
+				}
+			}
+		})
+	}
+
+	if err := trgt.readTargets(strings.NewReader("#proto=h3\nGET "+srv.URL+"\n"), false); err == nil {
+		t.Error("readTargets() accepted #proto=h3")
+	}
+}
+
+func Test_maxConns(t *testing.T) {
+	const maxConns = 3
+
+	var open, most int64
+	var mu sync.Mutex
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case http.StateNew:
+			if open++; open > most {
+				most = open
+			}
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	tr := newTransport(defaultIdleConnsPerHost, transportConfig{maxConns: maxConns})
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+
+	// many more requests at once than connections, which wait for one
+	var wg sync.WaitGroup
+	var failed counter
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				failed.Add(1)
+				return
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		t.Errorf("%d requests failed, want them to wait for a connection", n)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if most > maxConns {
+		t.Errorf("server saw %d connections at once, want at most %d", most, maxConns)
+	}
+}
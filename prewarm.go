@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// prewarm opens up to conns[host] connections to every host in requests by
+// sending that many HEAD requests to it at once, before anything is
+// measured. The connections then sit in the idle pools, so the first real
+// requests skip connection setup. It returns the number of probes answered.
+func prewarm(client *http.Client, requests []request, conns map[string]int, timeout time.Duration) int {
+	var wg sync.WaitGroup
+	var answered counter
+	for host, target := range probeTargets(requests) {
+		n := conns[host]
+		if n < 1 {
+			n = 1
+		}
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(target string) {
+				defer wg.Done()
+				if probe(client, target, timeout) {
+					answered.Add(1)
+				}
+			}(target)
+		}
+	}
+	wg.Wait()
+
+	return int(answered.Load())
+}
+
+// preflight probes every host in requests once, at the same time, and
+// returns the hosts that didn't answer, sorted. Any response will do, even an
+// error status: it only checks that the hosts can be reached at all.
+func preflight(client *http.Client, requests []request, timeout time.Duration) (hosts int, unreachable []string) {
+	probes := probeTargets(requests)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for host, target := range probes {
+		wg.Add(1)
+		go func(host, target string) {
+			defer wg.Done()
+			if !probe(client, target, timeout) {
+				mu.Lock()
+				unreachable = append(unreachable, host)
+				mu.Unlock()
+			}
+		}(host, target)
+	}
+	wg.Wait()
+
+	sort.Strings(unreachable)
+	return len(probes), unreachable
+}
+
+// probeTargets maps every host in requests to the first of its urls
+func probeTargets(requests []request) map[string]string {
+	probes := make(map[string]string)
+	for _, req := range requests {
+		u, err := url.Parse(req.url)
+		if err != nil {
+			continue
+		}
+		if _, ok := probes[u.Host]; !ok {
+			probes[u.Host] = req.url
+		}
+	}
+
+	return probes
+}
+
+// probe sends a HEAD request to target, reading the response to the end
+// so its connection goes back to the idle pool
+func probe(client *http.Client, target string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_prewarm(t *testing.T) {
+	var mu sync.Mutex
+	var conns int
+	var methods []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond) // hold the connection so the probes can't share it
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	requests := []request{{method: "POST", url: srv.URL + "/a"}, {method: "GET", url: srv.URL + "/b"}}
+	sizes := poolSizes(requests, 3, nil)
+	client := &http.Client{Transport: newHostPools(sizes, transportConfig{})}
+
+	if got := prewarm(client, requests, sizes, time.Second); got != 3 {
+		t.Fatalf("prewarm() answered %d probes, want 3", got)
+	}
+
+	mu.Lock()
+	if conns != 3 {
+		t.Errorf("prewarm() opened %d connections, want 3", conns)
+	}
+	for _, method := range methods {
+		if method != http.MethodHead {
+			t.Errorf("prewarm() sent a %s, want only HEAD", method)
+		}
+	}
+	mu.Unlock()
+
+	// the measured requests find the connections ready
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL + "/b")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if conns != 3 {
+		t.Errorf("requests after prewarm() opened %d more connections, want 0", conns-3)
+	}
+}
+
+func Test_preflight(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed) // any answer will do
+	}))
+	defer srv.Close()
+
+	// a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := "http://" + l.Addr().String()
+	l.Close()
+
+	tests := []struct {
+		name            string
+		requests        []request
+		wantHosts       int
+		wantUnreachable int
+	}{
+		{"reachable", []request{{method: "GET", url: srv.URL + "/a"}, {method: "GET", url: srv.URL + "/b"}}, 1, 0},
+		{"unreachable", []request{{method: "GET", url: down + "/a"}}, 1, 1},
+		{"one of two unreachable", []request{{method: "GET", url: srv.URL}, {method: "GET", url: down}}, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+			hosts, unreachable := preflight(client, tt.requests, time.Second)
+			if hosts != tt.wantHosts || len(unreachable) != tt.wantUnreachable {
+				t.Errorf("preflight() = %d, %v, want %d hosts and %d unreachable", hosts, unreachable, tt.wantHosts, tt.wantUnreachable)
+			}
+			for _, host := range unreachable {
+				if "http://"+host != down {
+					t.Errorf("preflight() gave %s as unreachable, want only %s", host, down)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// profilePhase is one step of a -profile: rate requests per second for
+// duration, sending only the targets with one of tags, or all of them if
+// there are none
+type profilePhase struct {
+	name     string
+	rate     int64
+	duration time.Duration
+	tags     []string
+}
+
+// readProfile reads the phases of a -profile from file
+func readProfile(file string) ([]profilePhase, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	phases, err := parseProfile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return phases, nil
+}
+
+// parseProfile parses phases, one per line, as "name rate duration [tags]",
+// e.g. "warmup 10 30s" or "checkout 2.5k 5m cart,pay". Blank lines and lines
+// starting with # are skipped.
+func parseProfile(r io.Reader) ([]profilePhase, error) {
+	var phases []profilePhase
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected \"name rate duration [tags]\", got %q", line, text)
+		}
+
+		var rate rateFlag
+		if err := rate.Set(fields[1]); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		duration, err := time.ParseDuration(fields[2])
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("line %d: invalid duration %q, expected a positive duration like 30s or 5m", line, fields[2])
+		}
+
+		phase := profilePhase{name: fields[0], rate: int64(rate), duration: duration}
+		if len(fields) == 4 {
+			phase.tags = splitTags(fields[3])
+		}
+		phases = append(phases, phase)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("no phases")
+	}
+
+	return phases, nil
+}
+
+// profileTargets are the requests of all to send in each phase, checking
+// that every phase has some
+func profileTargets(phases []profilePhase, all []request) ([][]request, error) {
+	targets := make([][]request, len(phases))
+	for i, phase := range phases {
+		targets[i] = all
+		if len(phase.tags) > 0 {
+			targets[i] = selectTags(all, phase.tags)
+		}
+		if len(targets[i]) == 0 {
+			return nil, fmt.Errorf("phase %s: no targets tagged %s", phase.name, strings.Join(phase.tags, ", "))
+		}
+	}
+
+	return targets, nil
+}
+
+// setRequests replaces the requests trgt sends
+func (trgt *targeter) setRequests(requests []request) {
+	trgt.mu.Lock()
+	trgt.requests = requests
+	trgt.mu.Unlock()
+}
+
+// runProfile goes through phases in order, sending each one's targets to
+// trgt and changing the rate to its own. The ticker is expected to start at
+// the rate of the first phase. Rates are changed relative to the last phase,
+// so changes made with the keys meanwhile carry over. It returns true once
+// the last phase is over, false if quit is closed first.
+func runProfile(phases []profilePhase, targets [][]request, trgt *targeter, rateChanger chan<- rateChange, quit <-chan struct{}) bool {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for i, phase := range phases {
+		trgt.setRequests(targets[i])
+
+		if i > 0 {
+			select {
+			case rateChanger <- rateChange{delta: phase.rate - phases[i-1].rate, reason: "profile:" + phase.name}:
+			case <-quit:
+				return false
+			}
+		}
+
+		timer.Reset(phase.duration)
+		select {
+		case <-timer.C:
+		case <-quit:
+			return false
+		}
+	}
+
+	return true
+}
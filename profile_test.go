@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    []profilePhase
+		wantErr bool
+	}{
+		{
+			name:    "phases",
+			profile: "# warm up first\nwarmup 10 30s\n\nsteady 2.5k 5m cart,pay\n  cooldown 0 1m  \n",
+			want: []profilePhase{
+				{name: "warmup", rate: 10, duration: 30 * time.Second},
+				{name: "steady", rate: 2500, duration: 5 * time.Minute, tags: []string{"cart", "pay"}},
+				{name: "cooldown", rate: 0, duration: time.Minute},
+			},
+		},
+		{name: "empty", profile: "# nothing\n\n", wantErr: true},
+		{name: "missing duration", profile: "warmup 10\n", wantErr: true},
+		{name: "too many fields", profile: "warmup 10 30s cart pay\n", wantErr: true},
+		{name: "bad rate", profile: "warmup fast 30s\n", wantErr: true},
+		{name: "bad duration", profile: "warmup 10 soon\n", wantErr: true},
+		{name: "zero duration", profile: "warmup 10 0s\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProfile(strings.NewReader(tt.profile))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_profileTargets(t *testing.T) {
+	all := []request{
+		{url: "http://a/cart", tags: []string{"cart"}},
+		{url: "http://a/pay", tags: []string{"pay"}},
+	}
+
+	phases := []profilePhase{{name: "all"}, {name: "pay", tags: []string{"pay"}}}
+	got, err := profileTargets(phases, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got[0]) != 2 || len(got[1]) != 1 || got[1][0].url != "http://a/pay" {
+		t.Errorf("profileTargets() = %+v, want all targets, then the pay one", got)
+	}
+
+	if _, err := profileTargets([]profilePhase{{name: "search", tags: []string{"search"}}}, all); err == nil {
+		t.Error("profileTargets() with no targets for a phase, want an error")
+	}
+}
+
+func Test_runProfile(t *testing.T) {
+	all := []request{
+		{url: "http://a/cart", tags: []string{"cart"}},
+		{url: "http://a/pay", tags: []string{"pay"}},
+	}
+	phases := []profilePhase{
+		{name: "warmup", rate: 10, duration: 40 * time.Millisecond},
+		{name: "pay", rate: 50, duration: 60 * time.Millisecond, tags: []string{"pay"}},
+		{name: "cooldown", rate: 20, duration: 40 * time.Millisecond},
+	}
+	targets, err := profileTargets(phases, all)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type seen struct {
+		change  rateChange
+		at      time.Duration
+		targets int
+	}
+
+	trgt := &targeter{}
+	rateChanger := make(chan rateChange)
+	changes := make(chan seen, len(phases))
+	start := time.Now()
+	go func() {
+		for c := range rateChanger {
+			trgt.mu.RLock()
+			changes <- seen{c, time.Since(start), len(trgt.requests)}
+			trgt.mu.RUnlock()
+		}
+	}()
+
+	if !runProfile(phases, targets, trgt, rateChanger, make(chan struct{})) {
+		t.Fatal("runProfile() = false, want the profile to run to the end")
+	}
+	elapsed := time.Since(start)
+	close(rateChanger)
+
+	if elapsed < 140*time.Millisecond {
+		t.Errorf("runProfile() took %s, want at least the 140ms of its phases", elapsed)
+	}
+
+	want := []struct {
+		change  rateChange
+		after   time.Duration
+		targets int
+	}{
+		{rateChange{delta: 40, reason: "profile:pay"}, 40 * time.Millisecond, 1},
+		{rateChange{delta: -30, reason: "profile:cooldown"}, 100 * time.Millisecond, 2},
+	}
+	for i, w := range want {
+		got := <-changes
+		if got.change != w.change {
+			t.Errorf("rate change %d = %+v, want %+v", i, got.change, w.change)
+		}
+		if got.at < w.after {
+			t.Errorf("rate change %d came after %s, want at least %s", i, got.at, w.after)
+		}
+		if got.targets != w.targets {
+			t.Errorf("rate change %d with %d targets, want the %d of its phase", i, got.targets, w.targets)
+		}
+	}
+	if len(trgt.requests) != 2 {
+		t.Errorf("last phase sends %d targets, want all 2", len(trgt.requests))
+	}
+
+	quit := make(chan struct{})
+	close(quit)
+	if runProfile(phases, targets, &targeter{}, make(chan rateChange), quit) {
+		t.Error("runProfile() after quit = true, want false")
+	}
+}
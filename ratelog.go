@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// rateChange changes the desired rate by delta
+type rateChange struct {
+	delta  int64
+	reason string // what changed it, e.g. key or backoff, for -rate-change-log
+}
+
+// rateChangeLog is where -rate-change-log writes every change of the
+// desired rate, nil without it. Only the ticker goroutine writes to it.
+var rateChangeLog io.Writer
+
+// logRateChange writes a "timestamp oldrate newrate reason" line to
+// rateChangeLog, unless the rate stayed the same
+func logRateChange(now time.Time, from, to int64, reason string) {
+	if rateChangeLog == nil || from == to {
+		return
+	}
+
+	fmt.Fprintf(rateChangeLog, "%s %d %d %s\n", now.Format(time.RFC3339Nano), from, to, reason)
+}
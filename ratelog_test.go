@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_tickerRateChangeLog(t *testing.T) {
+	defer desiredRate.Store(0)
+
+	buf := &lockedBuffer{}
+	rateChangeLog = buf
+	defer func() { rateChangeLog = nil }()
+
+	quit := make(chan struct{})
+	defer close(quit)
+
+	_, rateChanger := ticker(100, 0, nil, quit)
+	rateChanger <- rateChange{50, "key"}
+	rateChanger <- rateChange{-500, "backoff"}
+
+	var lines []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(lines) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	want := []string{"0 100 start", "100 150 key", "150 0 backoff"}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %q, want %d lines", lines, len(want))
+	}
+	for i, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			t.Errorf("line %d: %s", i, err)
+		}
+		if len(fields) != 2 || fields[1] != want[i] {
+			t.Errorf("line %d = %q, want a timestamp and %q", i, line, want[i])
+		}
+	}
+}
+
+func Test_logRateChange(t *testing.T) {
+	var buf bytes.Buffer
+	rateChangeLog = &buf
+	defer func() { rateChangeLog = nil }()
+
+	now := time.Date(2026, 10, 14, 9, 12, 3, 0, time.UTC)
+	logRateChange(now, 0, 0, "key")
+	logRateChange(now, 10, 20, "key")
+
+	if got, want := buf.String(), "2026-10-14T09:12:03Z 10 20 key\n"; got != want {
+		t.Errorf("logged %q, want %q, skipping the change to the same rate", got, want)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// rawLengthTransport sends every request with a Content-Length of length,
+// whatever the size of its body. Go's own transport refuses to send such
+// requests, so this one writes HTTP/1.1 by hand, on a new connection per
+// request that is closed once the response has been read. It doesn't go
+// through hostPools, but counts its connections in connsNew and their TLS
+// versions like the pools do.
+type rawLengthTransport struct {
+	length int64
+	cfg    transportConfig
+}
+
+// rawLengthSkipped are the headers the transport writes itself
+var rawLengthSkipped = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+func (t *rawLengthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := t.dial(ctx, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	connsNew.Add(1)
+
+	// unblock reads and writes once the request times out
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), host)
+	req.Header.WriteSubset(&b, rawLengthSkipped)
+	fmt.Fprintf(&b, "Content-Length: %d\r\nConnection: close\r\n\r\n", t.length)
+	b.Write(body)
+
+	if _, err := conn.Write(b.Bytes()); err != nil {
+		stop()
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		stop()
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	resp.Body = &connBody{ReadCloser: resp.Body, conn: conn, stop: stop}
+	return resp, nil
+}
+
+// dial connects to the host of u, over TLS for https
+func (t *rawLengthTransport) dial(ctx context.Context, u *url.URL) (net.Conn, error) {
+	return dialHTTP1(ctx, t.cfg, u)
+}
+
+// dialHTTP1 connects to the host of u for writing HTTP/1.1 by hand, over TLS
+// for https, counting the TLS version negotiated
+func dialHTTP1(ctx context.Context, cfg transportConfig, u *url.URL) (net.Conn, error) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+
+	dial := cfg.dialer()
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil || u.Scheme != "https" {
+		return conn, err
+	}
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: true}
+	if cfg.tls != nil {
+		tlsCfg = cfg.tls.Clone()
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = u.Hostname()
+	}
+	tlsCfg.NextProtos = []string{"http/1.1"}
+
+	tlsConn := tls.Client(conn, tlsCfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	countTLSVersion(tlsConn.ConnectionState().Version)
+
+	return tlsConn, nil
+}
+
+// connBody closes the connection along with the response body
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+	stop func() bool
+}
+
+func (b *connBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stop()
+	b.conn.Close()
+
+	return err
+}
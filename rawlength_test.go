@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_rawLengthTransport(t *testing.T) {
+	type received struct {
+		header string
+		body   string
+	}
+
+	tests := []struct {
+		name     string
+		length   int64
+		tls      bool
+		wantBody string
+		wantErr  bool
+	}{
+		{"shorter", 5, false, "hello", false},
+		{"shorter over tls", 5, true, "hello", false},
+		{"exact", 11, false, "hello world", false},
+		// the server waits for bytes that never come, until the request times out
+		{"longer", 20, false, "hello world", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan received, 1)
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				got <- received{r.Header.Get("Content-Length"), string(body)}
+				w.Header().Set("X-Seen", "yes")
+			}))
+			if tt.tls {
+				srv.StartTLS()
+			} else {
+				srv.Start()
+			}
+			defer srv.Close()
+
+			connsNew.Store(0)
+			for i := range negotiatedTLS {
+				negotiatedTLS[i].Store(0)
+			}
+			defer func() {
+				for i := range negotiatedTLS {
+					negotiatedTLS[i].Store(0)
+				}
+			}()
+
+			client := &http.Client{Transport: &rawLengthTransport{length: tt.length}}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequest("POST", srv.URL+"/path?q=1", strings.NewReader("hello world"))
+			req.Header.Set("X-Test", "1")
+
+			resp, err := client.Do(req.WithContext(ctx))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Seen") != "yes" {
+					t.Errorf("Do() = %s, want the server's 200", resp.Status)
+				}
+				resp.Body.Close()
+			}
+			if got := connsNew.Load(); got != 1 {
+				t.Errorf("connsNew = %d, want 1", got)
+			}
+			var wantTLS int64
+			if tt.tls {
+				wantTLS = 1
+			}
+			if got := negotiatedTLS[tls.VersionTLS13-tls.VersionTLS10].Load(); got != wantTLS {
+				t.Errorf("negotiatedTLS[1.3] = %d, want %d", got, wantTLS)
+			}
+
+			select {
+			case r := <-got:
+				if want := strconv.FormatInt(tt.length, 10); r.header != want {
+					t.Errorf("server saw Content-Length %q, want %q", r.header, want)
+				}
+				if !strings.HasPrefix(tt.wantBody, r.body) || (!tt.wantErr && r.body != tt.wantBody) {
+					t.Errorf("server read body %q, want %q", r.body, tt.wantBody)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("server never got the request")
+			}
+		})
+	}
+}
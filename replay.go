@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replayEvent is a request to be sent at offset from the start of the replay
+type replayEvent struct {
+	offset time.Duration
+	req    request
+}
+
+func newReplayTargeter(file string, base64body bool) (*targeter, []time.Duration, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	events, err := readReplay(f, base64body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	trgt := &targeter{}
+	offsets := make([]time.Duration, len(events))
+	for i, ev := range events {
+		trgt.requests = append(trgt.requests, ev.req)
+		offsets[i] = ev.offset
+	}
+
+	// every tick consumes one request, so start at the first one rather than
+	// wherever round robin would
+	trgt.idx.Store(-1)
+
+	return trgt, offsets, nil
+}
+
+// readReplay parses a replay log, sorted by offset. Its syntax is that of a
+// targets file, with each request line prefixed by its offset:
+// <offset> GET <url>\n
+// $ <body>\n
+// The offset is either a duration (1.5s, 200ms) or a number of seconds.
+func readReplay(reader io.Reader, base64body bool) ([]replayEvent, error) {
+	var events []replayEvent
+
+	scanner := bufio.NewScanner(reader)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if text, encoded, isBody := bodyLine(line, base64body); isBody {
+			if len(events) == 0 {
+				return nil, fmt.Errorf("line %d: body without a request", lineNo)
+			}
+
+			body := []byte(text)
+			if encoded {
+				var err error
+				body, err = base64.StdEncoding.DecodeString(text)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", lineNo, err)
+				}
+			}
+			events[len(events)-1].req.body = body
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: expected '<offset> <method> <url>'", lineNo)
+		}
+
+		offset, err := parseOffset(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNo, err)
+		}
+
+		events = append(events, replayEvent{
+			offset: offset,
+			req: request{
+				method: parts[1],
+				url:    parts[2],
+				body:   []byte{},
+			},
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].offset < events[j].offset })
+
+	return events, nil
+}
+
+func parseOffset(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		s = fmt.Sprintf("%fs", secs)
+	}
+
+	offset, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %q", s)
+	}
+
+	return offset, nil
+}
+
+// replayTicker ticks at each of the sorted offsets, divided by speed, instead
+// of at a constant rate. Rate changes don't apply to a replay and are
+// discarded. The last channel is closed once the workers are done with the
+// tick of every offset, sent, skipped or failed.
+func replayTicker(offsets []time.Duration, speed float64, quit <-chan struct{}) (<-chan time.Time, chan<- rateChange, <-chan struct{}) {
+	ticker := make(chan time.Time, 1)
+	rateChanger := make(chan rateChange, 1)
+	done := make(chan struct{})
+
+	go func() {
+		base := ticksHandled.Load()
+		start := time.Now()
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for i := 0; i < len(offsets); {
+			select {
+			case t := <-timer.C:
+				// fire everything that is due, late events go out right away
+				for ; i < len(offsets) && t.Sub(start) >= time.Duration(float64(offsets[i])/speed); i++ {
+					select {
+					case ticker <- t:
+					case <-quit:
+						return
+					}
+				}
+
+				if i < len(offsets) {
+					timer.Reset(time.Until(start.Add(time.Duration(float64(offsets[i]) / speed))))
+				}
+			case <-rateChanger:
+			case <-quit:
+				return
+			}
+		}
+
+		wait := time.NewTicker(10 * time.Millisecond)
+		defer wait.Stop()
+		for ticksHandled.Load()-base < int64(len(offsets)) {
+			select {
+			case <-wait.C:
+			case <-rateChanger:
+			case <-quit:
+				return
+			}
+		}
+		close(done)
+
+		for {
+			select {
+			case <-rateChanger:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return ticker, rateChanger, done
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_readReplay(t *testing.T) {
+	input := `0.5 GET http://127.0.0.1:5000/second
+$ {"foo": "bar"}
+0 GET http://127.0.0.1:5000/first
+
+1500ms POST http://127.0.0.1:5000/third
+$64 AAEC/w==
+0.5 GET http://127.0.0.1:5000/also-second
+`
+	events, err := readReplay(strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		offset time.Duration
+		url    string
+		body   string
+	}{
+		{0, "http://127.0.0.1:5000/first", ""},
+		{500 * time.Millisecond, "http://127.0.0.1:5000/second", `{"foo": "bar"}`},
+		{500 * time.Millisecond, "http://127.0.0.1:5000/also-second", ""},
+		{1500 * time.Millisecond, "http://127.0.0.1:5000/third", "\x00\x01\x02\xff"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("readReplay() got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		if events[i].offset != w.offset || events[i].req.url != w.url || string(events[i].req.body) != w.body {
+			t.Errorf("readReplay() event %d = %+v, want %+v", i, events[i], w)
+		}
+	}
+}
+
+func Test_readReplayErrors(t *testing.T) {
+	for _, input := range []string{
+		"$ body before request",
+		"GET http://127.0.0.1:5000/no-offset",
+		"soon GET http://127.0.0.1:5000/bad-offset",
+		"-1s GET http://127.0.0.1:5000/negative",
+	} {
+		if _, err := readReplay(strings.NewReader(input), false); err == nil {
+			t.Errorf("readReplay(%q) expected an error", input)
+		}
+	}
+}
+
+func Test_replayTicker(t *testing.T) {
+	offsets := []time.Duration{0, 40 * time.Millisecond, 40 * time.Millisecond, 100 * time.Millisecond}
+	speed := 2.0
+
+	quit := make(chan struct{})
+	defer close(quit)
+
+	start := time.Now()
+	base := ticksHandled.Load()
+	ticks, _, done := replayTicker(offsets, speed, quit)
+
+	var last time.Duration
+	for i, offset := range offsets {
+		select {
+		case <-ticks:
+		case <-time.After(time.Second):
+			t.Fatalf("replayTicker() tick %d never came", i)
+		}
+
+		elapsed := time.Since(start)
+		want := time.Duration(float64(offset) / speed)
+		if elapsed < want {
+			t.Errorf("replayTicker() tick %d after %v, want no earlier than %v", i, elapsed, want)
+		}
+		if elapsed < last {
+			t.Errorf("replayTicker() tick %d out of order", i)
+		}
+		last = elapsed
+	}
+
+	select {
+	case <-ticks:
+		t.Error("replayTicker() ticked more often than there are events")
+	case <-done:
+		t.Error("replayTicker() was done before the workers were")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ticksHandled.Add(int64(len(offsets)))
+	defer ticksHandled.Store(base)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("replayTicker() never was done after every tick was handled")
+	}
+}
+
+func Test_newReplayTargeterOrder(t *testing.T) {
+	f, err := ioutil.TempFile("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("1 GET http://127.0.0.1:5000/b\n0 GET http://127.0.0.1:5000/a\n")
+	f.Close()
+
+	trgt, offsets, err := newReplayTargeter(f.Name(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 2 || offsets[0] != 0 || offsets[1] != time.Second {
+		t.Errorf("newReplayTargeter() offsets = %v, want [0s 1s]", offsets)
+	}
+
+	for _, want := range []string{"/a", "/b"} {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.URL.Path != want {
+			t.Errorf("nextRequest() = %v, want %v", req.URL.Path, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// parseHeaderFlags parses -H flags, "Key: value" each, with environment
+// variables expanded
+func parseHeaderFlags(flags []string) (http.Header, error) {
+	lines := make([]string, len(flags))
+	for i, flag := range flags {
+		line, err := expandEnv(flag)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(line, ":") {
+			return nil, fmt.Errorf("-H %q: expected \"Key: value\"", flag)
+		}
+		lines[i] = line
+	}
+
+	headers := strings.Join(lines, "\r\n")
+	headers += "\r\n\r\n"                                                  // Need an extra \r\n at the end
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(headers))) // Never change, Go
+
+	mimeHeader, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("-H: %s", err)
+	}
+
+	for key := range mimeHeader {
+		if !validHeaderKey(key) {
+			return nil, fmt.Errorf("-H: invalid header name %q", key)
+		}
+	}
+
+	return http.Header(mimeHeader), nil
+}
+
+// validHeaderKey is whether key is a token, as net/http insists header
+// names are when sending, though textproto reads ones that aren't
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	return strings.IndexFunc(key, func(r rune) bool {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return false
+		}
+		return !strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+	}) < 0
+}
+
+// checkRequests builds every target once, with its tokens expanded, so that
+// targets no request can be made from are reported before the run starts
+// rather than silently never sent
+func checkRequests(requests []request) error {
+	tokens := tokenExpander{trgt: &targeter{}}
+	for i, st := range requests {
+		url := tokens.expand(st.url)
+		req, err := http.NewRequest(st.method, url, nil)
+		if err != nil {
+			return fmt.Errorf("target %d, %s %s: %s", i+1, st.method, st.url, err)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("target %d, %s %s: expected an http or https url", i+1, st.method, st.url)
+		}
+		if req.URL.Host == "" {
+			return fmt.Errorf("target %d, %s %s: no host", i+1, st.method, st.url)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func Test_parseHeaderFlags(t *testing.T) {
+	t.Setenv("SLAPPER_TEST_TOKEN", "s3cret")
+
+	tests := []struct {
+		name    string
+		flags   []string
+		want    http.Header
+		wantErr bool
+	}{
+		{
+			name:  "headers",
+			flags: []string{"accept: text/plain", "X-Token: ${SLAPPER_TEST_TOKEN}", "x-token: again"},
+			want:  http.Header{"Accept": {"text/plain"}, "X-Token": {"s3cret", "again"}},
+		},
+		{name: "no colon", flags: []string{"Accept text/plain"}, wantErr: true},
+		{name: "space in key", flags: []string{"X Token: s3cret"}, wantErr: true},
+		{name: "unset variable", flags: []string{"X-Token: ${SLAPPER_TEST_UNSET}"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHeaderFlags(tt.flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHeaderFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHeaderFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []request
+		wantErr  bool
+	}{
+		{
+			name: "valid",
+			requests: []request{
+				{method: "GET", url: "http://localhost:8080/items/{{seq}}"},
+				{method: "POST", url: "https://example.com/cart"},
+			},
+		},
+		{name: "bad host", requests: []request{{method: "GET", url: "http://exa mple.com/"}}, wantErr: true},
+		{name: "bad method", requests: []request{{method: "GE T", url: "http://localhost/"}}, wantErr: true},
+		{name: "no scheme", requests: []request{{method: "GET", url: "localhost/items"}}, wantErr: true},
+		{name: "no host", requests: []request{{method: "GET", url: "http:///items"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkRequests(tt.requests); (err != nil) != tt.wantErr {
+				t.Errorf("checkRequests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Test vectors are from the AWS Signature Version 4 test suite
+func Test_sigv4Signer_sign(t *testing.T) {
+	signer := &sigv4Signer{
+		region:    "us-east-1",
+		service:   "service",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     []byte
+		wantAuth string
+	}{
+		{
+			name:     "get-vanilla",
+			method:   "GET",
+			url:      "https://example.amazonaws.com/",
+			wantAuth: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:     "post-vanilla",
+			method:   "POST",
+			url:      "https://example.amazonaws.com/",
+			wantAuth: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer.sign(req, tt.body, now)
+
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("sign() X-Amz-Date = %v, want %v", got, "20150830T123600Z")
+			}
+			if got := req.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("sign() Authorization = %v, want %v", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func Test_sigv4Signer_nextRequest(t *testing.T) {
+	trgt := targeter{
+		requests: []request{
+			request{
+				method: "PUT",
+				url:    "https://bucket.s3.amazonaws.com/key",
+				body:   []byte("foo"),
+			},
+		},
+		signer: &sigv4Signer{
+			region:       "eu-west-1",
+			service:      "s3",
+			accessKey:    "AKIDEXAMPLE",
+			secretKey:    "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			sessionToken: "token",
+		},
+	}
+
+	req, err := trgt.nextRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := time.Parse(sigv4DateFmt, req.Header.Get("X-Amz-Date")); err != nil {
+		t.Errorf("nextRequest() X-Amz-Date: %v", err)
+	}
+	// sha256("foo")
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Errorf("nextRequest() X-Amz-Content-Sha256 = %v", got)
+	}
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("nextRequest() X-Amz-Security-Token = %v, want token", got)
+	}
+
+	auth := req.Header.Get("Authorization")
+	wantPrefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/" + req.Header.Get("X-Amz-Date")[:8] + "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature="
+	if len(auth) != len(wantPrefix)+64 || auth[:len(wantPrefix)] != wantPrefix {
+		t.Errorf("nextRequest() Authorization = %v", auth)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// sizeBuckets is how many buckets the size histogram has, the first for
+// bodies smaller than -size-min and the last for ones of -size-max or more
+const sizeBuckets = 22
+
+// sizeHistogram counts response bodies by size, in buckets growing by the
+// same factor from -size-min to -size-max
+type sizeHistogram struct {
+	bounds []int64 // bounds[i] is where bucket i+1 starts
+	counts []counter
+}
+
+// responseSizes has the size of every response body read or drained
+var responseSizes *sizeHistogram
+
+// sizesShown is non-zero to draw the size histogram instead of the latency one
+var sizesShown counter
+
+// newSizeHistogram makes a histogram telling apart sizes from min, or 1 if
+// it is 0, to max bytes. An empty body is always in the first bucket. Bounds
+// rounding to the same byte count are kept once, so small ranges have fewer
+// than sizeBuckets buckets.
+func newSizeHistogram(min, max int64) (*sizeHistogram, error) {
+	lower := min
+	if lower < 1 {
+		lower = 1
+	}
+	if max <= lower {
+		return nil, errors.New("-size-max must be more than -size-min")
+	}
+
+	h := &sizeHistogram{bounds: make([]int64, 0, sizeBuckets-1)}
+	base := math.Pow(float64(max)/float64(lower), 1/float64(sizeBuckets-2))
+	for i := 0; i < sizeBuckets-1; i++ {
+		bound := int64(math.Round(float64(lower) * math.Pow(base, float64(i))))
+		if i == sizeBuckets-2 {
+			bound = max
+		}
+		if n := len(h.bounds); n == 0 || bound > h.bounds[n-1] {
+			h.bounds = append(h.bounds, bound)
+		}
+	}
+	h.counts = make([]counter, len(h.bounds)+1)
+
+	return h, nil
+}
+
+// bucket is the bucket of a body of size bytes
+func (h *sizeHistogram) bucket(size int64) int {
+	return sort.Search(len(h.bounds), func(i int) bool { return h.bounds[i] > size })
+}
+
+func (h *sizeHistogram) record(size int64) {
+	h.counts[h.bucket(size)].Add(1)
+}
+
+func (h *sizeHistogram) reset() {
+	for i := range h.counts {
+		h.counts[i].Store(0)
+	}
+}
+
+// snapshot copies the counts, for a consistent view
+func (h *sizeHistogram) snapshot() []int64 {
+	counts := make([]int64, len(h.counts))
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+	}
+
+	return counts
+}
+
+// rangeLabel is the size range of buckets first through last
+func (h *sizeHistogram) rangeLabel(first, last int) string {
+	switch {
+	case first == 0 && last == len(h.counts)-1:
+		return "all"
+	case first == 0 && h.bounds[last] == 1:
+		return "0B"
+	case first == 0:
+		return "<" + formatBytes(h.bounds[last])
+	case last == len(h.counts)-1:
+		return formatBytes(h.bounds[first-1]) + "+"
+	}
+
+	return formatBytes(h.bounds[first-1]) + "-" + formatBytes(h.bounds[last])
+}
+
+// renderSizes draws the size histogram in at most rows bars, merging
+// neighbouring buckets like the latency histogram does, the longest bar
+// spanning barWidth. With percent, every bar is its share of all bodies.
+func renderSizes(w io.Writer, p *palette, h *sizeHistogram, rows, barWidth int, percent bool) {
+	counts := h.snapshot()
+	bars := plotRows(len(counts), rows)
+	sums := make([]int64, len(bars))
+	max, total := int64(1), int64(0)
+	for row, bkts := range bars {
+		for bkt := bkts[0]; bkt <= bkts[1]; bkt++ {
+			sums[row] += counts[bkt]
+		}
+		if sums[row] > max {
+			max = sums[row]
+		}
+		total += sums[row]
+	}
+
+	width := float64(barWidth) / float64(max)
+	if percent && total > 0 {
+		width = float64(barWidth) / float64(total)
+	}
+
+	for row, bkts := range bars {
+		cell := fmt.Sprintf("%6d", sums[row])
+		if percent && total > 0 {
+			cell = fmt.Sprintf("%5.1f%%", 100*float64(sums[row])/float64(total))
+		}
+
+		n := int(float64(sums[row]) * width)
+		bar := paint(p.bucketColor(uint(row), uint(len(bars))), strings.Repeat("*", n)+strings.Repeat(" ", barWidth-n))
+		fmt.Fprintf(w, "%13s: [%s] %s \r\n", h.rangeLabel(bkts[0], bkts[1]), paint(p.ok, cell), bar)
+	}
+}
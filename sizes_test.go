@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_sizeHistogram(t *testing.T) {
+	h, err := newSizeHistogram(0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int64{0, 0, 1, 3, 3, 3, 1023, 1024, 1 << 20, 5 << 20} {
+		h.record(size)
+	}
+
+	want := map[int]int64{0: 2, 1: 1, 2: 3, 10: 1, 11: 1, sizeBuckets - 1: 2}
+	for bkt, got := range h.snapshot() {
+		if got != want[bkt] {
+			t.Errorf("bucket %d (%s) counted %d bodies, want %d", bkt, h.rangeLabel(bkt, bkt), got, want[bkt])
+		}
+	}
+
+	h.reset()
+	for bkt, got := range h.snapshot() {
+		if got != 0 {
+			t.Errorf("bucket %d counted %d bodies after reset, want 0", bkt, got)
+		}
+	}
+
+	for _, bounds := range [][2]int64{{0, 1}, {100, 100}, {200, 100}} {
+		if _, err := newSizeHistogram(bounds[0], bounds[1]); err == nil {
+			t.Errorf("newSizeHistogram(%d, %d), want an error", bounds[0], bounds[1])
+		}
+	}
+}
+
+func Test_sizeHistogramSmallRange(t *testing.T) {
+	h, err := newSizeHistogram(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}; !reflect.DeepEqual(h.bounds, want) {
+		t.Errorf("bounds = %v, want %v", h.bounds, want)
+	}
+	if got, want := len(h.counts), 11; got != want {
+		t.Errorf("%d buckets, want %d", got, want)
+	}
+	for bkt := 1; bkt < len(h.counts); bkt++ {
+		if cur, prev := h.rangeLabel(bkt, bkt), h.rangeLabel(bkt-1, bkt-1); cur == prev {
+			t.Errorf("buckets %d and %d are both %s", bkt-1, bkt, cur)
+		}
+	}
+}
+
+func Test_sizeHistogramRangeLabel(t *testing.T) {
+	h, err := newSizeHistogram(0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from100, err := newSizeHistogram(100, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		h           *sizeHistogram
+		first, last int
+		want        string
+	}{
+		{h, 0, sizeBuckets - 1, "all"},
+		{h, 0, 0, "0B"},
+		{h, 0, 5, "<32B"},
+		{h, 11, 11, "1.0KB-2.0KB"},
+		{h, sizeBuckets - 1, sizeBuckets - 1, "1.0MB+"},
+		{from100, 0, 0, "<100B"},
+	}
+	for _, tt := range tests {
+		if got := tt.h.rangeLabel(tt.first, tt.last); got != tt.want {
+			t.Errorf("rangeLabel(%d, %d) = %q, want %q", tt.first, tt.last, got, tt.want)
+		}
+	}
+}
+
+func Test_renderSizes(t *testing.T) {
+	h, err := newSizeHistogram(0, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.record(0)
+	h.record(2048)
+	h.record(2048)
+
+	var buf bytes.Buffer
+	renderSizes(&buf, palettes["mono"], h, 11, 20, false)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 11 {
+		t.Fatalf("renderSizes() drew %d lines, want 11:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[0], "[     1]") || !strings.Contains(lines[0], strings.Repeat("*", 10)+" ") {
+		t.Errorf("renderSizes() first row = %q, want the empty body at half the width", lines[0])
+	}
+	if !strings.Contains(lines[6], "[     2]") || !strings.Contains(lines[6], strings.Repeat("*", 20)) {
+		t.Errorf("renderSizes() seventh row = %q, want both 2KB bodies at full width", lines[6])
+	}
+
+	buf.Reset()
+	renderSizes(&buf, palettes["mono"], h, 11, 20, true)
+	if !strings.Contains(buf.String(), " 66.7%") {
+		t.Errorf("renderSizes() with percent = %q, want the 2KB bodies' share", buf.String())
+	}
+}
+
+func Test_consumeBodyRecordsSize(t *testing.T) {
+	var err error
+	if responseSizes, err = newSizeHistogram(0, 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { responseSizes = nil }()
+
+	for _, mode := range []int{bodyRead, bodyDiscard, bodySkip} {
+		if _, err := consumeBody(ioutil.NopCloser(strings.NewReader("hello")), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := responseSizes.snapshot()[responseSizes.bucket(5)]; got != 2 {
+		t.Errorf("consumeBody() recorded %d bodies of 5 bytes, want the read and drained ones", got)
+	}
+}
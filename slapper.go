@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/tls"
+	"context"
 	"encoding/base64"
 	"errors"
 	"flag"
@@ -13,14 +13,17 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
-	"net/textproto"
+	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	term "github.com/nsf/termbox-go"
@@ -42,31 +45,170 @@ const (
 
 var (
 	requestsSent      counter
+	requestsTotal     counter // like requestsSent, but never reset
+	errorsTotal       counter // responses that weren't ok and failed requests, never reset
 	responsesReceived counter
 	responses         [1024]counter
 	desiredRate       counter
+	workerCount       counter
 
-	timingsOk  [][]counter
-	timingsBad [][]counter
+	timingsOk       [][]counter
+	timingsDegraded [][]counter // ok, but slower than degradedMs
+	timingsBad      [][]counter
+
+	// all responses since the start (or the last reset) by totalBuckets
+	// latency bucket
+	timingsTotal = make([]counter, totalBuckets)
+	runStart     counter // UnixNano of the start, or the last reset
+
+	// most recent results, shown in the tail pane (nil when disabled)
+	recentResults *resultLog
+
+	// what attack does with response bodies
+	responseBodyMode = bodyRead
+
+	// bytes of each body read into memory when set, the rest is drained
+	maxBodyRead int64
+	bytesRead   counter // of response bodies, read or drained
+
+	// caps the requests in flight when set, ticks beyond it are skipped
+	inflightSlots chan struct{}
+	skippedTicks  counter
+
+	// ticks nobody was ready to take because all workers were busy
+	droppedTicks counter
+
+	// non-zero to show latency buckets as percentages of all requests
+	percentShown counter
+
+	// requests sent and body bytes read in the last second, as measured by the reporter
+	currentRate, currentByteRate counter
+
+	// ticks attack is done with, whether their request was sent, skipped or failed
+	ticksHandled counter
+
+	// canceled to abandon the requests still in flight once the drain
+	// window after quitting is over. Responses they never got aren't counted.
+	inflightCtx, abandonInflight = context.WithCancel(context.Background())
+	abandoned                    counter
+
+	// how long a request may take, including reading the body. Methods
+	// without a timeout of their own get requestTimeout.
+	requestTimeout = 30 * time.Second
+	methodTimeouts = map[string]time.Duration{}
+
+	// requests still going after softDeadline, if set, are canceled and
+	// counted in requestsCanceled instead of as failures
+	softDeadline     time.Duration
+	requestsCanceled counter
+
+	// how long a worker pauses after each request before taking the next tick
+	thinkTime durationRange
+
+	// checks responses when set, failures count as errors
+	responseValidator *validator
+	validationFailed  counter
+	headerMismatches  counter // responses without an -expect-header
+	responsesOk       counter
+
+	// layoutMu guards the screen layout and the timing buffers sized by it,
+	// both of which are rebuilt when the terminal is resized
+	layoutMu  sync.RWMutex
+	layoutGen counter // bumped on every layout change
 
 	terminalWidth  uint
 	terminalHeight uint
+	tooSmall       bool // to draw in, since the last resize
 
 	// plotting vars
 	plotWidth  uint
 	plotHeight uint
+	tailHeight uint
 
 	// first bucket is for requests faster then minY,
 	// last of for ones slower then maxY
 	buckets    uint
 	logBase    float64
 	minY, maxY float64
-	startMs    float64
+
+	// number of buckets from -buckets, merged into the plot rows when there
+	// are more of them. 0 for a bucket per row.
+	fixedBuckets uint
+
+	// base of the bucket boundaries from -log-base, which then sets the
+	// number of buckets. 0 to fit the buckets between minY and maxY instead.
+	fixedLogBase float64
+
+	// latency objective marked on the plot, 0 when unset
+	sloMs float64
+
+	// ok responses slower than this are counted as degraded, 0 when unset
+	degradedMs float64
+
+	// source of the random strings of url expansions, seeded by -url-seed
+	// to get the same urls on every run. nil to use the global source.
+	urlRand *rand.Rand
 )
 
+// resetStats starts the stats over, totals and moving window alike
 func resetStats() {
+	resetCumulative()
+	resetWindow()
+}
+
+// resetCumulative starts over the totals kept since the start of the run,
+// percentiles, per endpoint and per status stats included, leaving the
+// moving window as it is
+func resetCumulative() {
 	requestsSent.Store(0)
 	responsesReceived.Store(0)
+	bytesRead.Store(0)
+	skippedTicks.Store(0)
+	requestsCanceled.Store(0)
+	droppedTicks.Store(0)
+	validationFailed.Store(0)
+	headerMismatches.Store(0)
+	responsesOk.Store(0)
+	connsReused.Store(0)
+	connsNew.Store(0)
+	uploadNanos.Store(0)
+	uploadLatencyNanos.Store(0)
+	uploadsTimed.Store(0)
+
+	for i := 0; i < len(timingsTotal); i++ {
+		timingsTotal[i].Store(0)
+	}
+	resetEndpoints()
+	if latencyHDR != nil {
+		latencyHDR.reset()
+	}
+	if responseSizes != nil {
+		responseSizes.reset()
+	}
+	runStart.Store(time.Now().UnixNano())
+
+	for i := 0; i < len(responses); i++ {
+		responses[i].Store(0)
+	}
+
+	for i := 0; i < len(negotiatedTLS); i++ {
+		negotiatedTLS[i].Store(0)
+	}
+
+	for i := 0; i < len(grpcStatuses); i++ {
+		grpcStatuses[i].Store(0)
+	}
+
+	if slowRequests != nil {
+		slowRequests.reset()
+	}
+}
+
+// resetWindow empties the moving window the histogram and heatmap are drawn
+// from, and the tail pane, leaving the totals as they are
+func resetWindow() {
+	layoutMu.RLock()
+	defer layoutMu.RUnlock()
 
 	for _, ok := range timingsOk {
 		for i := 0; i < len(ok); i++ {
@@ -74,47 +216,189 @@ func resetStats() {
 		}
 	}
 
+	for _, degraded := range timingsDegraded {
+		for i := 0; i < len(degraded); i++ {
+			degraded[i].Store(0)
+		}
+	}
+
 	for _, bad := range timingsBad {
 		for i := 0; i < len(bad); i++ {
 			bad[i].Store(0)
 		}
 	}
 
-	for i := 0; i < len(responses); i++ {
-		responses[i].Store(0)
+	if recentResults != nil {
+		recentResults.reset()
 	}
 }
 
 type counter int64
 
 type charrange struct {
-	min rune
-	max rune
+	min    rune
+	max    rune
+	weight int // how much likelier each of its characters is than one from a range of weight 1
 }
 
 func (c *counter) Add(v int64) int64 { return atomic.AddInt64((*int64)(c), v) }
 func (c *counter) Load() int64       { return atomic.LoadInt64((*int64)(c)) }
 func (c *counter) Store(v int64)     { atomic.StoreInt64((*int64)(c), v) }
 
+// result is the outcome of a single request, as shown in the tail pane
+type result struct {
+	method  string
+	url     string
+	status  int // 0 means the request failed without a response
+	elapsed time.Duration
+}
+
+func (r result) String() string {
+	status := "error"
+	if r.status != 0 {
+		status = strconv.Itoa(r.status)
+	}
+	return fmt.Sprintf("%s %s -> %s (%.1f ms)", r.method, r.url, status, float64(r.elapsed)/float64(time.Millisecond))
+}
+
+// resultLog is a bounded ring of the most recent results
+type resultLog struct {
+	mu      sync.Mutex
+	entries []result
+	next    int
+	full    bool
+}
+
+func newResultLog(size int) *resultLog {
+	return &resultLog{entries: make([]result, size)}
+}
+
+func (l *resultLog) add(r result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == 0 {
+		return
+	}
+
+	l.entries[l.next] = r
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// recent returns a copy of the stored results, oldest first
+func (l *resultLog) recent() []result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]result(nil), l.entries[:l.next]...)
+	}
+
+	out := make([]result, 0, len(l.entries))
+	out = append(out, l.entries[l.next:]...)
+	return append(out, l.entries[:l.next]...)
+}
+
+func (l *resultLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.next = 0
+	l.full = false
+}
+
+// renderTail writes exactly lines lines, the most recent entries at the
+// bottom, each padded or truncated to width so stale output gets overwritten
+func renderTail(w io.Writer, entries []result, lines, width int) {
+	if len(entries) > lines {
+		entries = entries[len(entries)-lines:]
+	}
+
+	if width < 1 {
+		width = 1
+	}
+
+	for i := 0; i < lines; i++ {
+		var line string
+		if idx := i - (lines - len(entries)); idx >= 0 {
+			line = entries[idx].String()
+		}
+
+		if len(line) > width-1 {
+			line = line[:width-1]
+		}
+
+		fmt.Fprintf(w, "%-*s\r\n", width-1, line)
+	}
+}
+
 type targeter struct {
 	idx      counter
-	requests []request
+	seq      counter // next value substituted for {{seq}}
+	mu       sync.RWMutex
+	requests []request // guarded by mu, as -control-addr adds to them
 	header   http.Header
+	signer   *sigv4Signer   // signs every request when set
+	oauth2   *oauth2Source  // bearer token of every request when set
+	chunked  bool           // send bodies with chunked transfer encoding
+	repeat   int64          // times each target is sent in a row, if more than 1
+	grpc     bool           // send bodies as the message of gRPC unary calls
+	grpcWeb  string         // binary or text to send them as gRPC-Web calls instead, "" for neither
+	form     *multipartForm // sent as the body of every request when set
+
+	// encoded for the body of every request when set, instead of form
+	urlencoded *urlencodedForm
+
+	// executed for the body of every request instead of the target's, if set
+	bodyTemplate *template.Template
+
+	// files sent as the bodies of requests instead of the targets', if set
+	bodyFiles *bodyFiles
+
+	// header set to a fresh UUID on every non-GET request, if any
+	idempotencyKey string
+
+	// users taking turns at sending the targets with a session header, if set
+	vusers *virtualUsers
+
+	// prepares the requests -control-addr adds like the ones read at the
+	// start, if set
+	admit func(requests []request) ([]request, error)
 }
 
 type request struct {
 	method string
 	url    string
 	body   []byte
+	tags   []string    // from the #tag= lines before it
+	proto  string      // h1 or h2 from the #proto= line before it, "" for the default
+	header http.Header // set on top of the -H headers, from JSON targets
+
+	// the target as written, which expanded requests share
+	endpoint string
 }
 
-func newTargeter(targets string, base64body bool) (*targeter, error) {
-	var f *os.File
+// newTargeter reads targets from a file, an http(s) URL, or stdin if targets
+// is empty. If any tags are given, only the requests with one of them are
+// kept. With sorted, they are sent in the order of sortRequests instead of
+// the file's.
+func newTargeter(targets, format string, base64body, sorted bool, tags, hosts []string) (*targeter, error) {
+	var f io.ReadCloser
 	var err error
 
-	if targets == "" {
+	switch {
+	case targets == "":
 		f = os.Stdin
-	} else {
+	case strings.HasPrefix(targets, "http://") || strings.HasPrefix(targets, "https://"):
+		f, err = fetchTargets(targets)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	default:
 		f, err = os.Open(targets)
 		if err != nil {
 			return nil, err
@@ -123,21 +407,184 @@ func newTargeter(targets string, base64body bool) (*targeter, error) {
 	}
 
 	trgt := &targeter{}
-	err = trgt.readTargets(f, base64body)
+	if err = trgt.readFormat(f, format, base64body); err != nil {
+		return trgt, err
+	}
+
+	if len(tags) > 0 {
+		trgt.requests = selectTags(trgt.requests, tags)
+		if len(trgt.requests) == 0 {
+			return trgt, fmt.Errorf("no targets tagged %s", strings.Join(tags, ", "))
+		}
+	}
+
+	if sorted {
+		sortRequests(trgt.requests)
+	}
+
+	if len(hosts) > 0 {
+		if trgt.requests, err = crossHosts(trgt.requests, hosts); err != nil {
+			return trgt, err
+		}
+	}
+
+	return trgt, nil
+}
+
+// readFormat reads targets from reader in format, text, json or jsonl
+func (trgt *targeter) readFormat(reader io.Reader, format string, base64body bool) error {
+	switch format {
+	case "text", "":
+		return trgt.readTargets(reader, base64body)
+	case "json":
+		return trgt.readJSONTargets(reader, base64body)
+	case "jsonl":
+		return trgt.readJSONLTargets(reader, base64body)
+	}
+
+	return fmt.Errorf("invalid -format %q, must be text, json or jsonl", format)
+}
+
+// sortRequests orders requests by method, then url, after expanding them.
+// Requests that only differ in their bodies or headers keep their order.
+func sortRequests(requests []request) {
+	sort.SliceStable(requests, func(i, j int) bool {
+		if requests[i].method != requests[j].method {
+			return requests[i].method < requests[j].method
+		}
+		return requests[i].url < requests[j].url
+	})
+}
+
+// shuffleRequests puts requests in a random order, drawn from rnd if it is
+// set, so that a seed gives the same order every time
+func shuffleRequests(requests []request, rnd *rand.Rand) {
+	shuffle := rand.Shuffle
+	if rnd != nil {
+		shuffle = rnd.Shuffle
+	}
+
+	shuffle(len(requests), func(i, j int) { requests[i], requests[j] = requests[j], requests[i] })
+}
+
+// crossHosts sends every request, whose url is a path, to each of hosts.
+// The hosts of a path follow each other, so that all hosts share the load
+// at any time. Every path on every host is an endpoint of its own.
+func crossHosts(requests []request, hosts []string) ([]request, error) {
+	crossed := make([]request, 0, len(requests)*len(hosts))
+	for _, req := range requests {
+		if !strings.HasPrefix(req.url, "/") {
+			return nil, fmt.Errorf("with -hosts, targets must be paths starting with /, got %s", req.url)
+		}
+
+		pattern := strings.TrimPrefix(req.endpoint, req.method+" ")
+		for _, host := range hosts {
+			on := req
+			on.url = host + req.url
+			on.endpoint = endpointName(req.method, host+pattern)
+			crossed = append(crossed, on)
+		}
+	}
+
+	return crossed, nil
+}
+
+// readHosts reads a -hosts file of one host per line, e.g.
+// http://10.0.0.1:8080, where hosts without a scheme get http://. Blank
+// lines and lines starting with # are skipped.
+func readHosts(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		host := strings.TrimSpace(scanner.Text())
+		if host == "" || strings.HasPrefix(host, "#") {
+			continue
+		}
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		hosts = append(hosts, strings.TrimSuffix(host, "/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-	return trgt, err
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", file)
+	}
+
+	return hosts, nil
+}
+
+// selectTags keeps the requests having any of tags
+func selectTags(requests []request, tags []string) []request {
+	var selected []request
+	for _, req := range requests {
+	tags:
+		for _, have := range req.tags {
+			for _, want := range tags {
+				if have == want {
+					selected = append(selected, req)
+					break tags
+				}
+			}
+		}
+	}
+
+	return selected
+}
+
+// splitTags splits a comma separated list of tags, dropping empty ones
+func splitTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// fetchTargets gets a targets file over HTTP
+func fetchTargets(targets string) (io.ReadCloser, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	resp, err := client.Get(targets)
+	if err != nil {
+		return nil, fmt.Errorf("fetching targets: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching targets from %s: %s", targets, resp.Status)
+	}
+
+	return resp.Body, nil
 }
 
 func (trgt *targeter) readTargets(reader io.Reader, base64body bool) error {
 	// syntax
+	// #tag=<tag>[,<tag>...]\n
+	// #proto=h1|h2|h2c\n
 	// GET <url>\n
 	// $ <body>\n
 	// \n
+	//
+	// Other lines starting with # are comments.
 
 	var (
 		method string
 		url    string
 		body   []byte
+		tags   []string
+		proto  string
 	)
 
 	scanner := bufio.NewScanner(reader)
@@ -161,6 +608,24 @@ func (trgt *targeter) readTargets(reader io.Reader, base64body bool) error {
 			continue
 		}
 
+		if strings.HasPrefix(line, "#") {
+			if list := strings.TrimPrefix(line, "#tag="); list != line {
+				tags = append(tags, splitTags(list)...)
+			}
+			if name := strings.TrimPrefix(line, "#proto="); name != line {
+				var err error
+				if proto, err = lookupProto(name); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		var err error
+		if line, err = expandEnv(line); err != nil {
+			return err
+		}
+
 		parts := strings.SplitAfterN(line, " ", 2)
 		method = strings.TrimSpace(parts[0])
 		url = strings.TrimSpace(parts[1])
@@ -171,43 +636,65 @@ func (trgt *targeter) readTargets(reader io.Reader, base64body bool) error {
 			body = []byte{}
 		} else if line == "{}" {
 			body = []byte{}
-		} else if !strings.HasPrefix(line, "$ ") {
+		} else if text, encoded, isBody := bodyLine(line, base64body); !isBody {
 			body = []byte{}
 			lastLine = line
-		} else {
-			line = strings.TrimPrefix(line, "$ ")
-			if base64body {
-				var err error
-				body, err = base64.StdEncoding.DecodeString(line)
-				if err != nil {
-					return err
-				}
-			} else {
-				body = []byte(line)
+		} else if encoded {
+			if body, err = base64.StdEncoding.DecodeString(text); err != nil {
+				return err
 			}
+		} else if text, err = expandEnv(text); err != nil {
+			return err
+		} else {
+			body = []byte(text)
 		}
 		urls, err := parseUrl(url)
 		if err != nil {
 			return err
 		}
+		endpoint := endpointName(method, strings.SplitN(url, " ", 2)[0])
 		requests := make([]request, len(urls))
 		for i, url := range urls {
 			requests[i] = request{
 				method: method,
 				url:    url,
 				body:   body,
+				tags:   tags,
+				proto:  proto,
+
+				endpoint: endpoint,
 			}
 		}
-		trgt.requests = append(trgt.requests, requests...)
+		if err := trgt.addRequests(requests); err != nil {
+			return err
+		}
+		tags, proto = nil, ""
 	}
 
 	return nil
 }
 
-// parseUrl will expand any urls containing random/range syntax
+// bodyLine reads a body line of a targets file, "$ <body>" or "$64 <base64
+// body>", giving the body and whether it is base64. With base64body, bodies
+// after "$ " are base64 as well. It returns false for any other line.
+func bodyLine(line string, base64body bool) (body string, encoded, ok bool) {
+	if text := strings.TrimPrefix(line, "$64 "); text != line {
+		return text, true, true
+	}
+	if text := strings.TrimPrefix(line, "$ "); text != line {
+		return text, base64body, true
+	}
+
+	return "", false, false
+}
+
+// parseUrl will expand any urls containing random/range syntax. Only
+// brackets holding a range or a random spec are expanded, so other brackets,
+// like the ones of filter[name]=x or ids[]=1 in a query, and the rest of the
+// url, %-encoding included, are left as they are.
 func parseUrl(url string) ([]string, error) {
-	detect := regexp.MustCompile(`\[(r?[^\]]*)\]`)
-	matches := detect.FindAllStringSubmatch(url, -1)
+	detect := regexp.MustCompile(`\[(r\d[^\]]*|\d+-\d+)\]`)
+	matches := detect.FindAllStringSubmatch(url[ipv6HostEnd(url):], -1)
 	orgurl := url
 	if res := strings.SplitN(url, " ", 2); len(res) == 2 {
 		url = res[0]
@@ -244,11 +731,19 @@ func parseUrl(url string) ([]string, error) {
 			ranges := strings.Split(a, "_")
 			var cr []charrange
 			for _, r := range ranges {
+				weight := 1
+				if parts := strings.SplitN(r, "*", 2); len(parts) == 2 {
+					r = parts[0]
+					weight, err = strconv.Atoi(parts[1])
+					if err != nil || weight < 1 {
+						return nil, fmt.Errorf("invalid weight %q", parts[1])
+					}
+				}
 				minmax := strings.Split(r, "-")
-				if len(minmax) != 2 || len(minmax[0]) > 1 || len(minmax[1]) > 1 {
+				if len(minmax) != 2 || len(minmax[0]) != 1 || len(minmax[1]) != 1 {
 					return nil, errors.New("invalid range")
 				}
-				cr = append(cr, charrange{min: []rune(minmax[0])[0], max: []rune(minmax[1])[0]})
+				cr = append(cr, charrange{min: []rune(minmax[0])[0], max: []rune(minmax[1])[0], weight: weight})
 			}
 			randstr := randomString(cr, l, count)
 			if result == nil {
@@ -258,10 +753,9 @@ func parseUrl(url string) ([]string, error) {
 				}
 			}
 			for i := range result {
-				result[i] = strings.Replace(result[i], fullmatch, randstr[i], -1)
+				result[i] = replaceRandom(result[i], ipv6HostEnd(result[i]), fullmatch, randstr[i])
 			}
 		} else { // assume it's just a range
-			fmt.Println(submatch, fullmatch)
 			min, _, err := getMinMax(submatch)
 			if err != nil {
 				return nil, err
@@ -273,7 +767,7 @@ func parseUrl(url string) ([]string, error) {
 				}
 			}
 			for i := range result {
-				result[i] = strings.Replace(result[i], fullmatch, strconv.Itoa(i+min), -1)
+				result[i] = replaceAfter(result[i], ipv6HostEnd(result[i]), fullmatch, strconv.Itoa(i+min))
 			}
 		}
 	}
@@ -281,12 +775,59 @@ func parseUrl(url string) ([]string, error) {
 	return result, nil
 }
 
+// ipv6HostEnd returns the index just past the brackets of an IPv6 literal
+// host, like the [::1] of http://[::1]:8080/, or 0 if the host is no such literal
+func ipv6HostEnd(url string) int {
+	start := strings.Index(url, "://")
+	if start < 0 {
+		return 0
+	}
+	start += len("://")
+
+	// skip any userinfo
+	if end := strings.IndexAny(url[start:], "/?#"); end >= 0 {
+		if at := strings.LastIndex(url[start:start+end], "@"); at >= 0 {
+			start += at + 1
+		}
+	} else if at := strings.LastIndex(url[start:], "@"); at >= 0 {
+		start += at + 1
+	}
+
+	if !strings.HasPrefix(url[start:], "[") {
+		return 0
+	}
+
+	end := strings.Index(url[start:], "]")
+	if end < 0 || !strings.Contains(url[start:start+end], ":") {
+		return 0
+	}
+
+	return start + end + 1
+}
+
+// replaceAfter replaces every old in s with new, leaving the first start bytes alone
+func replaceAfter(s string, start int, old, new string) string {
+	return s[:start] + strings.Replace(s[start:], old, new, -1)
+}
+
+// replaceRandom is replaceAfter for a random string, percent-encoded for the
+// path or query it lands in, so that characters like / ? # & or % in it
+// don't change the meaning of the url
+func replaceRandom(s string, start int, old, random string) string {
+	path, query := s[start:], ""
+	if q := strings.Index(path, "?"); q >= 0 {
+		path, query = path[:q], path[q:]
+	}
+
+	return s[:start] + strings.Replace(path, old, url.PathEscape(random), -1) +
+		strings.Replace(query, old, url.QueryEscape(random), -1)
+}
+
 // getCount will extract the count from a url, either by parsing the range or getting an explicit count. Range trumps a count
 func getCount(url string) (int, error) {
 	var count int
 	rng := regexp.MustCompile(`\[(\d+-\d+)\]`)
-	matches := rng.FindAllStringSubmatch(url, -1)
-	fmt.Println(matches)
+	matches := rng.FindAllStringSubmatch(url[ipv6HostEnd(url):], -1)
 	if len(matches) > 0 {
 		for _, match := range matches {
 			sub := match[1]
@@ -323,15 +864,24 @@ func getCount(url string) (int, error) {
 
 // randomString generates count random strings from the given range specifications
 func randomString(charranges []charrange, length, count int) []string {
+	// weighted ranges show up as many times in the list as their weight
 	var charlist string
 	for _, r := range charranges {
-		charlist += makeCharList(r)
+		weight := r.weight
+		if weight < 1 {
+			weight = 1
+		}
+		charlist += strings.Repeat(makeCharList(r), weight)
+	}
+	random := rand.Int63
+	if urlRand != nil {
+		random = urlRand.Int63
 	}
 	result := make([]string, count)
 	for i := 0; i < count; i++ {
 		b := make([]byte, length)
 		for i := range b {
-			b[i] = charlist[rand.Int63()%int64(len(charlist))]
+			b[i] = charlist[random()%int64(len(charlist))]
 		}
 		result[i] = string(b)
 	}
@@ -371,24 +921,89 @@ func makeCharList(in charrange) string {
 }
 
 func (trgt *targeter) nextRequest() (*http.Request, error) {
-	if len(trgt.requests) == 0 {
+	idx := trgt.idx.Add(1)
+	if trgt.repeat > 1 {
+		// the first call gets 1, so count the calls from 0 for whole runs of repeat
+		idx = (idx - 1) / trgt.repeat
+	}
+	st, ok := trgt.pick(idx)
+	if !ok {
 		return nil, errors.New("no requests")
 	}
 
-	idx := int(trgt.idx.Add(1))
-	st := trgt.requests[idx%len(trgt.requests)]
+	tokens := tokenExpander{trgt: trgt}
+	url, body := tokens.expand(st.url), tokens.expandBytes(st.body)
+
+	method := st.method
+	if trgt.bodyTemplate != nil {
+		var err error
+		body, err = renderBody(trgt.bodyTemplate, bodyData{
+			Seq:       tokens.sequence(),
+			UUID:      randomUUID(),
+			Timestamp: time.Now(),
+			Method:    method,
+			URL:       url,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if trgt.bodyFiles != nil {
+		body = trgt.bodyFiles.pick()
+	}
+	if trgt.form != nil {
+		body = trgt.form.body
+	}
+	if trgt.urlencoded != nil {
+		body = trgt.urlencoded.encode(&tokens)
+	}
+	if trgt.grpc {
+		method, body = http.MethodPost, grpcFrame(body)
+	}
+	if trgt.grpcWeb != "" {
+		method, body = http.MethodPost, grpcWebFrame(body, trgt.grpcWeb == "text")
+	}
 
 	req, err := http.NewRequest(
-		st.method,
-		st.url,
-		bytes.NewReader(st.body),
+		method,
+		url,
+		bytes.NewReader(body),
 	)
 	if err != nil {
 		return req, err
 	}
+	if st.endpoint != "" {
+		req = withEndpoint(req, st.endpoint)
+	}
+	if st.proto != "" {
+		req = withProto(req, st.proto)
+	}
+
+	if trgt.form != nil {
+		req.Header.Set("Content-Type", trgt.form.contentType)
+	}
+	if trgt.urlencoded != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if trgt.grpc {
+		setGRPCHeaders(req)
+	}
+	if trgt.grpcWeb != "" {
+		setGRPCWebHeaders(req, trgt.grpcWeb == "text")
+	}
+
+	if trgt.chunked && len(body) > 0 {
+		// hiding the length makes Go fall back to chunked encoding
+		req.ContentLength = -1
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
 
 	for key, headers := range trgt.header {
 		for _, header := range headers {
+			header = tokens.expand(header)
 			if key == "Host" {
 				req.Host = header
 			} else {
@@ -397,185 +1012,166 @@ func (trgt *targeter) nextRequest() (*http.Request, error) {
 		}
 	}
 
-	return req, err
-}
-
-func attack(trgt *targeter, timeout time.Duration, ch <-chan time.Time, quit <-chan struct{}) {
-	tr := &http.Transport{
-		DisableKeepAlives:   false,
-		DisableCompression:  true,
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     30 * time.Second,
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+	for key, headers := range st.header {
+		for i, header := range headers {
+			header = tokens.expand(header)
+			if key == "Host" {
+				req.Host = header
+			} else if i == 0 {
+				req.Header.Set(key, header)
+			} else {
+				req.Header.Add(key, header)
+			}
+		}
 	}
 
-	client := &http.Client{
-		Transport: tr,
-		Timeout:   timeout,
+	if trgt.oauth2 != nil {
+		req.Header.Set("Authorization", "Bearer "+trgt.oauth2.token())
 	}
 
-	for {
-		select {
-		case <-ch:
-			if request, err := trgt.nextRequest(); err == nil {
-				requestsSent.Add(1)
+	if trgt.idempotencyKey != "" && st.method != http.MethodGet {
+		req.Header.Set(trgt.idempotencyKey, randomUUID())
+	}
 
-				start := time.Now()
-				response, err := client.Do(request)
-				if err == nil {
-					_, err = ioutil.ReadAll(response.Body)
-					response.Body.Close()
-				}
-				now := time.Now()
+	if trgt.vusers != nil {
+		req.Header.Set(trgt.vusers.header, trgt.vusers.session(idx, trgt.requestCount()))
+	}
 
-				elapsed := now.Sub(start)
-				elapsedMs := float64(elapsed) / float64(time.Millisecond)
-				correctedElapsedMs := elapsedMs - startMs
-				elapsedBucket := int(math.Log(correctedElapsedMs) / math.Log(logBase))
-
-				// first bucket is for requests faster then minY,
-				// last of for ones slower then maxY
-				if elapsedBucket < 0 {
-					elapsedBucket = 0
-				} else if elapsedBucket >= int(buckets)-1 {
-					elapsedBucket = int(buckets) - 1
-				} else {
-					elapsedBucket = elapsedBucket + 1
-				}
+	if trgt.signer != nil {
+		trgt.signer.sign(req, body, time.Now())
+	}
 
-				responsesReceived.Add(1)
+	return req, err
+}
 
-				status := 0
-				if err == nil {
-					status = response.StatusCode
-				}
+const (
+	bodyRead    = iota // read the whole body into memory
+	bodyDiscard        // drain the body without keeping it
+	bodySkip           // close the body unread
+)
 
-				responses[status].Add(1)
-				tOk, tBad := getTimingsSlot(now)
-				if status >= 200 && status < 300 {
-					tOk[elapsedBucket].Add(1)
-				} else {
-					tBad[elapsedBucket].Add(1)
-				}
+// consumeBody finishes off a response body according to mode, returning
+// it if it was read, up to maxBodyRead. Skipping it is cheapest, but the
+// connection can then not be reused for keep-alive.
+func consumeBody(body io.ReadCloser, mode int) ([]byte, error) {
+	var data []byte
+	var drained int64
+	var err error
+	switch mode {
+	case bodyRead:
+		if maxBodyRead > 0 {
+			data, err = ioutil.ReadAll(io.LimitReader(body, maxBodyRead))
+			if err == nil {
+				drained, err = io.Copy(ioutil.Discard, body)
 			}
-		case <-quit:
-			return
+		} else {
+			data, err = ioutil.ReadAll(body)
 		}
+	case bodyDiscard:
+		drained, err = io.Copy(ioutil.Discard, body)
+	}
+	bytesRead.Add(int64(len(data)) + drained)
+	if responseSizes != nil && mode != bodySkip && err == nil {
+		responseSizes.record(int64(len(data)) + drained)
 	}
-}
 
-func reporter(quit <-chan struct{}) {
-	fmt.Print("\033[H")
-	for i := 0; i < int(terminalHeight); i++ {
-		fmt.Println(string(bytes.Repeat([]byte(" "), int(terminalWidth)-1)))
+	if cerr := body.Close(); err == nil {
+		err = cerr
 	}
 
-	var currentRate counter
-	go func() {
-		var lastSent int64
-		for range time.Tick(time.Second) {
-			curr := requestsSent.Load()
-			currentRate.Store(curr - lastSent)
-			lastSent = curr
-		}
-	}()
+	return data, err
+}
 
-	colors := []string{
-		"\033[38;5;46m", "\033[38;5;47m", "\033[38;5;48m", "\033[38;5;49m", // green
-		"\033[38;5;149m", "\033[38;5;148m", "\033[38;5;179m", "\033[38;5;176m", // yellow
-		"\033[38;5;169m", "\033[38;5;168m", "\033[38;5;197m", "\033[38;5;196m", // red
+// errSoftDeadline is the cause of requests canceled at softDeadline
+var errSoftDeadline = errors.New("canceled at -max-duration-per-request")
+
+// requestContext bounds a request with the given method by its timeout, or
+// by softDeadline if that comes first
+func requestContext(parent context.Context, method string) (context.Context, context.CancelFunc) {
+	timeout := timeoutFor(method)
+	if softDeadline > 0 && softDeadline < timeout {
+		return context.WithTimeoutCause(parent, softDeadline, errSoftDeadline)
 	}
 
-	colorMultiplier := float64(len(colors)) / float64(buckets)
-	barWidth := int(plotWidth) - reservedWidthSpace // reserve some space on right and left
+	return context.WithTimeout(parent, timeout)
+}
 
-	ticker := time.Tick(screenRefreshInterval)
+// timeoutFor returns the timeout for requests with the given method
+func timeoutFor(method string) time.Duration {
+	if timeout, ok := methodTimeouts[method]; ok {
+		return timeout
+	}
+
+	return requestTimeout
+}
+
+func attack(trgt *targeter, client *http.Client, ch <-chan time.Time, quit <-chan struct{}) {
 	for {
 		select {
-		case <-ticker:
-			// scratch arrays
-			tOk := make([]int64, len(timingsOk))
-			tBad := make([]int64, len(timingsBad))
-
-			// need to understand how long in longest bar,
-			// also take a change to copy arrays to have consistent view
-
-			max := int64(1)
-			for i := 0; i < len(timingsOk); i++ {
-				ok := timingsOk[i]
-				bad := timingsBad[i]
-
-				for j := 0; j < len(ok); j++ {
-					tOk[j] += ok[j].Load()
-					tBad[j] += bad[j].Load()
-					if sum := tOk[j] + tBad[j]; sum > max {
-						max = sum
-					}
+		case <-ch:
+			if inflightSlots != nil {
+				select {
+				case inflightSlots <- struct{}{}:
+				default:
+					skippedTicks.Add(1)
+					ticksHandled.Add(1)
+					continue
 				}
 			}
 
-			sent := requestsSent.Load()
-			recv := responsesReceived.Load()
-			fmt.Print("\033[H") // clean screen
-			fmt.Printf("sent: %-6d ", sent)
-			fmt.Printf("in-flight: %-2d ", sent-recv)
-			fmt.Printf("\033[96mrate: %4d/%d RPS\033[0m ", currentRate.Load(), desiredRate.Load())
-
-			fmt.Print("responses: ")
-			for status, counter := range responses {
-				if c := counter.Load(); c > 0 {
-					if status >= 200 && status < 300 {
-						fmt.Printf("\033[32m[%d]: %-6d\033[0m ", status, c)
-					} else {
-						fmt.Printf("\033[31m[%d]: %-6d\033[0m ", status, c)
+			if request, err := trgt.nextRequest(); err == nil && (requestsLeft == nil || requestsLeft.take()) {
+				requestsSent.Add(1)
+				requestsTotal.Add(1)
+
+				ctx, cancel := requestContext(request.Context(), request.Method)
+				stopAbandon := context.AfterFunc(inflightCtx, cancel)
+				request, phases := withConnTrace(request.WithContext(ctx))
+
+				var body []byte
+				start := time.Now()
+				response, err := client.Do(request)
+				if teeFirst != nil {
+					teeFirst.dump(request, response, err)
+				}
+				if err == nil {
+					body, err = consumeBody(response.Body, responseBodyMode)
+				}
+				now := time.Now()
+				if exchangeCaptures != nil && exchangeCaptures.sample() {
+					exchangeCaptures.record(request, response, body, err, now, now.Sub(start))
+				}
+				if !stopAbandon() && err != nil {
+					cancel()
+					abandoned.Add(1)
+					if inflightSlots != nil {
+						<-inflightSlots
 					}
+					ticksHandled.Add(1)
+					return
 				}
-			}
-			fmt.Print("\r\n\r\n")
+				cancel()
 
-			width := float64(barWidth) / float64(max)
-			for bkt := uint(0); bkt < buckets; bkt++ {
-				var label string
-				if bkt == 0 {
-					if startMs >= 10 {
-						label = fmt.Sprintf("<%.0f", startMs)
-					} else {
-						label = fmt.Sprintf("<%.1f", startMs)
-					}
-				} else if bkt == buckets-1 {
-					if maxY >= 10 {
-						label = fmt.Sprintf("%3.0f+", maxY)
-					} else {
-						label = fmt.Sprintf("%.1f+", maxY)
-					}
+				if err != nil && context.Cause(ctx) == errSoftDeadline {
+					requestsCanceled.Add(1)
 				} else {
-					beginMs := minY + math.Pow(logBase, float64(bkt-1))
-					endMs := minY + math.Pow(logBase, float64(bkt))
-
-					if endMs >= 10 {
-						label = fmt.Sprintf("%3.0f-%3.0f", beginMs, endMs)
-					} else {
-						label = fmt.Sprintf("%.1f-%.1f", beginMs, endMs)
+					countResponse(trgt, request, response, body, err, now, now.Sub(start))
+					if err == nil && request.ContentLength != 0 {
+						recordUpload(phases, start, now)
 					}
 				}
+			}
+
+			if inflightSlots != nil {
+				<-inflightSlots
+			}
+			ticksHandled.Add(1)
 
-				widthOk := int(float64(tOk[bkt]) * width)
-				widthBad := int(float64(tBad[bkt]) * width)
-				widthLeft := barWidth - widthOk - widthBad
-
-				fmt.Printf("%10s ms: [%s%6d%s/%s%6d%s] %s%s%s%s%s \r\n",
-					label,
-					"\033[32m",
-					tOk[bkt],
-					"\033[0m",
-					"\033[31m",
-					tBad[bkt],
-					"\033[0m",
-					colors[int(float64(bkt)*colorMultiplier)],
-					bytes.Repeat([]byte("E"), widthBad),
-					bytes.Repeat([]byte("*"), widthOk),
-					bytes.Repeat([]byte(" "), widthLeft),
-					"\033[0m")
+			if pause := thinkTime.random(); pause > 0 {
+				select {
+				case <-time.After(pause):
+				case <-quit:
+					return
+				}
 			}
 		case <-quit:
 			return
@@ -583,192 +1179,1999 @@ func reporter(quit <-chan struct{}) {
 	}
 }
 
-func keyPressListener(rateChanger chan<- int64) {
-	// start keyPress listener
-	err := term.Init()
-	if err != nil {
-		log.Fatal(err)
+// countResponse counts the outcome of request, which took elapsed until now,
+// in all the stats
+func countResponse(trgt *targeter, request *http.Request, response *http.Response, body []byte, err error, now time.Time, elapsed time.Duration) {
+	responsesReceived.Add(1)
+
+	status := 0
+	if err == nil {
+		status = response.StatusCode
 	}
 
-	defer term.Close()
+	responses[status].Add(1)
+	if recentResults != nil || slowRequests != nil {
+		r := result{
+			method:  request.Method,
+			url:     request.URL.String(),
+			status:  status,
+			elapsed: elapsed,
+		}
+		if recentResults != nil {
+			recentResults.add(r)
+		}
+		if slowRequests != nil {
+			slowRequests.observe(r, now)
+		}
+	}
 
-keyPressListenerLoop:
-	for {
-		switch ev := term.PollEvent(); ev.Type {
-		case term.EventKey:
-			switch ev.Key {
-			case term.KeyCtrlC:
-				break keyPressListenerLoop
-			default:
-				switch ev.Ch {
-				case 'q':
-					break keyPressListenerLoop
-				case 'r':
-					resetStats()
-				case 'k': // up
-					rateChanger <- rateIncreaseStep
-				case 'j':
-					rateChanger <- rateDecreaseStep
-				}
-			}
-		case term.EventError:
-			log.Fatal(ev.Err)
+	if status == http.StatusTooManyRequests && rateLimitBackoff != nil {
+		rateLimitBackoff.throttled(response.Header.Get("Retry-After"))
+	}
+
+	ok := isOK(status)
+	if err == nil && responseValidator != nil {
+		if !responseValidator.valid(status, body) {
+			validationFailed.Add(1)
+			ok = false
+		}
+		if !responseValidator.validHeaders(response.Header) {
+			headerMismatches.Add(1)
+			ok = false
+		}
+	}
+	if err == nil && (trgt.grpc || trgt.grpcWeb != "") {
+		var code int
+		var grpcErr error
+		if trgt.grpc {
+			code, grpcErr = grpcStatus(response)
+		} else {
+			code, grpcErr = grpcWebStatus(response, body, trgt.grpcWeb == "text")
+		}
+		if grpcErr == nil {
+			grpcStatuses[code].Add(1)
+		}
+		if grpcErr != nil || code != 0 {
+			ok = false
 		}
 	}
+	if ok {
+		responsesOk.Add(1)
+	} else {
+		errorsTotal.Add(1)
+		if stopOnFailure != nil {
+			stopOnFailure.fail(describeFailure(request, response, body, err))
+		}
+	}
+
+	recordTiming(now, elapsed, ok)
+	if latencyHDR != nil {
+		latencyHDR.record(elapsed)
+	}
+	recordEndpoint(endpointOf(request), elapsed)
 }
 
-func ticker(rate uint64, quit <-chan struct{}) (<-chan time.Time, chan<- int64) {
-	ticker := make(chan time.Time, 1)
-	rateChanger := make(chan int64, 1)
+// renderHistogram draws the latency buckets in at most rows bars, merging
+// neighbouring buckets into one bar when there are more. By default the
+// longest bar spans barWidth and the others are scaled to it. With percent,
+// every bar is its share of all requests, so bars add up to barWidth and runs
+// at different rates compare. Must be called with layoutMu held.
+func renderHistogram(w io.Writer, p *palette, tOk, tDegraded, tBad []int64, rows, barWidth, sloBucket int, percent bool) {
+	bars := plotRows(len(tOk), rows)
+	ok, degraded, bad := make([]int64, len(bars)), make([]int64, len(bars)), make([]int64, len(bars))
+	sloRow := -1
+	for row, bkts := range bars {
+		for bkt := bkts[0]; bkt <= bkts[1]; bkt++ {
+			ok[row] += tOk[bkt]
+			degraded[row] += tDegraded[bkt]
+			bad[row] += tBad[bkt]
+		}
+		if bkts[0] <= sloBucket && sloBucket <= bkts[1] {
+			sloRow = row
+		}
+	}
 
-	// start main workers
-	go func() {
-		desiredRate.Store(int64(rate))
-		tck := time.NewTicker(time.Duration(1e9 / rate))
+	max, total := int64(1), int64(0)
+	for row := range ok {
+		sum := ok[row] + degraded[row] + bad[row]
+		if sum > max {
+			max = sum
+		}
+		total += sum
+	}
 
-		for {
-			select {
-			case r := <-rateChanger:
-				tck.Stop()
-				if newRate := desiredRate.Add(r); newRate > 0 {
-					tck = time.NewTicker(time.Duration(1e9 / newRate))
-				} else {
-					desiredRate.Store(0)
-				}
-			case t := <-tck.C:
-				ticker <- t
-			case <-quit:
-				return
-			}
+	width := float64(barWidth) / float64(max)
+	shown := int64(0) // counts are shown as is
+	if percent {
+		if total == 0 {
+			total = 1
 		}
-	}()
+		width = float64(barWidth) / float64(total)
+		shown = total
+	}
 
-	return ticker, rateChanger
-}
+	for row, bkts := range bars {
+		widthOk := int(float64(ok[row]) * width)
+		widthDegraded := int(float64(degraded[row]) * width)
+		widthBad := int(float64(bad[row]) * width)
 
-func getTimingsSlot(now time.Time) ([]counter, []counter) {
-	n := int(now.UnixNano() / 100000000)
-	slot := n % len(timingsOk)
-	return timingsOk[slot], timingsBad[slot]
+		label := rangeLabel(uint(bkts[0]), uint(bkts[1]))
+		renderBucket(w, p, label, uint(row), uint(len(bars)), ok[row], degraded[row], bad[row], shown, widthOk, widthDegraded, widthBad, barWidth, row == sloRow)
+	}
 }
 
-func initializeTimingsBucket(buckets uint) {
-	timingsOk = make([][]counter, movingWindowsSize*screenRefreshFrequency)
-	for i := 0; i < len(timingsOk); i++ {
-		timingsOk[i] = make([]counter, buckets)
+// plotRows splits n buckets into at most rows runs of neighbouring ones, as
+// even as they go, giving the first and last bucket of each
+func plotRows(n, rows int) [][2]int {
+	if rows < 1 || rows > n {
+		rows = n
 	}
 
-	timingsBad = make([][]counter, movingWindowsSize*screenRefreshFrequency)
-	for i := 0; i < len(timingsBad); i++ {
-		timingsBad[i] = make([]counter, buckets)
+	runs := make([][2]int, rows)
+	for row := range runs {
+		runs[row] = [2]int{row * n / rows, (row+1)*n/rows - 1}
 	}
 
-	go func() {
-		for now := range time.Tick(screenRefreshInterval) {
-			// TODO account for missing ticks
-			// clean next timing slot which is last one in ring buffer
-			next := now.Add(screenRefreshInterval)
-			tOk, tBad := getTimingsSlot(next)
-			for i := 0; i < len(tOk); i++ {
-				tOk[i].Store(0)
-			}
+	return runs
+}
 
-			for i := 0; i < len(tBad); i++ {
-				tBad[i].Store(0)
-			}
+// rangeLabel is the latency range of buckets first through last, in
+// milliseconds. Must be called with layoutMu held.
+func rangeLabel(first, last uint) string {
+	if first == 0 && last == buckets-1 {
+		return "all"
+	}
+
+	perMs := displayUnit.perMs
+	begin, _ := bucketBoundsMs(first)
+	_, end := bucketBoundsMs(last)
+	begin, end = begin*perMs, end*perMs
+
+	if first == 0 {
+		if end >= 10 {
+			return fmt.Sprintf("<%.0f", end)
 		}
-	}()
+		return fmt.Sprintf("<"+labelFormat(end), end)
+	} else if last == buckets-1 {
+		return fmt.Sprintf(labelFormat(begin)+"+", begin)
+	}
+
+	f := labelFormat(end)
+	return fmt.Sprintf(f+"-"+f, begin, end)
 }
 
-type arrayFlags []string
+// bucketBoundsMs is where latency bucket bkt starts and where the next one
+// does, in ms: minY plus logBase to the power of bkt-1 and of bkt. The first
+// bucket is for requests faster than minY+1, from 0, and the last one for
+// everything from where it starts, never ending. Bucketing and labels both
+// go by these bounds, so that they agree. Must be called with layoutMu held.
+func bucketBoundsMs(bkt uint) (lower, upper float64) {
+	return logBoundsMs(logBase, buckets, bkt)
+}
 
-func (i *arrayFlags) String() string {
-	return fmt.Sprintf("+%v", *i)
+// bucketIndex is the latency bucket of a request taking elapsedMs, the one
+// whose bounds it is within, its lower bound included. Must be called with
+// layoutMu held.
+func bucketIndex(elapsedMs float64) int {
+	return logIndex(logBase, buckets, elapsedMs)
 }
 
-func (i *arrayFlags) Set(value string) error {
-	*i = append(*i, value)
-	return nil
+// logBoundsMs is where bucket bkt of n, growing by base past minY, starts
+// and where the next one does, in ms, as bucketBoundsMs describes
+func logBoundsMs(base float64, n, bkt uint) (lower, upper float64) {
+	lower, upper = 0, math.Inf(1)
+	if bkt > 0 {
+		lower = minY + math.Pow(base, float64(bkt-1))
+	}
+	if bkt < n-1 {
+		upper = minY + math.Pow(base, float64(bkt))
+	}
+
+	return lower, upper
 }
 
-var headerFlags arrayFlags
+// logIndex is the bucket of n, growing by base past minY, whose bounds
+// elapsedMs is within
+func logIndex(base float64, n uint, elapsedMs float64) int {
+	last := int(n) - 1
+	if !(elapsedMs-minY >= 1) {
+		return 0
+	}
 
-func main() {
-	workers := flag.Uint("workers", 8, "Number of workers")
-	timeout := flag.Duration("timeout", 30*time.Second, "Requests timeout")
-	targets := flag.String("targets", "", "Targets file")
-	base64body := flag.Bool("base64body", false, "Bodies in targets file are base64-encoded")
-	rate := flag.Uint64("rate", 50, "Requests per second")
-	miY := flag.Duration("minY", 0, "min on Y axe (default 0ms)")
-	maY := flag.Duration("maxY", 100*time.Millisecond, "max on Y axe")
-	flag.Var(&headerFlags, "H", "HTTP header 'key: value' set on all requests. Repeat for more than one header.")
-	flag.Parse()
+	bkt := int(math.Log(elapsedMs-minY)/math.Log(base)) + 1
+	if bkt > last {
+		bkt = last
+	}
 
-	terminalWidth, _ = terminal.Width()
-	terminalHeight, _ = terminal.Height()
+	// the logarithm can be a hair off right at a bound, which the bounds
+	// themselves settle
+	for bkt < last {
+		if _, upper := logBoundsMs(base, n, uint(bkt)); elapsedMs < upper {
+			break
+		}
+		bkt++
+	}
+	for bkt > 1 {
+		if lower, _ := logBoundsMs(base, n, uint(bkt)); elapsedMs >= lower {
+			break
+		}
+		bkt--
+	}
 
-	plotWidth = terminalWidth
-	plotHeight = terminalHeight - statsLines
+	return bkt
+}
 
-	if plotWidth <= reservedWidthSpace {
-		log.Fatal("not enough screen width, min 40 characters required")
+// sloBreach is the percentage of counts in buckets past sloBucket, those
+// certainly slower than the SLO
+func sloBreach(counts []int64, sloBucket int) float64 {
+	var total, above int64
+	for bkt, c := range counts {
+		total += c
+		if bkt > sloBucket {
+			above += c
+		}
 	}
 
-	if plotHeight <= reservedHeightSpace {
-		log.Fatal("not enough screen height, min 3 lines required")
+	if total == 0 {
+		return 0
 	}
 
-	minY, maxY = float64(*miY/time.Millisecond), float64(*maY/time.Millisecond)
-	deltaY := maxY - minY
-	buckets = plotHeight
-	logBase = math.Pow(deltaY, 1/float64(buckets-2))
-	startMs = minY + math.Pow(logBase, 0)
+	return 100 * float64(above) / float64(total)
+}
 
-	initializeTimingsBucket(buckets)
+// recordTiming puts a response that took elapsed into its latency bucket, as
+// degraded if it is ok but slower than degradedMs
+func recordTiming(now time.Time, elapsed time.Duration, ok bool) {
+	layoutMu.RLock()
+	defer layoutMu.RUnlock()
+
+	elapsedMs := float64(elapsed) / float64(time.Millisecond)
+	elapsedBucket := bucketIndex(elapsedMs)
+
+	timingsTotal[totalIndex(elapsedMs)].Add(1)
+	tOk, tDegraded, tBad := getTimingsSlot(now)
+	switch {
+	case !ok:
+		tBad[elapsedBucket].Add(1)
+	case degradedMs > 0 && elapsedMs > degradedMs:
+		tDegraded[elapsedBucket].Add(1)
+	default:
+		tOk[elapsedBucket].Add(1)
+	}
+}
 
-	quit := make(chan struct{}, 1)
-	ticker, rateChanger := ticker(*rate, quit)
+// statusLine is a snapshot of the counters shown at the top of the screen
+type statusLine struct {
+	sent      int64
+	recv      int64
+	ok        int64 // responses that were ok and passed validation
+	rate      int64
+	byteRate  int64 // of response bodies, per second
+	desired   int64
+	skipped   int64
+	canceled  int64 // at -max-duration-per-request
+	dropped   int64
+	invalid   int64
+	badHeader int64   // without an -expect-header
+	running   int64   // workers running
+	workers   int64   // suggested number of workers when lagging, 0 otherwise
+	reused    int64   // connections reused from the idle pool
+	newConns  int64   // connections opened
+	uploads   int64   // requests with a body whose upload was timed
+	uploadMs  float64 // mean time writing them
+	uploadPct float64 // of their latency
+	sloMs     float64
+	sloBreach float64 // percent of the plotted requests above sloMs
+	responses [len(responses)]int64
+	tls       [len(negotiatedTLS)]int64
+	grpc      [grpcCodes]int64
+}
 
-	trgt, err := newTargeter(*targets, *base64body)
-	if err != nil {
-		log.Fatal(err)
+// lineBuilder collects colored segments of a line as long as they fit in the visible width
+type lineBuilder struct {
+	buf  bytes.Buffer
+	left int
+	full bool
+}
+
+// add appends text in the given color, or marks the line full if it doesn't fit
+func (lb *lineBuilder) add(color, text string) bool {
+	if lb.full || len(text) > lb.left {
+		lb.full = true
+		return false
 	}
 
-	if len(headerFlags) > 0 {
-		headers := strings.Join(headerFlags, "\r\n")
-		headers += "\r\n\r\n"                                                  // Need an extra \r\n at the end
-		tp := textproto.NewReader(bufio.NewReader(strings.NewReader(headers))) // Never change, Go
+	lb.buf.WriteString(paint(color, text))
+	lb.left -= len(text)
 
-		mimeHeader, err := tp.ReadMIMEHeader()
-		if err != nil {
-			log.Fatal(err)
+	return true
+}
+
+// renderStatusLine writes the stats line, never wider than width-1 visible
+// characters. In quiet mode the per-status breakdown is collapsed into ok/err totals.
+func renderStatusLine(w io.Writer, st statusLine, width int, quiet bool) {
+	lb := lineBuilder{left: width - 1}
+
+	lb.add("", fmt.Sprintf("sent: %-6d ", st.sent))
+	lb.add("", fmt.Sprintf("in-flight: %-2d ", st.sent-st.recv))
+	if st.running > 0 {
+		lb.add("", fmt.Sprintf("workers: %-2d ", st.running))
+	}
+	lb.add(screenPalette.rate, fmt.Sprintf("rate: %4d/%d RPS", st.rate, st.desired))
+	if st.byteRate > 0 {
+		lb.add("", fmt.Sprintf(" %s/s", formatBytes(st.byteRate)))
+	}
+	if reuse := reusePercent(st.reused, st.newConns); reuse >= 0 {
+		lb.add("", fmt.Sprintf(" reuse: %d%%", reuse))
+	}
+	if st.uploads > 0 {
+		lb.add("", fmt.Sprintf(" upload: %s (%.0f%%)", displayUnit.format(st.uploadMs), st.uploadPct))
+	}
+	if st.skipped > 0 {
+		lb.add("", fmt.Sprintf(" skipped: %d", st.skipped))
+	}
+	if st.dropped > 0 {
+		lb.add(screenPalette.warn, fmt.Sprintf(" dropped: %d", st.dropped))
+	}
+	if st.canceled > 0 {
+		lb.add(screenPalette.warn, fmt.Sprintf(" canceled: %d", st.canceled))
+	}
+	if st.workers > 0 {
+		lb.add("", " ")
+		lb.add(screenPalette.warn, fmt.Sprintf("lagging, try -workers %d", st.workers))
+	}
+	for v, c := range st.tls {
+		if c > 0 {
+			lb.add("", fmt.Sprintf(" tls1.%d: %d", v, c))
+		}
+	}
+	for code, c := range st.grpc {
+		if c == 0 {
+			continue
 		}
 
-		trgt.header = http.Header(mimeHeader)
+		color := screenPalette.bad
+		if code == 0 {
+			color = screenPalette.ok
+		}
+		lb.add("", " ")
+		lb.add(color, fmt.Sprintf("grpc[%d]: %d", code, c))
+	}
+	if st.sloMs > 0 {
+		lb.add(screenPalette.warn, fmt.Sprintf(" >%g%s: %.1f%%", st.sloMs*displayUnit.perMs, displayUnit.name, st.sloBreach))
+	}
+	lb.add("", " responses: ")
+	if st.invalid > 0 {
+		lb.add(screenPalette.invalid, fmt.Sprintf("invalid: %d", st.invalid))
+		lb.add("", " ")
+	}
+	if st.badHeader > 0 {
+		lb.add(screenPalette.invalid, fmt.Sprintf("bad headers: %d", st.badHeader))
+		lb.add("", " ")
 	}
 
-	// start attackers
-	var wg sync.WaitGroup
-	for i := uint(0); i < *workers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			attack(trgt, *timeout, ticker, quit)
-		}()
+	if quiet {
+		// counted like the summary, so that responses failing validation
+		// are errors whatever their status
+		lb.add(screenPalette.ok, fmt.Sprintf("ok=%d", st.ok))
+		lb.add("", " ")
+		lb.add(screenPalette.bad, fmt.Sprintf("err=%d", st.recv-st.ok))
+	} else {
+		for status, c := range st.responses {
+			if c == 0 {
+				continue
+			}
+
+			color := screenPalette.bad
+			if isOK(status) {
+				color = screenPalette.ok
+			}
+
+			// keep room for the ellipsis in case the next one doesn't fit
+			entry := fmt.Sprintf("[%d]: %-6d ", status, c)
+			if len(entry)+len("...") > lb.left {
+				lb.add("", "...")
+				break
+			}
+			lb.add(color, entry)
+		}
 	}
 
-	// start reporter
-	wg.Add(1)
+	lb.buf.WriteString(strings.Repeat(" ", lb.left))
+	w.Write(lb.buf.Bytes())
+}
+
+// formatBytes is n bytes in the largest unit keeping it at 1 or more
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+
+	f, unit := float64(n), 0
+	for f >= 1024 && unit < len(units)-1 {
+		f /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	return fmt.Sprintf("%.1f%s", f, units[unit])
+}
+
+// clearScreen blanks the whole terminal, so a shrunk layout leaves nothing stale behind
+func clearScreen(width, height uint) {
+	if width == 0 {
+		return
+	}
+
+	fmt.Print("\033[H")
+	for i := 0; i < int(height); i++ {
+		fmt.Println(string(bytes.Repeat([]byte(" "), int(width)-1)))
+	}
+}
+
+// renderTooSmall says how big the terminal must be to draw in, in place of
+// the screen, cut to width
+func renderTooSmall(w io.Writer, width, height uint) {
+	msg := fmt.Sprintf("terminal too small, need %dx%d", reservedWidthSpace+1, statsLines+tailHeight+reservedHeightSpace+1)
+	if int(width) < len(msg) {
+		msg = msg[:width]
+	}
+	if height > 0 {
+		fmt.Fprint(w, "\033[H", msg)
+	}
+}
+
+const (
+	lagThreshold = 0.9 // of the desired rate
+	lagSeconds   = 5
+)
+
+// lagDetector notices when the achieved rate stays below the desired one,
+// a sign of too few workers for the target's latency
+type lagDetector struct {
+	behind int // consecutive seconds below lagThreshold
+}
+
+// observe takes one per second sample and reports whether the rate has been
+// lagging for at least lagSeconds in a row
+func (d *lagDetector) observe(achieved, desired int64) bool {
+	if desired > 0 && float64(achieved) < lagThreshold*float64(desired) {
+		d.behind++
+	} else {
+		d.behind = 0
+	}
+
+	return d.behind >= lagSeconds
+}
+
+// suggestWorkers scales workers by how far behind the achieved rate is.
+// Every worker has one request in flight at a time, so throughput grows
+// about linearly with their number until the target saturates.
+func suggestWorkers(workers, achieved, desired int64) int64 {
+	if achieved <= 0 {
+		return workers * 2
+	}
+
+	return int64(math.Ceil(float64(workers) * float64(desired) / float64(achieved)))
+}
+
+// windowTimings sums the timing slots of the window shown on the plot. Must
+// be called with layoutMu held.
+func windowTimings() (tOk, tDegraded, tBad []int64) {
+	tOk = make([]int64, buckets)
+	tDegraded = make([]int64, buckets)
+	tBad = make([]int64, buckets)
+
+	for i := 0; i < len(timingsOk); i++ {
+		ok := timingsOk[i]
+		degraded := timingsDegraded[i]
+		bad := timingsBad[i]
+
+		for j := 0; j < len(ok); j++ {
+			tOk[j] += ok[j].Load()
+			tDegraded[j] += degraded[j].Load()
+			tBad[j] += bad[j].Load()
+		}
+	}
+
+	return tOk, tDegraded, tBad
+}
+
+func reporter(quit <-chan struct{}, quiet bool) {
+	var suggestedWorkers counter // non-zero while the rate lags behind
+	var rates rateHistory
 	go func() {
-		defer wg.Done()
-		reporter(quit)
-	}()
+		var lastSent, lastBytes int64
+		var lag lagDetector
+		for range time.Tick(time.Second) {
+			curr := requestsSent.Load()
+			currentRate.Store(curr - lastSent)
+			lastSent = curr
+			rates.add(currentRate.Load())
 
-	keyPressListener(rateChanger)
+			read := bytesRead.Load()
+			currentByteRate.Store(read - lastBytes)
+			lastBytes = read
 
-	// bye
-	close(quit)
-	wg.Wait()
+			if lag.observe(currentRate.Load(), desiredRate.Load()) {
+				suggestedWorkers.Store(suggestWorkers(workerCount.Load(), currentRate.Load(), desiredRate.Load()))
+			} else {
+				suggestedWorkers.Store(0)
+			}
+		}
+	}()
+
+	drawnGen := int64(-1)
+	ticker := time.Tick(screenRefreshInterval)
+	for {
+		select {
+		case <-ticker:
+			layoutMu.RLock()
+			if gen := layoutGen.Load(); gen != drawnGen {
+				clearScreen(terminalWidth, terminalHeight)
+				drawnGen = gen
+			}
+			if tooSmall {
+				renderTooSmall(os.Stdout, terminalWidth, terminalHeight)
+				layoutMu.RUnlock()
+				continue
+			}
+
+			barWidth, heatmapWidth := barWidths(plotWidth, degradedMs > 0)
+
+			// copy arrays to have consistent view
+			tOk, tDegraded, tBad := windowTimings()
+
+			st := statusLine{
+				sent:      requestsSent.Load(),
+				recv:      responsesReceived.Load(),
+				ok:        responsesOk.Load(),
+				rate:      currentRate.Load(),
+				byteRate:  currentByteRate.Load(),
+				desired:   desiredRate.Load(),
+				skipped:   skippedTicks.Load(),
+				canceled:  requestsCanceled.Load(),
+				dropped:   droppedTicks.Load(),
+				invalid:   validationFailed.Load(),
+				badHeader: headerMismatches.Load(),
+				running:   workerCount.Load(),
+				workers:   suggestedWorkers.Load(),
+				reused:    connsReused.Load(),
+				newConns:  connsNew.Load(),
+				uploads:   uploadsTimed.Load(),
+			}
+			st.uploadMs, st.uploadPct = uploadStats(uploadNanos.Load(), uploadLatencyNanos.Load(), st.uploads)
+
+			sloBucket := -1
+			if sloMs > 0 {
+				sloBucket = bucketIndex(sloMs)
+				counts := make([]int64, buckets)
+				for bkt := range counts {
+					counts[bkt] = tOk[bkt] + tDegraded[bkt] + tBad[bkt]
+				}
+				st.sloMs, st.sloBreach = sloMs, sloBreach(counts, sloBucket)
+			}
+			for status := range responses {
+				st.responses[status] = responses[status].Load()
+			}
+			for v := range negotiatedTLS {
+				st.tls[v] = negotiatedTLS[v].Load()
+			}
+			for code := range grpcStatuses {
+				st.grpc[code] = grpcStatuses[code].Load()
+			}
+
+			fmt.Print("\033[H") // clean screen
+			renderStatusLine(os.Stdout, st, int(terminalWidth), quiet)
+			fmt.Print("\r\n")
+			renderSparkline(os.Stdout, rates.recent(sparklineWidth(int(terminalWidth))), st.desired, int(terminalWidth))
+			fmt.Print("\r\n")
+
+			if sizesShown.Load() != 0 {
+				renderSizes(os.Stdout, screenPalette, responseSizes, int(plotHeight), barWidth, percentShown.Load() != 0)
+			} else if heatmapShown.Load() != 0 {
+				okSlots, badSlots, cur := timingSlots(time.Now())
+				renderHeatmap(os.Stdout, screenPalette, okSlots, badSlots, cur, int(plotHeight), heatmapWidth)
+			} else {
+				renderHistogram(os.Stdout, screenPalette, tOk, tDegraded, tBad, int(plotHeight), barWidth, sloBucket, percentShown.Load() != 0)
+			}
+
+			if recentResults != nil {
+				renderTail(os.Stdout, recentResults.recent(), int(tailHeight), int(terminalWidth))
+			}
+
+			if helpShown.Load() != 0 {
+				renderHelp(os.Stdout, statsLines+1, 3)
+			}
+			layoutMu.RUnlock()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// keyPressListener handles keys until told to quit, on a terminal already
+// initialized, and closes it when it returns
+func keyPressListener(rateChanger chan<- rateChange) {
+	defer term.Close()
+
+keyPressListenerLoop:
+	for {
+		switch ev := term.PollEvent(); ev.Type {
+		case term.EventKey:
+			if ev.Key != term.KeyCtrlC && helpShown.Load() != 0 {
+				toggleHelp()
+				continue
+			}
+
+			switch ev.Key {
+			case term.KeyCtrlC:
+				break keyPressListenerLoop
+			default:
+				switch ev.Ch {
+				case 'q':
+					break keyPressListenerLoop
+				case 'r':
+					resetStats()
+				case 'c':
+					resetCumulative()
+				case 'w':
+					resetWindow()
+				case 'k': // up
+					rateChanger <- rateChange{rateIncreaseStep, "key"}
+				case 'j':
+					rateChanger <- rateChange{rateDecreaseStep, "key"}
+				case '+':
+					scaleWorkers(workersStep, rateChanger)
+				case '-':
+					scaleWorkers(-workersStep, rateChanger)
+				case 'p':
+					percentShown.Store(1 - percentShown.Load())
+				case 'h':
+					heatmapShown.Store(1 - heatmapShown.Load())
+					layoutGen.Add(1)
+				case 's':
+					sizesShown.Store(1 - sizesShown.Load())
+					layoutGen.Add(1)
+				case '?':
+					toggleHelp()
+				}
+			}
+		case term.EventResize:
+			resize(uint(ev.Width), uint(ev.Height))
+		case term.EventInterrupt:
+			break keyPressListenerLoop
+		case term.EventError:
+			log.Fatal(ev.Err)
+		}
+	}
+}
+
+// ticker paces the workers at rate, after first handing out burst ticks as
+// fast as they are taken. Pacing starts once the workers are done with all
+// burst ticks, sent, skipped or failed, and after calling afterBurst if it is set.
+func ticker(rate, burst uint64, afterBurst func(), quit <-chan struct{}) (<-chan time.Time, chan<- rateChange) {
+	ticker := make(chan time.Time, 1)
+	rateChanger := make(chan rateChange, 1)
+
+	// start main workers
+	go func() {
+		desiredRate.Store(int64(rate))
+		logRateChange(time.Now(), 0, int64(rate), "start")
+		changeRate := func(c rateChange) int64 {
+			old := desiredRate.Load()
+			newRate := desiredRate.Add(c.delta)
+			if newRate < 0 {
+				newRate = 0
+				desiredRate.Store(0)
+			}
+			logRateChange(time.Now(), old, newRate, c.reason)
+
+			return newRate
+		}
+
+		if burst > 0 {
+			base := ticksHandled.Load()
+			for sent := uint64(0); sent < burst; {
+				select {
+				case ticker <- time.Now():
+					sent++
+				case r := <-rateChanger:
+					changeRate(r)
+				case <-quit:
+					return
+				}
+			}
+
+			wait := time.NewTicker(10 * time.Millisecond)
+			for ticksHandled.Load()-base < int64(burst) {
+				select {
+				case <-wait.C:
+				case r := <-rateChanger:
+					changeRate(r)
+				case <-quit:
+					wait.Stop()
+					return
+				}
+			}
+			wait.Stop()
+
+			if afterBurst != nil {
+				afterBurst()
+			}
+		}
+
+		tck := time.NewTicker(time.Hour)
+		tck.Stop()
+		if r := desiredRate.Load(); r > 0 {
+			tck = time.NewTicker(time.Duration(1e9 / r))
+		}
+
+		for {
+			select {
+			case r := <-rateChanger:
+				tck.Stop()
+				if newRate := changeRate(r); newRate > 0 {
+					tck = time.NewTicker(time.Duration(1e9 / newRate))
+				}
+			case t := <-tck.C:
+				select {
+				case ticker <- t:
+				default:
+					droppedTicks.Add(1)
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	return ticker, rateChanger
+}
+
+// layout is how the screen is split between stats, plot and tail pane, along
+// with the latency buckets, one per plot row unless fixedBuckets is set
+type layout struct {
+	terminalWidth  uint
+	terminalHeight uint
+	plotWidth      uint
+	plotHeight     uint
+	buckets        uint
+	logBase        float64
+}
+
+// computeLayout fits the plot in a terminal of the given size, leaving tail lines for the tail pane
+func computeLayout(width, height, tail uint) (layout, error) {
+	l := layout{
+		terminalWidth:  width,
+		terminalHeight: height,
+		plotWidth:      width,
+	}
+
+	if l.plotWidth <= reservedWidthSpace {
+		return l, errors.New("not enough screen width, min 40 characters required")
+	}
+
+	if height <= statsLines+tail+reservedHeightSpace {
+		return l, errors.New("not enough screen height, min 3 lines required")
+	}
+
+	l.plotHeight = height - statsLines - tail
+	if fixedLogBase > 0 {
+		l.logBase = fixedLogBase
+		l.buckets = logBaseBuckets(maxY-minY, fixedLogBase)
+	} else {
+		l.buckets = l.plotHeight
+		if fixedBuckets > 0 {
+			l.buckets = fixedBuckets
+		}
+		l.logBase = math.Pow(maxY-minY, 1/float64(l.buckets-2))
+	}
+
+	return l, nil
+}
+
+// logBaseBuckets is the number of buckets of base it takes to reach span ms
+// past minY, along with the ones for requests below minY and above maxY
+func logBaseBuckets(span, base float64) uint {
+	// a hair of slack, so that exact powers of base don't get an extra bucket
+	n := math.Ceil(math.Log(span)/math.Log(base) - 1e-9)
+	if n < 1 {
+		n = 1
+	}
+
+	return uint(n) + 2
+}
+
+// applyLayout switches to l, starting over with an empty moving window if the
+// number of buckets changed. The totals are kept, they don't go by the layout.
+func applyLayout(l layout) {
+	layoutMu.Lock()
+	defer layoutMu.Unlock()
+
+	terminalWidth, terminalHeight = l.terminalWidth, l.terminalHeight
+	tooSmall = false
+	plotWidth, plotHeight = l.plotWidth, l.plotHeight
+	logBase = l.logBase
+
+	if l.buckets != buckets || timingsOk == nil {
+		buckets = l.buckets
+		allocateTimingsBuckets(buckets)
+	}
+
+	layoutGen.Add(1)
+}
+
+// resize recomputes the layout for a new terminal size. Sizes too small to
+// draw in keep the previous layout, with drawing paused until the terminal
+// is big enough again.
+func resize(width, height uint) {
+	l, err := computeLayout(width, height, tailHeight)
+	if err != nil {
+		layoutMu.Lock()
+		terminalWidth, terminalHeight = width, height
+		tooSmall = true
+		layoutMu.Unlock()
+		layoutGen.Add(1)
+		return
+	}
+
+	applyLayout(l)
+}
+
+// barWidths are the widths of the histogram bars and the heatmap rows of a
+// plot plotWidth wide, leaving room for the labels around them, and for the
+// degraded counts if they are shown. Neither is ever negative.
+func barWidths(plotWidth uint, degraded bool) (bar, heatmap int) {
+	bar = int(plotWidth) - reservedWidthSpace
+	if bar < 0 {
+		bar = 0
+	}
+	heatmap = bar + heatmapExtraWidth
+
+	if degraded {
+		if bar -= degradedCellWidth; bar < 0 {
+			bar = 0
+		}
+	}
+
+	return bar, heatmap
+}
+
+// getTimingsSlot must be called with layoutMu held
+func getTimingsSlot(now time.Time) (ok, degraded, bad []counter) {
+	slot := timingsSlotIndex(now)
+	return timingsOk[slot], timingsDegraded[slot], timingsBad[slot]
+}
+
+// timingsSlotIndex is the slot of the ring buffer responses at now go into
+func timingsSlotIndex(now time.Time) int {
+	n := int(now.UnixNano() / 100000000)
+	return n % len(timingsOk)
+}
+
+func allocateTimingsBuckets(buckets uint) {
+	timingsOk = make([][]counter, movingWindowsSize*screenRefreshFrequency)
+	for i := 0; i < len(timingsOk); i++ {
+		timingsOk[i] = make([]counter, buckets)
+	}
+
+	timingsDegraded = make([][]counter, movingWindowsSize*screenRefreshFrequency)
+	for i := 0; i < len(timingsDegraded); i++ {
+		timingsDegraded[i] = make([]counter, buckets)
+	}
+
+	timingsBad = make([][]counter, movingWindowsSize*screenRefreshFrequency)
+	for i := 0; i < len(timingsBad); i++ {
+		timingsBad[i] = make([]counter, buckets)
+	}
+}
+
+func startTimingsCleaner() {
+	go func() {
+		for now := range time.Tick(screenRefreshInterval) {
+			// TODO account for missing ticks
+			// clean next timing slot which is last one in ring buffer
+			next := now.Add(screenRefreshInterval)
+
+			layoutMu.RLock()
+			tOk, tDegraded, tBad := getTimingsSlot(next)
+			for i := 0; i < len(tOk); i++ {
+				tOk[i].Store(0)
+			}
+
+			for i := 0; i < len(tDegraded); i++ {
+				tDegraded[i].Store(0)
+			}
+
+			for i := 0; i < len(tBad); i++ {
+				tBad[i].Store(0)
+			}
+			layoutMu.RUnlock()
+		}
+	}()
+}
+
+type arrayFlags []string
+
+func (i *arrayFlags) String() string {
+	return fmt.Sprintf("+%v", *i)
+}
+
+func (i *arrayFlags) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+var headerFlags arrayFlags
+
+var formFields, formFiles arrayFlags
+
+// statusSet marks response statuses to count as ok on top of 2xx
+type statusSet [len(responses)]bool
+
+var okStatuses statusSet
+
+func isOK(status int) bool {
+	return (status >= 200 && status < 300) || (status >= 0 && status < len(okStatuses) && okStatuses[status])
+}
+
+func (s *statusSet) String() string {
+	var codes []string
+	for status, ok := range s {
+		if ok {
+			codes = append(codes, strconv.Itoa(status))
+		}
+	}
+
+	return strings.Join(codes, ",")
+}
+
+// Set takes a comma separated list of statuses and status ranges, e.g. 404,300-399
+func (s *statusSet) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+
+		min, max := 0, 0
+		var err error
+		if strings.Contains(part, "-") {
+			min, max, err = getMinMax(part)
+		} else {
+			min, err = strconv.Atoi(part)
+			max = min
+		}
+
+		if err != nil || min < 100 || max > 599 {
+			return fmt.Errorf("invalid status or status range %q", part)
+		}
+
+		for status := min; status <= max; status++ {
+			s[status] = true
+		}
+	}
+
+	return nil
+}
+
+// rateFlag is a number of requests per second, optionally with a k or m
+// suffix for thousands or millions, e.g. 2.5k
+type rateFlag uint64
+
+// rateSuffixes are the powers of ten the suffixes of a rate multiply it by
+var rateSuffixes = map[byte]int{'k': 3, 'm': 6}
+
+func (r *rateFlag) String() string {
+	return strconv.FormatUint(uint64(*r), 10)
+}
+
+// Set parses the rate as decimal digits, shifting the point by the suffix,
+// rather than multiplying a float, which can't hold e.g. 16.1 exactly
+func (r *rateFlag) Set(value string) error {
+	invalid := fmt.Errorf("invalid rate %q, expected a whole number of requests per second, e.g. 500, 10k or 2.5k", value)
+
+	number, exp := strings.ToLower(value), 0
+	if n := len(number); n > 0 {
+		if e, ok := rateSuffixes[number[n-1]]; ok {
+			number, exp = number[:n-1], e
+		}
+	}
+
+	whole, fraction, _ := strings.Cut(number, ".")
+	if whole == "" && fraction == "" {
+		return invalid
+	}
+	if len(fraction) > exp {
+		if strings.Trim(fraction[exp:], "0") != "" {
+			return invalid
+		}
+		fraction = fraction[:exp]
+	}
+	digits := whole + fraction + strings.Repeat("0", exp-len(fraction))
+	if strings.Trim(digits, "0123456789") != "" {
+		return invalid
+	}
+
+	rate, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || rate > math.MaxInt64 {
+		return invalid
+	}
+
+	*r = rateFlag(rate)
+	return nil
+}
+
+// totalRate is the rate to pace all workers at: rate, unless a per-worker
+// rate is given, times the number of workers
+func totalRate(rate, perWorker rateFlag, workers uint) (uint64, error) {
+	if perWorker == 0 {
+		return uint64(rate), nil
+	}
+
+	total := uint64(perWorker) * uint64(workers)
+	if workers != 0 && total/uint64(workers) != uint64(perWorker) || total > math.MaxInt64 {
+		return 0, fmt.Errorf("-rate-per-worker %d times %d workers is too many requests per second", perWorker, workers)
+	}
+
+	return total, nil
+}
+
+// durationRange is a duration, or a min-max range of them to pick from at random
+type durationRange struct {
+	min, max time.Duration
+}
+
+func (d *durationRange) String() string {
+	if d.min == d.max {
+		return d.min.String()
+	}
+
+	return d.min.String() + "-" + d.max.String()
+}
+
+func (d *durationRange) Set(value string) error {
+	parts := strings.SplitN(value, "-", 2)
+
+	min, err := time.ParseDuration(parts[0])
+	if err != nil {
+		return err
+	}
+
+	max := min
+	if len(parts) == 2 {
+		if max, err = time.ParseDuration(parts[1]); err != nil {
+			return err
+		}
+	}
+
+	if min < 0 || max < min {
+		return fmt.Errorf("invalid duration range %q", value)
+	}
+
+	d.min, d.max = min, max
+	return nil
+}
+
+// random picks a duration from the range
+func (d *durationRange) random() time.Duration {
+	if d.max <= d.min {
+		return d.min
+	}
+
+	return d.min + time.Duration(rand.Int63n(int64(d.max-d.min)+1))
+}
+
+func main() {
+	workers := flag.Uint("workers", 8, "Number of workers")
+	timeout := flag.Duration("timeout", 30*time.Second, "Requests timeout")
+	maxDurationPerRequest := flag.Duration("max-duration-per-request", 0, "Cancel requests taking longer, counting them as canceled rather than as errors")
+	maxDuration := flag.Duration("duration", 0, "Stop after running this long, 0 to run until quit")
+	maxRequests := flag.Uint64("requests", 0, "Stop after sending this many requests, 0 for no limit. With -duration, whichever comes first ends the run.")
+	maxErrors := flag.Uint64("max-errors", 0, "Stop after this many errors, failed requests and responses that aren't ok alike, 0 for no limit")
+	drainTimeout := flag.Duration("drain-timeout", 0, "How long requests in flight when quitting may take to finish before they are abandoned, 0 to wait out their -timeout")
+	methodTimeoutFlags := make(map[string]*time.Duration)
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		methodTimeoutFlags[method] = flag.Duration("timeout-"+strings.ToLower(method), 0, fmt.Sprintf("Timeout for %s requests, instead of -timeout", method))
+	}
+	targets := flag.String("targets", "", "Targets file, or an http(s) URL to fetch it from")
+	flag.BoolVar(&allowUnsetEnv, "allow-unset", false, "Replace ${NAME} references to unset environment variables with nothing instead of failing")
+	targetsFormat := flag.String("format", "text", "Format of the targets file: text, json for an array of {method, url, headers, body} objects, or jsonl for one such object per line")
+	vusers := flag.Uint("vusers", 0, "Send every round of the targets as one of this many virtual users, taking turns, each with a session id of its own in -vuser-header")
+	vuserHeader := flag.String("vuser-header", "X-Session", "Header carrying the session id of the virtual user of -vusers")
+	shuffleTargets := flag.Bool("shuffle-targets", false, "Send the targets in a random order, after expanding them, instead of in the order of the targets file. -url-seed makes it the same order every run.")
+	sortTargets := flag.Bool("sort-targets", false, "Send the targets sorted by method and url, after expanding them, instead of in the order of the targets file")
+	hostsFile := flag.String("hosts", "", "File of hosts, one per line, to send every path in the targets file to")
+	tags := flag.String("tags", "", "Comma-separated tags; only targets with one of them are sent")
+	bodyDir := flag.String("body-dir-cycle", "", "Directory of files to send as request bodies in turn, one per request, instead of the targets' bodies")
+	bodyDirRandom := flag.Bool("body-dir-random", false, "Pick the -body-dir-cycle file of every request at random instead of in turn")
+	bodyTemplate := flag.String("body-template", "", "Go text/template file executed for the body of every request, instead of the targets' bodies")
+	urlSeed := flag.Int64("url-seed", 0, "Seed for the random parts of urls and -shuffle-targets, to get the same urls in the same order on every run. Other randomness is unaffected.")
+	base64body := flag.Bool("base64body", false, "Bodies in targets file are base64-encoded")
+	rate := rateFlag(50)
+	flag.Var(&rate, "rate", "Requests per second, e.g. 500, 10k or 2.5k")
+	var ratePerWorker rateFlag
+	flag.Var(&ratePerWorker, "rate-per-worker", "Requests per second of each worker, instead of -rate for all of them")
+	rateChangeLogFile := flag.String("rate-change-log", "", "File to append a 'timestamp oldrate newrate reason' line to for every change of the rate")
+	rateLimitAware := flag.Bool("rate-limit-aware", false, "Halve the rate on 429 responses, wait out their Retry-After, then recover gradually")
+	burst := flag.Uint64("burst", 0, "Number of requests to send as fast as possible before pacing at -rate")
+	burstExclude := flag.Bool("burst-exclude", false, "Reset the stats after the -burst, leaving it out of them")
+	miY := flag.Duration("minY", 0, "min on Y axe (default 0ms)")
+	maY := flag.Duration("maxY", 100*time.Millisecond, "max on Y axe")
+	replay := flag.String("replay", "", "Replay the requests in this file at their recorded offsets, instead of -targets at -rate")
+	replaySpeed := flag.Float64("replay-speed", 1, "Speed multiplier for -replay")
+	findMaxFlag := flag.Bool("find-max", false, "Binary search for the highest rate up to -rate that keeps to -find-max-errors and -slo, a -find-max-window at each rate tried")
+	findMaxWindow := flag.Duration("find-max-window", 10*time.Second, "How long -find-max measures each rate it tries")
+	findMaxErrors := flag.Float64("find-max-errors", 0.01, "Highest error rate, from 0 to 1, that -find-max counts as sustained")
+	profile := flag.String("profile", "", "File of phases to run in order, one 'name rate duration [tags]' per line, instead of a single -rate")
+	logBaseFlag := flag.Float64("log-base", 0, "Base of the latency bucket boundaries, e.g. 2 to double every bucket. Sets the number of buckets to span -minY to -maxY, merged to fit the screen.")
+	bucketsFlag := flag.Uint("buckets", 0, "Number of latency buckets, merged to fit the screen. 0 for one per screen row, so the resolution depends on the terminal height.")
+	percent := flag.Bool("percent", false, "Show each latency bucket as a percentage of all requests instead of a count. Toggle with p.")
+	degraded := flag.Duration("degraded", 0, "Latency over which ok responses are counted and drawn as degraded")
+	slo := flag.Duration("slo", 0, "Latency objective to mark on the plot, along with the share of requests above it")
+	sloBudget := flag.Duration("slo-budget", 0, "How long the p99 of the moving window may stay above -slo before the run is ended, exiting non-zero")
+	unitName := flag.String("latency-unit", "ms", "Unit to show latencies in: us, ms or s")
+	paletteName := flag.String("palette", "256", "Screen colors: 256, 16 or mono")
+	tee := flag.Bool("tee", false, "Write the first response in full, headers and body, to stderr before the screen starts")
+	stopOnError := flag.Bool("stop-on-error", false, "Stop at the first failed request, and print it along with its response")
+	quiet := flag.Bool("quiet", false, "Show ok/error totals instead of a count per response status")
+	captureRate := flag.Float64("capture-rate", 0, "Fraction of requests, from 0 to 1, to write to -capture-file in full along with their responses")
+	captureFile := flag.String("capture-file", "", "File to append the exchanges sampled by -capture-rate to")
+	hdrFile := flag.String("hdr", "", "File to write the HdrHistogram percentile distribution of all latencies to on exit, in ms")
+	reportFormat := flag.String("report-format", "text", "Format of the summary printed on exit: text, json or csv. Given, the summary is printed however the run ended.")
+	saveSummaryFile := flag.String("save-summary", "", "File to write the summary of the run to as JSON on exit, e.g. as a baseline for -compare")
+	compareFile := flag.String("compare", "", "Summary saved with -save-summary to compare the run to on exit, exiting non-zero on regressions")
+	compareTolerance := flag.Float64("compare-tolerance", 10, "Percent by which latencies may grow, and the rate drop, before -compare calls it a regression")
+	compareErrorTolerance := flag.Float64("compare-error-tolerance", 0.1, "Percentage points by which the error rate may grow before -compare calls it a regression")
+	snapshotInterval := flag.Duration("snapshot-interval", 0, "Write a summary line to stderr this often, 0 to disable. Stderr must not be the terminal.")
+	reportCSV := flag.String("report-csv", "", "Append a row of percentiles, rate and error rate to this CSV file every -report-interval")
+	reportInterval := flag.Duration("report-interval", 10*time.Second, "How often to append a row to -report-csv")
+	seqStart := flag.Int64("seq-start", 0, "First value substituted for {{seq}}")
+	warnSlow := flag.Duration("warn-slow", 0, "Catch requests slower than this, printing the slowest on exit. 0 to disable.")
+	warnSlowLog := flag.String("warn-slow-log", "", "File to append a line to for every request slower than -warn-slow")
+	warnSlowKeep := flag.Uint("warn-slow-keep", 10, "Number of the slowest requests to print on exit with -warn-slow")
+	tail := flag.Uint("tail", 0, "Number of lines at the bottom of the screen showing the most recent requests")
+	sigv4 := flag.Bool("sigv4", false, "Sign requests with AWS Signature Version 4")
+	sigv4Region := flag.String("sigv4-region", os.Getenv("AWS_REGION"), "AWS region for -sigv4")
+	sigv4Service := flag.String("sigv4-service", "", "AWS service name for -sigv4, e.g. execute-api or s3")
+	awsAccessKey := flag.String("aws-access-key-id", os.Getenv("AWS_ACCESS_KEY_ID"), "AWS access key ID for -sigv4")
+	awsSecretKey := flag.String("aws-secret-access-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "AWS secret access key for -sigv4")
+	awsSessionToken := flag.String("aws-session-token", os.Getenv("AWS_SESSION_TOKEN"), "AWS session token for -sigv4 with temporary credentials")
+	oauth2TokenURL := flag.String("oauth2-token-url", "", "OAuth2 token endpoint to get a bearer token for every request from, with the client credentials grant")
+	oauth2ClientID := flag.String("oauth2-client-id", os.Getenv("OAUTH2_CLIENT_ID"), "OAuth2 client ID for -oauth2-token-url")
+	oauth2ClientSecret := flag.String("oauth2-client-secret", os.Getenv("OAUTH2_CLIENT_SECRET"), "OAuth2 client secret for -oauth2-token-url")
+	idempotencyKey := flag.String("idempotency-key", "", "Header to set to a fresh UUID on every non-GET request, e.g. Idempotency-Key")
+	repeat := flag.Uint("repeat", 1, "Number of times each target is sent in a row before moving on to the next")
+	grpcWeb := flag.String("grpc-web", "", "Send the targets as gRPC-Web unary calls, in the binary or text format, their bodies being the serialized request messages")
+	grpcMode := flag.Bool("grpc", false, "Send the targets as gRPC unary calls over HTTP/2, their bodies being the serialized request messages")
+	contentLength := flag.Int64("content-length", -1, "Content-Length to send regardless of the actual body size, for testing servers. -1 sends the real one.")
+	chunked := flag.Bool("chunked", false, "Send request bodies with chunked transfer encoding instead of a Content-Length")
+	pipeline := flag.Uint("pipeline", 0, "Experimental: pipeline up to this many GETs per HTTP/1.1 connection, writing them all before reading the responses. 0 to not pipeline.")
+	maxInflight := flag.Uint("max-inflight", 0, "Maximum number of requests in flight, ticks beyond it are skipped. 0 for no limit beyond -workers.")
+	discardBody := flag.Bool("discard-body", false, "Drain response bodies without keeping them in memory")
+	sizeMin := flag.Int64("size-min", 0, "Smallest response body size, in bytes, the size histogram tells apart; smaller ones share its first bucket")
+	sizeMax := flag.Int64("size-max", 1<<20, "Largest response body size, in bytes, the size histogram tells apart; larger ones share its last bucket")
+	maxBodyReadFlag := flag.Int64("max-body-read", 0, "Read at most this many bytes of each response body into memory, draining the rest. 0 reads it all.")
+	noBody := flag.Bool("no-body", false, "Close response bodies without reading them. Connections may then not be reused.")
+	dnsCacheTTL := flag.Duration("dns-cache-ttl", 0, "Cache resolved host addresses for this long, 0 to resolve for every new connection")
+	dnsServer := flag.String("dns-server", "", "DNS server 'host[:port]' to resolve target hosts with, instead of the system's")
+	network := flag.String("net", "tcp", "Address family to connect over: tcp for either, tcp4 for IPv4 only or tcp6 for IPv6 only")
+	controlAddr := flag.String("control-addr", "", "Address like localhost:9001 to accept more targets on, in the -format of the targets file, while running")
+	apiAddr := flag.String("api-addr", "", "Address like localhost:9000 to serve the live buckets, counts and rates on as JSON, at /stats")
+	preflightCheck := flag.Bool("preflight", false, "Send one unmeasured HEAD request to each host before starting, and exit if none of them answer")
+	keepaliveProbe := flag.Bool("keepalive-probe", false, "Open each host's share of -workers connections with unmeasured HEAD requests before starting")
+	spreadIPs := flag.Bool("spread-ips", false, "Spread new connections over all addresses a host resolves to")
+	sni := flag.String("sni", "", "Server name to send in the TLS handshake, instead of the host of the url")
+	tlsMin := flag.String("tls-min", "", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	tlsMax := flag.String("tls-max", "", "Maximum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	ciphers := flag.String("ciphers", "", "Comma-separated list of TLS 1.0-1.2 cipher suites to offer, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	noDelay := flag.Bool("nodelay", true, "Set TCP_NODELAY on connections, as Go does. -nodelay=false to send with Nagle's algorithm.")
+	maxConns := flag.Uint("max-conns", 0, "Most connections to have open to a host at once, idle or busy; requests wait for one beyond that. 0 for no limit.")
+	runLabels = labelFlags{}
+	flag.Var(runLabels, "label", "Label 'key=value' of the run, e.g. env=staging, added to summaries, reports and /stats. Repeat for more.")
+	hostConns := hostConnsFlags{}
+	flag.Var(hostConns, "host-conns", "Idle connection pool size 'host=N' for a host, instead of its share of -workers. Repeat for more than one host.")
+	var expectStatus arrayFlags
+	flag.Var(&expectStatus, "expect-status", "Response statuses or ranges, e.g. 200,301-302, that responses must have to be valid. Repeat for more.")
+	var expectHeaders arrayFlags
+	flag.Var(&expectHeaders, "expect-header", "Header 'Key: Value', or just 'Key' for any value, that responses must have to be valid. Repeat for more.")
+	expectBody := flag.String("expect-body", "", "Text responses must contain to be valid")
+	expectBodyRegex := flag.String("expect-body-regex", "", "Regular expression responses must match to be valid")
+	flag.Var(&thinkTime, "think-time", "How long each worker pauses after a request, e.g. 1s, or a range to pick from at random, e.g. 500ms-2s")
+	flag.Var(&okStatuses, "ok-status", "Response statuses or ranges to count as ok on top of 2xx, e.g. 404,300-399")
+	flag.Var(&formFields, "form", "Multipart form field 'name=value' sent as the body of every request. Repeat for more fields.")
+	urlencoded := flag.Bool("urlencoded", false, "Send the -form fields as application/x-www-form-urlencoded instead of multipart, expanding tokens in their values for every request")
+	flag.Var(&formFiles, "form-file", "Multipart form file 'name=path' sent as part of the -form body. Repeat for more files.")
+	flag.Var(&headerFlags, "H", "HTTP header 'key: value' set on all requests. Repeat for more than one header.")
+	flag.Parse()
+
+	var baseline *Summary
+	if *compareFile != "" {
+		s, err := loadSummary(*compareFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		baseline = &s
+	}
+
+	if *snapshotInterval > 0 {
+		if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			log.Fatal("-snapshot-interval writes to stderr, which would garble the screen; redirect it, e.g. 2>>snapshots.log")
+		}
+	}
+
+	p, err := lookupPalette(*paletteName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	screenPalette = p
+
+	encoder, err := lookupSummaryEncoder(*reportFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reportFormatSet := false
+	flag.Visit(func(f *flag.Flag) { reportFormatSet = reportFormatSet || f.Name == "report-format" })
+
+	if displayUnit, err = lookupLatencyUnit(*unitName); err != nil {
+		log.Fatal(err)
+	}
+
+	width, _ := terminal.Width()
+	height, _ := terminal.Height()
+
+	minY, maxY = float64(*miY)/float64(time.Millisecond), float64(*maY)/float64(time.Millisecond)
+	sloMs = float64(*slo) / float64(time.Millisecond)
+	degradedMs = float64(*degraded) / float64(time.Millisecond)
+	if *percent {
+		percentShown.Store(1)
+	}
+	tailHeight = *tail
+	if *bucketsFlag > 0 && *bucketsFlag < 3 {
+		log.Fatal("-buckets must be at least 3, for the requests below -minY, above -maxY and in between")
+	}
+	fixedBuckets = *bucketsFlag
+	if *logBaseFlag != 0 {
+		if *logBaseFlag <= 1 {
+			log.Fatal("-log-base must be more than 1")
+		}
+		if *bucketsFlag > 0 {
+			log.Fatal("-log-base sets the number of buckets, and can't be used with -buckets")
+		}
+		fixedLogBase = *logBaseFlag
+	}
+
+	l, err := computeLayout(width, height, tailHeight)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if tailHeight > 0 {
+		recentResults = newResultLog(int(tailHeight))
+	}
+
+	if *warnSlow > 0 {
+		var w io.Writer
+		if *warnSlowLog != "" {
+			f, err := os.OpenFile(*warnSlowLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		slowRequests = newSlowLog(*warnSlow, int(*warnSlowKeep), w)
+	} else if *warnSlowLog != "" {
+		log.Fatal("-warn-slow-log needs -warn-slow")
+	}
+
+	if *rateChangeLogFile != "" {
+		f, err := os.OpenFile(*rateChangeLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		rateChangeLog = f
+	}
+
+	if *hdrFile != "" {
+		latencyHDR = newHDRHistogram()
+	}
+
+	if *captureRate < 0 || *captureRate > 1 {
+		log.Fatal("-capture-rate must be between 0 and 1")
+	}
+	if *captureRate > 0 {
+		if *captureFile == "" {
+			log.Fatal("-capture-rate needs a -capture-file to write to")
+		}
+		if *pipeline > 0 {
+			log.Fatal("-pipeline writes requests by hand, and can't be used with -capture-rate")
+		}
+
+		f, err := os.OpenFile(*captureFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		exchangeCaptures = newExchangeCapture(f, *captureRate)
+	} else if *captureFile != "" {
+		log.Fatal("-capture-file is written by -capture-rate, which isn't set")
+	}
+
+	applyLayout(l)
+	startTimingsCleaner()
+	runStart.Store(time.Now().UnixNano())
+
+	quit := make(chan struct{}, 1)
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(quit) }) }
+	if *stopOnError {
+		stopOnFailure = &failureStop{stop: func() {
+			stop()
+			go term.Interrupt() // wake up keyPressListener
+		}}
+	}
+
+	var trgt *targeter
+	var ticks <-chan time.Time
+	var profilePhases []profilePhase
+	var profileRequests [][]request
+	var rateSearch *rateSearch
+	var sloWatch *sloWatchdog
+	var replayDone <-chan struct{}
+	var rateChanger chan<- rateChange
+	var hosts []string // from -hosts
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "url-seed" {
+			urlRand = rand.New(rand.NewSource(*urlSeed))
+		}
+	})
+
+	if *replay != "" {
+		if *replaySpeed <= 0 {
+			log.Fatal("-replay-speed must be positive")
+		}
+		if *tags != "" {
+			log.Fatal("-tags selects from -targets, and can't be used with -replay")
+		}
+		if *hostsFile != "" {
+			log.Fatal("-hosts combines with -targets, and can't be used with -replay")
+		}
+		if *targetsFormat != "text" {
+			log.Fatal("-format is the format of -targets, and can't be used with -replay")
+		}
+		if *controlAddr != "" {
+			log.Fatal("-control-addr adds to -targets, and can't be used with -replay")
+		}
+		if *sortTargets || *shuffleTargets {
+			log.Fatal("-replay keeps the order of the recording, and can't be used with -sort-targets or -shuffle-targets")
+		}
+		if *rateLimitAware {
+			log.Fatal("-rate-limit-aware changes the rate, which -replay doesn't have")
+		}
+		if *profile != "" {
+			log.Fatal("-profile sets the rate, which -replay doesn't have")
+		}
+		if *findMaxFlag {
+			log.Fatal("-find-max searches for a rate, which -replay doesn't have")
+		}
+
+		var offsets []time.Duration
+		trgt, offsets, err = newReplayTargeter(*replay, *base64body)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ticks, rateChanger, replayDone = replayTicker(offsets, *replaySpeed, quit)
+	} else {
+		rateSet := false
+		flag.Visit(func(f *flag.Flag) { rateSet = rateSet || f.Name == "rate" })
+		if rateSet && ratePerWorker > 0 {
+			log.Fatal("-rate and -rate-per-worker are mutually exclusive")
+		}
+		desired, rateErr := totalRate(rate, ratePerWorker, *workers)
+		if rateErr != nil {
+			log.Fatal(rateErr)
+		}
+
+		if *hostsFile != "" {
+			if hosts, err = readHosts(*hostsFile); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if *sortTargets && *shuffleTargets {
+			log.Fatal("-sort-targets and -shuffle-targets are mutually exclusive")
+		}
+		trgt, err = newTargeter(*targets, *targetsFormat, *base64body, *sortTargets, splitTags(*tags), hosts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *shuffleTargets {
+			shuffleRequests(trgt.requests, urlRand)
+		}
+		if *profile != "" {
+			if rateSet || ratePerWorker > 0 {
+				log.Fatal("-profile sets the rate of every phase, and can't be used with -rate or -rate-per-worker")
+			}
+			if *controlAddr != "" {
+				log.Fatal("-profile sets the targets of every phase, and can't be used with -control-addr")
+			}
+			if profilePhases, err = readProfile(*profile); err != nil {
+				log.Fatal(err)
+			}
+			if profileRequests, err = profileTargets(profilePhases, trgt.requests); err != nil {
+				log.Fatal(err)
+			}
+			desired = uint64(profilePhases[0].rate)
+		}
+		if *findMaxFlag {
+			if *profile != "" || *rateLimitAware {
+				log.Fatal("-find-max sets the rate itself, and can't be used with -profile or -rate-limit-aware")
+			}
+			if *findMaxWindow <= 0 {
+				log.Fatal("-find-max-window must be positive")
+			}
+			if *findMaxErrors < 0 || *findMaxErrors > 1 {
+				log.Fatal("-find-max-errors must be between 0 and 1")
+			}
+			if desired == 0 {
+				log.Fatal("-find-max searches up to -rate, which must be more than 0")
+			}
+			rateSearch = newRateSearch(int64(desired))
+		}
+		trgt.repeat = int64(*repeat)
+		var afterBurst func()
+		if *burstExclude {
+			afterBurst = resetStats
+		}
+		ticks, rateChanger = ticker(desired, *burst, afterBurst, quit)
+	}
+	if *sloBudget > 0 {
+		if *slo <= 0 {
+			log.Fatal("-slo-budget is spent while the p99 is above -slo, which must be set")
+		}
+		if *findMaxFlag {
+			log.Fatal("-slo-budget ends the run at the first sustained breach, and can't be used with -find-max")
+		}
+		sloWatch = &sloWatchdog{sloMs: sloMs, budget: *sloBudget, p99: windowP99, poll: 100 * time.Millisecond}
+	}
+	trgt.seq.Store(*seqStart)
+	trgt.chunked = *chunked
+	trgt.grpc = *grpcMode
+	switch *grpcWeb {
+	case "":
+	case "binary", "text":
+		if *grpcMode {
+			log.Fatal("-grpc and -grpc-web are mutually exclusive")
+		}
+		trgt.grpcWeb = *grpcWeb
+	default:
+		log.Fatalf("invalid -grpc-web %q, must be binary or text", *grpcWeb)
+	}
+	if len(formFields) > 0 || len(formFiles) > 0 {
+		if *grpcMode || *grpcWeb != "" {
+			log.Fatal("-form and -form-file can't be used with -grpc or -grpc-web")
+		}
+		if *urlencoded {
+			if len(formFiles) > 0 {
+				log.Fatal("-form-file needs a multipart body, and can't be used with -urlencoded")
+			}
+			if trgt.urlencoded, err = newURLEncodedForm(formFields); err != nil {
+				log.Fatal(err)
+			}
+		} else if trgt.form, err = newMultipartForm(formFields, formFiles); err != nil {
+			log.Fatal(err)
+		}
+	} else if *urlencoded {
+		log.Fatal("-urlencoded encodes the -form fields, and there are none")
+	}
+	trgt.idempotencyKey = http.CanonicalHeaderKey(*idempotencyKey)
+	if *vusers > 0 {
+		if !validHeaderKey(*vuserHeader) {
+			log.Fatalf("invalid -vuser-header %q", *vuserHeader)
+		}
+		trgt.vusers = newVirtualUsers(int(*vusers), *vuserHeader)
+	}
+	if *bodyTemplate != "" {
+		if trgt.form != nil || trgt.urlencoded != nil {
+			log.Fatal("-body-template can't be used with -form or -form-file")
+		}
+		if trgt.bodyTemplate, err = loadBodyTemplate(*bodyTemplate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *bodyDir != "" {
+		if trgt.form != nil || trgt.urlencoded != nil || trgt.bodyTemplate != nil {
+			log.Fatal("-body-dir-cycle can't be used with -form, -form-file or -body-template")
+		}
+		if trgt.bodyFiles, err = loadBodyDir(*bodyDir, *bodyDirRandom); err != nil {
+			log.Fatal(err)
+		}
+	} else if *bodyDirRandom {
+		log.Fatal("-body-dir-random picks from -body-dir-cycle, which isn't set")
+	}
+
+	if len(headerFlags) > 0 {
+		if trgt.header, err = parseHeaderFlags(headerFlags); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *sigv4 {
+		if *sigv4Region == "" || *sigv4Service == "" {
+			log.Fatal("-sigv4 needs both -sigv4-region and -sigv4-service")
+		}
+
+		if *awsAccessKey == "" || *awsSecretKey == "" {
+			log.Fatal("-sigv4 needs AWS credentials, see -aws-access-key-id and -aws-secret-access-key")
+		}
+
+		trgt.signer = &sigv4Signer{
+			region:       *sigv4Region,
+			service:      *sigv4Service,
+			accessKey:    *awsAccessKey,
+			secretKey:    *awsSecretKey,
+			sessionToken: *awsSessionToken,
+		}
+	}
+
+	var oauth2Next time.Time
+	if *oauth2TokenURL != "" {
+		if *oauth2ClientID == "" || *oauth2ClientSecret == "" {
+			log.Fatal("-oauth2-token-url needs both -oauth2-client-id and -oauth2-client-secret")
+		}
+		if *sigv4 {
+			log.Fatal("-oauth2-token-url and -sigv4 both set the Authorization header")
+		}
+
+		trgt.oauth2 = &oauth2Source{
+			tokenURL:     *oauth2TokenURL,
+			clientID:     *oauth2ClientID,
+			clientSecret: *oauth2ClientSecret,
+			client:       &http.Client{Timeout: *timeout},
+		}
+		if oauth2Next, err = trgt.oauth2.refresh(time.Now()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch {
+	case *discardBody && *noBody:
+		log.Fatal("-discard-body and -no-body are mutually exclusive")
+	case *discardBody:
+		responseBodyMode = bodyDiscard
+	case *noBody:
+		responseBodyMode = bodySkip
+	}
+
+	if *grpcMode && *noBody {
+		log.Fatal("-grpc reads the status from the trailers after the body, and can't be used with -no-body")
+	}
+	if *grpcWeb != "" && (responseBodyMode != bodyRead || *maxBodyReadFlag > 0) {
+		log.Fatal("-grpc-web reads the status from the end of the body, and can't be used with -discard-body, -no-body or -max-body-read")
+	}
+
+	if *maxBodyReadFlag < 0 {
+		log.Fatal("-max-body-read can't be negative")
+	}
+	maxBodyRead = *maxBodyReadFlag
+
+	if *maxRequests > 0 {
+		requestsLeft = newRequestBudget(int64(*maxRequests))
+	}
+
+	if *sizeMin < 0 {
+		log.Fatal("-size-min can't be negative")
+	}
+	if responseSizes, err = newSizeHistogram(*sizeMin, *sizeMax); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(expectStatus) > 0 || len(expectHeaders) > 0 || *expectBody != "" || *expectBodyRegex != "" {
+		responseValidator = &validator{}
+		for _, header := range expectHeaders {
+			expectation, err := parseHeaderExpectation(header)
+			if err != nil {
+				log.Fatal(err)
+			}
+			responseValidator.headers = append(responseValidator.headers, expectation)
+		}
+		if len(expectStatus) > 0 {
+			responseValidator.statuses = &statusSet{}
+			for _, status := range expectStatus {
+				if err := responseValidator.statuses.Set(status); err != nil {
+					log.Fatal(err)
+				}
+			}
+		}
+		if *expectBody != "" {
+			responseValidator.contains = []byte(*expectBody)
+		}
+		if *expectBodyRegex != "" {
+			if responseValidator.matches, err = regexp.Compile(*expectBodyRegex); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if responseValidator.needsBody() && responseBodyMode != bodyRead {
+			log.Fatal("-expect-body and -expect-body-regex need response bodies, and can't be used with -discard-body or -no-body")
+		}
+		if responseValidator.needsBody() && maxBodyRead > 0 {
+			log.Fatal("-expect-body and -expect-body-regex need whole response bodies, and can't be used with -max-body-read")
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(*tlsMin, *tlsMax, *ciphers, *sni)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *network != "tcp" && *network != "tcp4" && *network != "tcp6" {
+		log.Fatalf("invalid -net %q, must be tcp, tcp4 or tcp6", *network)
+	}
+
+	trCfg := transportConfig{tls: tlsConfig, http2: *grpcMode, maxConns: int(*maxConns), nagle: !*noDelay}
+	if *network != "tcp" {
+		trCfg.network = *network
+	}
+	resolver := net.DefaultResolver
+	if *dnsServer != "" {
+		resolver = newResolver(*dnsServer)
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: resolver}
+	if *dnsCacheTTL > 0 || *spreadIPs {
+		cache := newDNSCache(resolver, *dnsCacheTTL)
+		cache.spread = *spreadIPs
+
+		trCfg.dial = cache.dialContext(dialer)
+	} else if *dnsServer != "" {
+		trCfg.dial = dialer.DialContext
+	}
+
+	requestTimeout = *timeout
+	softDeadline = *maxDurationPerRequest
+	for method, timeout := range methodTimeoutFlags {
+		if *timeout > 0 {
+			methodTimeouts[method] = *timeout
+		}
+	}
+
+	// all workers share the connection pools. Timeouts are set per request.
+	sizes := poolSizes(trgt.requests, *workers, hostConns)
+	pools := newHostPools(sizes, trCfg)
+	pools.addProtoPools(trgt.requests, *workers, hostConns, trCfg)
+	client := &http.Client{
+		Transport: pools,
+	}
+
+	if *contentLength >= 0 {
+		if *chunked || *grpcMode {
+			log.Fatal("-content-length can't be used with -chunked or -grpc")
+		}
+		if *maxConns > 0 {
+			log.Fatal("-content-length opens a connection per request, and can't be used with -max-conns")
+		}
+		client.Transport = &rawLengthTransport{length: *contentLength, cfg: trCfg}
+	}
+
+	if *tee {
+		teeFirst = newResponseTee(os.Stderr)
+	}
+
+	if *maxInflight > 0 {
+		inflightSlots = make(chan struct{}, *maxInflight)
+	}
+
+	if *preflightCheck {
+		hosts, unreachable := preflight(client, trgt.requests, *timeout)
+		if hosts > 0 && len(unreachable) == hosts {
+			log.Fatalf("target unreachable: no answer from %s", strings.Join(unreachable, ", "))
+		}
+		if len(unreachable) > 0 {
+			log.Printf("preflight: no answer from %s", strings.Join(unreachable, ", "))
+		}
+	}
+
+	if *pipeline > 0 {
+		if *contentLength >= 0 || *grpcMode || *maxInflight > 0 {
+			log.Fatal("-pipeline can't be used with -content-length, -grpc or -max-inflight")
+		}
+		if softDeadline > 0 {
+			log.Fatal("-pipeline waits for whole batches, and can't be used with -max-duration-per-request")
+		}
+		if *maxConns > 0 {
+			log.Fatal("-pipeline opens a connection per worker and host, and can't be used with -max-conns")
+		}
+		if *controlAddr != "" {
+			log.Fatal("-pipeline only checks the targets it starts with, and can't be used with -control-addr")
+		}
+		if err := pipelineable(trgt.requests); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *keepaliveProbe && *contentLength >= 0 {
+		log.Fatal("-keepalive-probe can't be used with -content-length, which doesn't reuse connections")
+	}
+
+	// everything that can fail is checked before the first request is sent
+	// and the terminal is taken over, so errors are seen on a plain screen
+	if err := checkRequests(trgt.requests); err != nil {
+		log.Fatal(err)
+	}
+
+	if *apiAddr != "" {
+		l, err := net.Listen("tcp", *apiAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go http.Serve(l, apiHandler())
+	}
+
+	if *controlAddr != "" {
+		l, err := net.Listen("tcp", *controlAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		trgt.admit = admitTargets(splitTags(*tags), hosts)
+		go serveControl(l, trgt, *targetsFormat, *base64body)
+	}
+
+	var csvFile *os.File
+	var csvFresh bool // so the header is written
+	if *reportCSV != "" {
+		if *reportInterval <= 0 {
+			log.Fatal("-report-interval must be positive")
+		}
+
+		if csvFile, err = os.OpenFile(*reportCSV, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+			log.Fatal(err)
+		}
+		defer csvFile.Close()
+
+		fi, err := csvFile.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		csvFresh = fi.Size() == 0
+	}
+
+	if *keepaliveProbe {
+		prewarm(client, trgt.requests, sizes, *timeout)
+		runStart.Store(time.Now().UnixNano())
+	}
+
+	// start attackers
+	var wg sync.WaitGroup
+	perWorker := int64(0)
+	if *replay == "" {
+		perWorker = int64(ratePerWorker)
+	}
+	attackers = newWorkerPool(func(quit <-chan struct{}) {
+		if *pipeline > 0 {
+			pipelineAttack(trgt, newPipeliner(int(*pipeline), trCfg), ticks, quit)
+			return
+		}
+		attack(trgt, client, ticks, quit)
+	}, perWorker)
+	attackers.resize(int(*workers))
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-quit
+		attackers.stop()
+		attackers.wait()
+	}()
+
+	if *snapshotInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshotter(os.Stderr, *snapshotInterval, quit)
+		}()
+	}
+
+	if csvFile != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			csvReporter(csvFile, csvFresh, *reportInterval, quit)
+		}()
+	}
+
+	if trgt.oauth2 != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trgt.oauth2.run(oauth2Next, quit)
+		}()
+	}
+
+	if *rateLimitAware {
+		rateLimitBackoff = newBackoff(time.Second)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rateLimitBackoff.run(rateChanger, quit)
+		}()
+	}
+
+	if teeFirst != nil {
+		// show the response before the screen is drawn over it
+		select {
+		case <-teeFirst.done:
+		case <-time.After(*timeout):
+		case <-quit:
+		}
+	}
+
+	if *maxDuration > 0 || requestsLeft != nil || *maxErrors > 0 {
+		limits := runLimits{
+			duration: *maxDuration,
+			errors:   int64(*maxErrors),
+			failed:   errorsTotal.Load,
+			poll:     10 * time.Millisecond,
+		}
+		if requestsLeft != nil {
+			limits.requests = requestsLeft.done
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reason := limits.supervise(quit); reason != "" {
+				runEnd.Store(reason)
+				stop()
+				go term.Interrupt() // wake up keyPressListener
+			}
+		}()
+	}
+
+	if replayDone != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-replayDone:
+				runEnd.Store("replay")
+				stop()
+				go term.Interrupt() // wake up keyPressListener
+			case <-quit:
+			}
+		}()
+	}
+
+	if len(profilePhases) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if runProfile(profilePhases, profileRequests, trgt, rateChanger, quit) {
+				runEnd.Store("profile")
+				stop()
+				go term.Interrupt() // wake up keyPressListener
+			}
+		}()
+	}
+
+	if rateSearch != nil {
+		judge := func(rate int64, s Summary) (bool, string) {
+			return windowVerdict(s, rate, *findMaxErrors, sloMs)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if findMax(rateSearch, rateSearch.ceiling, *findMaxWindow, rateChanger, judge, quit) {
+				runEnd.Store("find-max")
+				stop()
+				go term.Interrupt() // wake up keyPressListener
+			}
+		}()
+	}
+
+	if sloWatch != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sloWatch.watch(quit) {
+				runEnd.Store("slo-budget")
+				stop()
+				go term.Interrupt() // wake up keyPressListener
+			}
+		}()
+	}
+
+	// take over the terminal once nothing else can fail, so whatever was
+	// printed so far can still be read
+	if err := term.Init(); err != nil {
+		log.Fatal(err)
+	}
+
+	// start reporter
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reporter(quit, *quiet)
+	}()
+
+	keyPressListener(rateChanger)
+
+	// bye
+	stop()
+	if *drainTimeout > 0 {
+		time.AfterFunc(*drainTimeout, abandonInflight)
+	}
+	wg.Wait()
+
+	summaries := endpointSummaries()
+	_, plain := encoder.(textSummary)
+	if len(summaries) > 1 && plain {
+		writeEndpointTable(os.Stdout, summaries)
+	}
+
+	if endReason() != "" || reportFormatSet {
+		if err := encoder.encode(os.Stdout, buildSummary(time.Now())); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if slowRequests != nil && plain {
+		writeSlowest(os.Stdout, slowRequests)
+	}
+
+	// without text, stdout is kept to the summary, and the rest goes to stderr
+	notes := io.Writer(os.Stdout)
+	if !plain {
+		notes = os.Stderr
+	}
+
+	if rateSearch != nil {
+		writeSearch(notes, rateSearch)
+	}
+
+	if n := abandoned.Load(); n > 0 {
+		fmt.Fprintf(notes, "%d requests still in flight after -drain-timeout were abandoned\n", n)
+	}
+
+	if stopOnFailure != nil && stopOnFailure.detail != "" {
+		fmt.Fprint(notes, stopOnFailure.detail)
+	}
+
+	if exchangeCaptures != nil {
+		exchangeCaptures.close()
+		if n := exchangeCaptures.dropped.Load(); n > 0 {
+			fmt.Fprintf(notes, "%d sampled exchanges were left out of -capture-file, writing it fell behind\n", n)
+		}
+	}
+
+	if latencyHDR != nil {
+		if err := writeHDR(*hdrFile, latencyHDR); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *saveSummaryFile != "" || baseline != nil {
+		final := buildSummary(time.Now())
+		if *saveSummaryFile != "" {
+			if err := saveSummary(*saveSummaryFile, final); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		if baseline != nil {
+			comparisons := compareSummaries(*baseline, final, *compareTolerance, *compareErrorTolerance)
+			fmt.Fprintf(notes, "compared to %s:\n", *compareFile)
+			writeComparison(notes, comparisons)
+			if n := regressions(comparisons); n > 0 {
+				log.Fatalf("%d regressions against %s", n, *compareFile)
+			}
+		}
+	}
+
+	if endReason() == "slo-budget" {
+		log.Fatal(sloWatch.breach())
+	}
 }
 
 func init() {
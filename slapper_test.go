@@ -1,11 +1,75 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+func Test_parseUrlEscapesRandom(t *testing.T) {
+	// ! through / has most of the characters with a meaning in urls
+	got, err := parseUrl("http://www.example.com/[r20;!-/]?q=[r20;!-/] 50")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range got {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Errorf("parseUrl() gave invalid url %q: %v", s, err)
+			continue
+		}
+
+		if u.Host != "www.example.com" || u.Fragment != "" {
+			t.Errorf("parseUrl() gave %q, whose host or fragment changed", s)
+		}
+
+		if segment := strings.TrimPrefix(u.Path, "/"); len(segment) != 20 {
+			t.Errorf("parseUrl() gave %q, whose path %q isn't a 20 character segment", s, u.Path)
+		}
+
+		if q := u.Query(); len(q) != 1 || len(q.Get("q")) != 20 {
+			t.Errorf("parseUrl() gave %q, whose query %v isn't a single 20 character q", s, q)
+		}
+	}
+}
+
+func Test_ipv6HostEnd(t *testing.T) {
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"http://www.example.com/[1-3]", 0},
+		{"http://[::1]:8080/[1-3]", len("http://[::1]")},
+		{"http://[::1]", len("http://[::1]")},
+		{"http://user:pw@[::1]/", len("http://user:pw@[::1]")},
+		{"http://www.example.com/[r3;a-z]@[1-2]", 0},
+		{"http://[1-3].example.com/", 0},
+		{"www.example.com/[1-3]", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := ipv6HostEnd(tt.url); got != tt.want {
+				t.Errorf("ipv6HostEnd() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_parseUrl(t *testing.T) {
 	type args struct {
 		url string
@@ -75,6 +139,96 @@ func Test_parseUrl(t *testing.T) {
 			exactmatch: false,
 			wantErr:    false,
 		},
+		{
+			name:       "random, weighted ranges",
+			args:       args{"http://www.example.com/[r10;a-z*3_0-9] 10"},
+			wantlen:    10,
+			wantre:     regexp.MustCompile(`http://www.example.com/[a-z0-9]{10}$`),
+			exactmatch: false,
+			wantErr:    false,
+		},
+		{
+			name:    "random, invalid weight",
+			args:    args{"http://www.example.com/[r10;a-z*x] 10"},
+			wantErr: true,
+		},
+		{
+			name:    "random, zero weight",
+			args:    args{"http://www.example.com/[r10;a-z*0] 10"},
+			wantErr: true,
+		},
+		{
+			name:       "IPv6 literal host",
+			args:       args{"http://[::1]:8080/path"},
+			want:       []string{"http://[::1]:8080/path"},
+			wantlen:    1,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "IPv6 literal host with userinfo",
+			args:       args{"http://user@[fe80::1%25eth0]/"},
+			want:       []string{"http://user@[fe80::1%25eth0]/"},
+			wantlen:    1,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "IPv6 literal host with path range",
+			args:       args{"http://[::1]:8080/[1-3]"},
+			want:       []string{"http://[::1]:8080/1", "http://[::1]:8080/2", "http://[::1]:8080/3"},
+			wantlen:    3,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "IPv6 literal host with query range and randomness",
+			args:       args{"https://[2001:db8::1]/[r5;a-z]?id=[10-11]"},
+			wantlen:    2,
+			wantre:     regexp.MustCompile(`^https://\[2001:db8::1\]/[a-z]{5}\?id=1[01]$`),
+			exactmatch: false,
+			wantErr:    false,
+		},
+		{
+			name:       "query range",
+			args:       args{"http://host/search?page=[1-3]&q=test"},
+			want:       []string{"http://host/search?page=1&q=test", "http://host/search?page=2&q=test", "http://host/search?page=3&q=test"},
+			wantlen:    3,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "query range keeps encoding",
+			args:       args{"http://host/search?q=caf%C3%A9+au%20lait%26more&page=[1-2]#top"},
+			want:       []string{"http://host/search?q=caf%C3%A9+au%20lait%26more&page=1#top", "http://host/search?q=caf%C3%A9+au%20lait%26more&page=2#top"},
+			wantlen:    2,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "query range next to bracketed keys",
+			args:       args{"http://host/items?filter[name]=x&page[size]=[10-11]"},
+			want:       []string{"http://host/items?filter[name]=x&page[size]=10", "http://host/items?filter[name]=x&page[size]=11"},
+			wantlen:    2,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "bracketed keys only",
+			args:       args{"http://host/items?ids[]=1&ids[]=2&sort[role]=asc"},
+			want:       []string{"http://host/items?ids[]=1&ids[]=2&sort[role]=asc"},
+			wantlen:    1,
+			exactmatch: true,
+			wantErr:    false,
+		},
+		{
+			name:       "encoded brackets are no range",
+			args:       args{"http://host/?q=%5B1-2%5D"},
+			want:       []string{"http://host/?q=%5B1-2%5D"},
+			wantlen:    1,
+			exactmatch: true,
+			wantErr:    false,
+		},
 		{
 			name:       "range AND randomness",
 			args:       args{"http://www.example.com/[100-900]/[r10;a-z]"},
@@ -91,6 +245,9 @@ func Test_parseUrl(t *testing.T) {
 				t.Errorf("parseUrl() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				return
+			}
 			if tt.wantlen != len(got) {
 				t.Errorf("parseUrl() got %d strings (%v), want %d", len(got), got, tt.wantlen)
 			}
@@ -113,6 +270,40 @@ func Test_parseUrl(t *testing.T) {
 	}
 }
 
+func Test_randomStringWeights(t *testing.T) {
+	const length = 100000
+
+	tests := []struct {
+		name      string
+		ranges    []charrange
+		wantRatio float64 // of 'a' to 'b'
+	}{
+		{
+			name:      "unweighted",
+			ranges:    []charrange{{min: 'a', max: 'a'}, {min: 'b', max: 'b'}},
+			wantRatio: 1,
+		},
+		{
+			name:      "a thrice as likely",
+			ranges:    []charrange{{min: 'a', max: 'a', weight: 3}, {min: 'b', max: 'b', weight: 1}},
+			wantRatio: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := randomString(tt.ranges, length, 1)[0]
+			a, b := strings.Count(s, "a"), strings.Count(s, "b")
+			if a+b != length {
+				t.Fatalf("randomString() generated characters outside the ranges")
+			}
+			// with this many samples the ratio is well within 10%
+			if ratio := float64(a) / float64(b); math.Abs(ratio-tt.wantRatio) > tt.wantRatio*0.1 {
+				t.Errorf("randomString() a:b = %.2f, want %.2f", ratio, tt.wantRatio)
+			}
+		})
+	}
+}
+
 func Test_makeCharList(t *testing.T) {
 	type args struct {
 		in charrange
@@ -141,3 +332,1454 @@ func Test_makeCharList(t *testing.T) {
 		})
 	}
 }
+
+func Test_renderTail(t *testing.T) {
+	entries := []result{
+		{method: "GET", url: "http://www.example.com/1", status: 200, elapsed: 12 * time.Millisecond},
+		{method: "POST", url: "http://www.example.com/2", status: 503, elapsed: 1500 * time.Microsecond},
+		{method: "GET", url: "http://www.example.com/3", status: 0, elapsed: 30 * time.Second},
+	}
+	tests := []struct {
+		name    string
+		entries []result
+		lines   int
+		width   int
+		want    []string
+	}{
+		{
+			name:    "all entries fit",
+			entries: entries,
+			lines:   3,
+			width:   60,
+			want: []string{
+				"GET http://www.example.com/1 -> 200 (12.0 ms)",
+				"POST http://www.example.com/2 -> 503 (1.5 ms)",
+				"GET http://www.example.com/3 -> error (30000.0 ms)",
+			},
+		},
+		{
+			name:    "only most recent entries shown",
+			entries: entries,
+			lines:   2,
+			width:   60,
+			want: []string{
+				"POST http://www.example.com/2 -> 503 (1.5 ms)",
+				"GET http://www.example.com/3 -> error (30000.0 ms)",
+			},
+		},
+		{
+			name:    "blank lines above few entries",
+			entries: entries[:1],
+			lines:   3,
+			width:   60,
+			want: []string{
+				"",
+				"",
+				"GET http://www.example.com/1 -> 200 (12.0 ms)",
+			},
+		},
+		{
+			name:    "truncated to width",
+			entries: entries[:1],
+			lines:   1,
+			width:   11,
+			want:    []string{"GET http:/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			renderTail(&buf, tt.entries, tt.lines, tt.width)
+
+			got := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+			if len(got) != len(tt.want) {
+				t.Fatalf("renderTail() wrote %d lines, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if len(got[i]) != tt.width-1 {
+					t.Errorf("renderTail() line %d is %d wide, want %d", i, len(got[i]), tt.width-1)
+				}
+				if strings.TrimRight(got[i], " ") != tt.want[i] {
+					t.Errorf("renderTail() line %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_resultLog(t *testing.T) {
+	l := newResultLog(2)
+	for i := 1; i <= 3; i++ {
+		l.add(result{status: i})
+	}
+
+	got := l.recent()
+	if len(got) != 2 || got[0].status != 2 || got[1].status != 3 {
+		t.Errorf("resultLog.recent() = %v, want statuses [2 3]", got)
+	}
+
+	l.reset()
+	if got := l.recent(); len(got) != 0 {
+		t.Errorf("resultLog.recent() after reset = %v, want empty", got)
+	}
+}
+
+func Test_computeLayout(t *testing.T) {
+	minY, maxY = 0, 100
+
+	tests := []struct {
+		name           string
+		width, height  uint
+		tail           uint
+		wantPlotHeight uint
+		wantErr        bool
+	}{
+		{
+			name:           "plot fills the screen below the stats",
+			width:          80,
+			height:         24,
+			wantPlotHeight: 21,
+		},
+		{
+			name:           "tail pane takes from the plot",
+			width:          80,
+			height:         24,
+			tail:           5,
+			wantPlotHeight: 16,
+		},
+		{
+			name:    "too narrow",
+			width:   40,
+			height:  24,
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			width:   80,
+			height:  6,
+			wantErr: true,
+		},
+		{
+			name:    "too short for the tail pane",
+			width:   80,
+			height:  10,
+			tail:    4,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := computeLayout(tt.width, tt.height, tt.tail)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("computeLayout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.plotWidth != tt.width || got.plotHeight != tt.wantPlotHeight {
+				t.Errorf("computeLayout() plot = %dx%d, want %dx%d", got.plotWidth, got.plotHeight, tt.width, tt.wantPlotHeight)
+			}
+			if got.buckets != got.plotHeight {
+				t.Errorf("computeLayout() buckets = %d, want %d", got.buckets, got.plotHeight)
+			}
+			// the second to last bucket ends at maxY
+			if end := minY + math.Pow(got.logBase, float64(got.buckets-2)); math.Abs(end-maxY) > 1e-9 {
+				t.Errorf("computeLayout() last bucket ends at %f, want %f", end, maxY)
+			}
+		})
+	}
+}
+
+func Test_applyLayout(t *testing.T) {
+	minY, maxY = 0, 100
+
+	for _, size := range [][2]uint{{80, 24}, {120, 40}, {60, 12}} {
+		l, err := computeLayout(size[0], size[1], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		applyLayout(l)
+		for i := range timingsOk {
+			if uint(len(timingsOk[i])) != buckets || uint(len(timingsBad[i])) != buckets {
+				t.Fatalf("applyLayout() slot %d has %d/%d buckets, want %d", i, len(timingsOk[i]), len(timingsBad[i]), buckets)
+			}
+		}
+		if buckets != l.buckets || plotHeight != l.plotHeight || terminalWidth != size[0] {
+			t.Errorf("applyLayout() did not switch to layout %+v", l)
+		}
+	}
+}
+
+func Test_resizeTooSmall(t *testing.T) {
+	setupTestLayout(t)
+	defer setupTestLayout(t)
+
+	before := buckets
+	resize(30, 5)
+	if !tooSmall {
+		t.Fatal("resize() to 30x5 should pause drawing")
+	}
+	if terminalWidth != 30 || terminalHeight != 5 || plotWidth != 80 || buckets != before {
+		t.Errorf("resize() to 30x5 = terminal %dx%d, plot width %d, %d buckets, want the new terminal size and the old layout", terminalWidth, terminalHeight, plotWidth, buckets)
+	}
+
+	var buf bytes.Buffer
+	renderTooSmall(&buf, 30, 5)
+	if got := buf.String(); got != "\033[Hterminal too small, need 41x7" {
+		t.Errorf("renderTooSmall() = %q, want the size needed", got)
+	}
+	buf.Reset()
+	renderTooSmall(&buf, 18, 5)
+	if got := buf.String(); got != "\033[Hterminal too small" {
+		t.Errorf("renderTooSmall() = %q, want the message cut to 18 characters", got)
+	}
+	buf.Reset()
+	renderTooSmall(&buf, 0, 0)
+	if buf.Len() != 0 {
+		t.Errorf("renderTooSmall() on a 0x0 terminal wrote %q", buf.String())
+	}
+
+	resize(100, 30)
+	if tooSmall || plotWidth != 100 {
+		t.Errorf("resize() to 100x30 should draw again at the new size, got plot width %d, too small %v", plotWidth, tooSmall)
+	}
+}
+
+func Test_barWidths(t *testing.T) {
+	tests := []struct {
+		plotWidth   uint
+		degraded    bool
+		wantBar     int
+		wantHeatmap int
+	}{
+		{80, false, 40, 40 + heatmapExtraWidth},
+		{80, true, 40 - degradedCellWidth, 40 + heatmapExtraWidth},
+		{43, true, 0, 3 + heatmapExtraWidth},
+		{20, false, 0, heatmapExtraWidth},
+		{0, true, 0, heatmapExtraWidth},
+	}
+	for _, tt := range tests {
+		bar, heatmap := barWidths(tt.plotWidth, tt.degraded)
+		if bar != tt.wantBar || heatmap != tt.wantHeatmap {
+			t.Errorf("barWidths(%d, %v) = %d, %d, want %d, %d", tt.plotWidth, tt.degraded, bar, heatmap, tt.wantBar, tt.wantHeatmap)
+		}
+	}
+
+	// bars of no width still draw their labels
+	setupTestLayout(t)
+	degradedMs = 100
+	defer func() { degradedMs = 0 }()
+	counts := make([]int64, buckets)
+	counts[1] = 10
+
+	var buf bytes.Buffer
+	layoutMu.RLock()
+	renderHistogram(&buf, palettes["mono"], counts, counts, counts, len(counts), 0, -1, false)
+	layoutMu.RUnlock()
+	if !strings.Contains(buf.String(), "[    10/    10/    10]") {
+		t.Errorf("renderHistogram() of 0 wide bars = %q, want the counts", buf.String())
+	}
+}
+
+func Test_fixedBuckets(t *testing.T) {
+	fixedBuckets = 40
+	defer func() {
+		fixedBuckets = 0
+		setupTestLayout(t)
+	}()
+
+	var want Summary
+	for i, height := range []uint{24, 60, 12} {
+		minY, maxY = 0, 100
+		l, err := computeLayout(80, height, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.buckets != 40 {
+			t.Fatalf("computeLayout() with a %d line terminal has %d buckets, want 40", height, l.buckets)
+		}
+
+		applyLayout(l)
+		resetStats()
+		now := time.Now()
+		for ms := 1; ms <= 100; ms++ {
+			recordTiming(now, time.Duration(ms)*time.Millisecond, true)
+		}
+
+		got := buildSummary(now)
+		if i == 0 {
+			want = got
+			continue
+		}
+		if got.P50 != want.P50 || got.P90 != want.P90 || got.P99 != want.P99 {
+			t.Errorf("percentiles with a %d line terminal = %.2f/%.2f/%.2f, want %.2f/%.2f/%.2f as with 24 lines",
+				height, got.P50, got.P90, got.P99, want.P50, want.P90, want.P99)
+		}
+	}
+}
+
+func Test_fixedLogBase(t *testing.T) {
+	defer func() {
+		fixedLogBase = 0
+		setupTestLayout(t)
+	}()
+
+	tests := []struct {
+		base       float64
+		minY, maxY float64
+		buckets    uint
+	}{
+		{2, 0, 100, 9}, // 2^7 is the first power past 100
+		{10, 0, 100, 4},
+		{10, 5, 1005, 5},
+		{1.5, 0, 100, 14},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%g from %g to %g", tt.base, tt.minY, tt.maxY), func(t *testing.T) {
+			fixedLogBase = tt.base
+			minY, maxY = tt.minY, tt.maxY
+			l, err := computeLayout(80, 24, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.buckets != tt.buckets || l.logBase != tt.base {
+				t.Fatalf("computeLayout() = %d buckets of base %g, want %d of base %g", l.buckets, l.logBase, tt.buckets, tt.base)
+			}
+			applyLayout(l)
+
+			layoutMu.RLock()
+			defer layoutMu.RUnlock()
+			if upper := bucketUpperMs(buckets - 2); upper < maxY {
+				t.Errorf("bucketUpperMs(%d) = %g, want the buckets to reach -maxY %g", buckets-2, upper, maxY)
+			}
+			for bkt := uint(1); bkt < buckets-2; bkt++ {
+				ratio := (bucketUpperMs(bkt+1) - minY) / (bucketUpperMs(bkt) - minY)
+				if math.Abs(ratio-tt.base) > 1e-9 {
+					t.Errorf("bucket %d is %g times bucket %d, want %g", bkt+1, ratio, bkt, tt.base)
+				}
+
+				// attack puts a response right at a bound in the next bucket
+				if _, upper := bucketBoundsMs(bkt); bucketIndex(upper) != int(bkt)+1 || bucketIndex(math.Nextafter(upper, 0)) != int(bkt) {
+					t.Errorf("bucketIndex() around minY+base^%d = %d and %d, want %d and %d", bkt, bucketIndex(upper), bucketIndex(math.Nextafter(upper, 0)), bkt+1, bkt)
+				}
+			}
+		})
+	}
+}
+
+func Test_bucketIndexBounds(t *testing.T) {
+	defer func() { fixedLogBase = 0 }()
+
+	tests := []struct {
+		name       string
+		base       float64
+		minY, maxY float64
+	}{
+		{"from 0", 0, 0, 100},
+		{"from far off 0", 0, 5000, 5100},
+		{"fixed base", 2, 300, 1300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixedLogBase = tt.base
+			minY, maxY = tt.minY, tt.maxY
+			l, err := computeLayout(80, 24, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			applyLayout(l)
+
+			layoutMu.RLock()
+			defer layoutMu.RUnlock()
+
+			for _, elapsed := range []float64{-1, 0, minY, minY + 0.999, math.NaN()} {
+				if got := bucketIndex(elapsed); got != 0 {
+					t.Errorf("bucketIndex(%g) = %d, want the first bucket", elapsed, got)
+				}
+			}
+			if got := bucketIndex(minY + 1); got != 1 {
+				t.Errorf("bucketIndex(minY+1) = %d, want 1", got)
+			}
+			if got := bucketIndex(math.MaxFloat64); got != int(buckets)-1 {
+				t.Errorf("bucketIndex(max) = %d, want the last bucket", got)
+			}
+
+			for bkt := uint(1); bkt < buckets; bkt++ {
+				lower, upper := bucketBoundsMs(bkt)
+				if prevLower, prevUpper := bucketBoundsMs(bkt - 1); prevUpper != lower || prevLower >= lower {
+					t.Fatalf("bucket %d starts at %g, want where bucket %d ends, %g", bkt, lower, bkt-1, prevUpper)
+				}
+
+				// a latency exactly at a bound starts its bucket, a hair less ends the last one
+				if got := bucketIndex(lower); got != int(bkt) {
+					t.Errorf("bucketIndex(%g), the start of bucket %d, = %d", lower, bkt, got)
+				}
+				if got := bucketIndex(math.Nextafter(lower, 0)); got != int(bkt)-1 {
+					t.Errorf("bucketIndex() right before %g, the start of bucket %d, = %d, want %d", lower, bkt, got, bkt-1)
+				}
+
+				// and the label says so
+				want := fmt.Sprintf(labelFormat(lower)+"+", lower)
+				if bkt < buckets-1 {
+					f := labelFormat(upper)
+					want = fmt.Sprintf(f+"-"+f, lower, upper)
+				}
+				if got := rangeLabel(bkt, bkt); got != want {
+					t.Errorf("rangeLabel(%d) = %q, want %q", bkt, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_plotRows(t *testing.T) {
+	tests := []struct {
+		n, rows int
+		want    [][2]int
+	}{
+		{3, 5, [][2]int{{0, 0}, {1, 1}, {2, 2}}},
+		{4, 2, [][2]int{{0, 1}, {2, 3}}},
+		{5, 3, [][2]int{{0, 0}, {1, 2}, {3, 4}}},
+		{3, 0, [][2]int{{0, 0}, {1, 1}, {2, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d in %d", tt.n, tt.rows), func(t *testing.T) {
+			if got := plotRows(tt.n, tt.rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("plotRows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_renderHistogramMerged(t *testing.T) {
+	setupTestLayout(t)
+
+	tOk := make([]int64, buckets)
+	for bkt := range tOk {
+		tOk[bkt] = 1
+	}
+
+	var buf bytes.Buffer
+	layoutMu.RLock()
+	renderHistogram(&buf, palettes["mono"], tOk, make([]int64, buckets), make([]int64, buckets), 4, 20, -1, false)
+	first, last := strings.TrimSpace(rangeLabel(0, buckets/4-1)), strings.TrimSpace(rangeLabel(3*buckets/4, buckets-1))
+	layoutMu.RUnlock()
+
+	rows := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(rows) != 4 {
+		t.Fatalf("renderHistogram() into 4 rows wrote %d", len(rows))
+	}
+	if !strings.HasPrefix(strings.TrimSpace(rows[0]), first+" ms") || !strings.HasPrefix(strings.TrimSpace(rows[3]), last+" ms") {
+		t.Errorf("renderHistogram() rows are labeled %q and %q, want %q and %q", rows[0], rows[3], first, last)
+	}
+	if !strings.HasSuffix(last, "+") || !strings.HasPrefix(first, "<") {
+		t.Errorf("rangeLabel() gave %q and %q for the outer rows, want open ended ranges", first, last)
+	}
+}
+
+var ansiEscape = regexp.MustCompile("\033\\[[0-9;]*m")
+
+func Test_renderStatusLine(t *testing.T) {
+	st := statusLine{sent: 120, recv: 118, ok: 105, rate: 50, desired: 50}
+	st.responses[0] = 3
+	st.responses[200] = 100
+	st.responses[204] = 5
+	st.responses[503] = 10
+
+	tests := []struct {
+		name  string
+		width int
+		quiet bool
+		st    statusLine
+		want  string
+	}{
+		{
+			name:  "verbose",
+			width: 120,
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS responses: [0]: 3      [200]: 100    [204]: 5      [503]: 10     ",
+		},
+		{
+			name:  "quiet",
+			width: 120,
+			quiet: true,
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS responses: ok=105 err=13",
+		},
+		{
+			name:  "quiet, failing validation",
+			width: 120,
+			quiet: true,
+			st:    statusLine{sent: 120, recv: 118, ok: 100, invalid: 5, rate: 50, desired: 50},
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS responses: invalid: 5 ok=100 err=18",
+		},
+		{
+			name:  "lagging",
+			width: 120,
+			quiet: true,
+			st:    statusLine{sent: 120, recv: 118, rate: 40, desired: 50, workers: 10},
+			want:  "sent: 120    in-flight: 2  rate:   40/50 RPS lagging, try -workers 10 responses: ok=0 err=118",
+		},
+		{
+			name:  "workers",
+			width: 120,
+			quiet: true,
+			st:    statusLine{sent: 120, recv: 118, rate: 50, desired: 50, running: 12},
+			want:  "sent: 120    in-flight: 2  workers: 12 rate:   50/50 RPS responses: ok=0 err=118",
+		},
+		{
+			name:  "throughput",
+			width: 120,
+			quiet: true,
+			st:    statusLine{sent: 120, recv: 118, rate: 50, byteRate: 3 << 20, desired: 50},
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS 3.0MB/s responses: ok=0 err=118",
+		},
+		{
+			name:  "connection reuse",
+			width: 120,
+			quiet: true,
+			st:    statusLine{sent: 120, recv: 118, rate: 50, desired: 50, reused: 98, newConns: 2},
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS reuse: 98% responses: ok=0 err=118",
+		},
+		{
+			name:  "upload time",
+			width: 120,
+			quiet: true,
+			st:    statusLine{sent: 120, recv: 118, rate: 50, desired: 50, uploads: 10, uploadMs: 12.5, uploadPct: 40},
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS upload: 12.5ms (40%) responses: ok=0 err=118",
+		},
+		{
+			name:  "verbose, too narrow for all statuses",
+			width: 90,
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS responses: [0]: 3      [200]: 100    ...",
+		},
+		{
+			name:  "quiet, too narrow for the totals",
+			width: 64,
+			quiet: true,
+			want:  "sent: 120    in-flight: 2  rate:   50/50 RPS responses: ok=105",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if tt.st.sent == 0 {
+				tt.st = st
+			}
+			renderStatusLine(&buf, tt.st, tt.width, tt.quiet)
+
+			got := ansiEscape.ReplaceAllString(buf.String(), "")
+			if len(got) != tt.width-1 {
+				t.Errorf("renderStatusLine() is %d wide, want %d", len(got), tt.width-1)
+			}
+			if strings.TrimRight(got, " ") != strings.TrimRight(tt.want, " ") {
+				t.Errorf("renderStatusLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func benchmarkConsumeBody(b *testing.B, mode int) {
+	payload := strings.Repeat("x", 64*1024)
+
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, payload)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := consumeBody(resp.Body, mode); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(atomic.LoadInt64(&conns))/float64(b.N), "conns/op")
+}
+
+func BenchmarkConsumeBodyRead(b *testing.B)    { benchmarkConsumeBody(b, bodyRead) }
+func BenchmarkConsumeBodyDiscard(b *testing.B) { benchmarkConsumeBody(b, bodyDiscard) }
+func BenchmarkConsumeBodySkip(b *testing.B)    { benchmarkConsumeBody(b, bodySkip) }
+
+func Test_lagDetector(t *testing.T) {
+	tests := []struct {
+		name     string
+		achieved []int64
+		desired  int64
+		want     bool
+	}{
+		{
+			name:     "keeping up",
+			achieved: []int64{100, 98, 100, 95, 100, 99},
+			desired:  100,
+			want:     false,
+		},
+		{
+			name:     "persistently behind",
+			achieved: []int64{60, 55, 62, 58, 61},
+			desired:  100,
+			want:     true,
+		},
+		{
+			name:     "briefly behind",
+			achieved: []int64{60, 55, 62, 58, 100},
+			desired:  100,
+			want:     false,
+		},
+		{
+			name:     "not long enough",
+			achieved: []int64{60, 55, 62, 58},
+			desired:  100,
+			want:     false,
+		},
+		{
+			name:     "paused",
+			achieved: []int64{0, 0, 0, 0, 0, 0},
+			desired:  0,
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d lagDetector
+			var got bool
+			for _, achieved := range tt.achieved {
+				got = d.observe(achieved, tt.desired)
+			}
+			if got != tt.want {
+				t.Errorf("lagDetector.observe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_suggestWorkers(t *testing.T) {
+	if got := suggestWorkers(8, 50, 100); got != 16 {
+		t.Errorf("suggestWorkers(8, 50, 100) = %d, want 16", got)
+	}
+	if got := suggestWorkers(8, 70, 100); got != 12 {
+		t.Errorf("suggestWorkers(8, 70, 100) = %d, want 12", got)
+	}
+	if got := suggestWorkers(8, 0, 100); got != 16 {
+		t.Errorf("suggestWorkers(8, 0, 100) = %d, want 16", got)
+	}
+}
+
+// windowTotals sums the ok and bad counts over the whole moving window
+func windowTotals() (int64, int64) {
+	layoutMu.RLock()
+	defer layoutMu.RUnlock()
+
+	var ok, bad int64
+	for i := range timingsOk {
+		for j := range timingsOk[i] {
+			ok += timingsOk[i][j].Load()
+			bad += timingsBad[i][j].Load()
+		}
+	}
+
+	return ok, bad
+}
+
+// attackOnce runs attack for a single request against url and waits until its response is recorded
+func attackOnce(t *testing.T, url string) {
+	trgt := &targeter{requests: []request{{method: "GET", url: url}}}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time, 1)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, client, ch, quit)
+	}()
+	defer func() {
+		close(quit)
+		<-done
+	}()
+
+	before := responsesReceived.Load()
+	ch <- time.Now()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ok, bad := windowTotals()
+		if responsesReceived.Load() > before && ok+bad > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("attack() never recorded the response")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_attackOkStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	defer func() { okStatuses = statusSet{} }()
+
+	tests := []struct {
+		name     string
+		okStatus string
+		wantOk   int64
+		wantBad  int64
+	}{
+		{name: "404 is an error by default", wantBad: 1},
+		{name: "404 configured as ok", okStatus: "404", wantOk: 1},
+		{name: "404 in a configured range", okStatus: "300-499", wantOk: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestLayout(t)
+			okStatuses = statusSet{}
+			if tt.okStatus != "" {
+				if err := okStatuses.Set(tt.okStatus); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			attackOnce(t, srv.URL)
+
+			if ok, bad := windowTotals(); ok != tt.wantOk || bad != tt.wantBad {
+				t.Errorf("attack() recorded ok/bad %d/%d, want %d/%d", ok, bad, tt.wantOk, tt.wantBad)
+			}
+		})
+	}
+}
+
+func Test_statusSet(t *testing.T) {
+	var s statusSet
+	if err := s.Set("404, 410,300-302"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.String(); got != "300,301,302,404,410" {
+		t.Errorf("statusSet = %v, want 300,301,302,404,410", got)
+	}
+	if !isOK(200) || isOK(0) || isOK(500) {
+		t.Errorf("isOK() should keep 2xx ok and others not")
+	}
+
+	for _, invalid := range []string{"abc", "99", "600", "400-300", "404,"} {
+		var s statusSet
+		if err := s.Set(invalid); err == nil {
+			t.Errorf("statusSet.Set(%q) expected an error", invalid)
+		}
+	}
+}
+
+func Test_attackMaxInflight(t *testing.T) {
+	setupTestLayout(t)
+
+	const limit, workers, ticks = 2, 6, 30
+
+	var current, peak int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&current, 1)
+		defer atomic.AddInt64(&current, -1)
+		for {
+			p := atomic.LoadInt64(&peak)
+			if n <= p || atomic.CompareAndSwapInt64(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	inflightSlots = make(chan struct{}, limit)
+
+	trgt := &targeter{requests: []request{{method: "GET", url: srv.URL}}}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attack(trgt, client, ch, quit)
+		}()
+	}
+
+	for i := 0; i < ticks; i++ {
+		ch <- time.Now()
+		time.Sleep(time.Millisecond)
+	}
+	close(quit)
+
+	// workers still in flight need the slots to give theirs back
+	wg.Wait()
+	inflightSlots = nil
+
+	if p := atomic.LoadInt64(&peak); p > limit {
+		t.Errorf("attack() had %d requests in flight, want at most %d", p, limit)
+	}
+	if skippedTicks.Load() == 0 {
+		t.Errorf("attack() skipped no ticks while at the limit")
+	}
+}
+
+func Test_tickerDrops(t *testing.T) {
+	droppedTicks.Store(0)
+	defer droppedTicks.Store(0)
+
+	quit := make(chan struct{})
+	defer close(quit)
+	ticks, _ := ticker(1000, 0, nil, quit)
+
+	// a single worker that is busy for far longer than the tick interval
+	<-ticks
+	time.Sleep(50 * time.Millisecond)
+	<-ticks
+
+	if dropped := droppedTicks.Load(); dropped < 10 {
+		t.Errorf("ticker() dropped %d ticks while the worker was busy, want at least 10", dropped)
+	}
+
+	before := droppedTicks.Load()
+	deadline := time.Now().Add(20 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ticks:
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if dropped := droppedTicks.Load() - before; dropped > 5 {
+		t.Errorf("ticker() dropped %d ticks while keeping up", dropped)
+	}
+}
+
+func Test_attackMethodTimeout(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	methodTimeouts = map[string]time.Duration{"GET": 50 * time.Millisecond}
+	defer func() { methodTimeouts = map[string]time.Duration{} }()
+
+	if got := timeoutFor("POST"); got != requestTimeout {
+		t.Errorf("timeoutFor(POST) = %v, want the default %v", got, requestTimeout)
+	}
+
+	start := time.Now()
+	attackOnce(t, srv.URL)
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("attack() took %v, want the GET timeout to cut it short", elapsed)
+	}
+	if errs := responses[0].Load(); errs != 1 {
+		t.Errorf("attack() counted %d failed requests, want 1", errs)
+	}
+}
+
+func Test_tickerBurst(t *testing.T) {
+	const burst = 5
+	interval := 50 * time.Millisecond
+
+	quit := make(chan struct{})
+	defer close(quit)
+
+	var burstDone int64
+	base := ticksHandled.Load()
+	ticks, _ := ticker(uint64(time.Second/interval), burst, func() { atomic.AddInt64(&burstDone, 1) }, quit)
+
+	start := time.Now()
+	for i := 0; i < burst; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(interval / 2):
+			t.Fatalf("ticker() burst tick %d not sent right away", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > interval/2 {
+		t.Errorf("ticker() took %v for the burst, want it as fast as possible", elapsed)
+	}
+
+	// no pacing until the workers are done with the burst
+	select {
+	case <-ticks:
+		t.Fatal("ticker() started pacing before the burst was handled")
+	case <-time.After(2 * interval):
+	}
+	if atomic.LoadInt64(&burstDone) != 0 {
+		t.Error("ticker() called afterBurst before the burst was handled")
+	}
+
+	ticksHandled.Add(burst)
+	answered := time.Now()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ticks:
+		case <-time.After(4 * interval):
+			t.Fatal("ticker() never started pacing")
+		}
+	}
+	if elapsed := time.Since(answered); elapsed < interval {
+		t.Errorf("ticker() sent two paced ticks in %v, want them %v apart", elapsed, interval)
+	}
+	if got := atomic.LoadInt64(&burstDone); got != 1 {
+		t.Errorf("ticker() called afterBurst %d times, want once", got)
+	}
+
+	ticksHandled.Store(base)
+}
+
+func Test_tickerBurstSkipped(t *testing.T) {
+	setupTestLayout(t)
+
+	// every tick is skipped, none of them ever gets a response
+	inflightSlots = make(chan struct{}, 1)
+	inflightSlots <- struct{}{}
+
+	trgt := &targeter{requests: []request{{method: "GET", url: "http://127.0.0.1:1/"}}}
+	quit := make(chan struct{})
+	ticks, _ := ticker(1000, 3, nil, quit)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		attack(trgt, http.DefaultClient, ticks, quit)
+	}()
+
+	skipped := skippedTicks.Load()
+	deadline := time.Now().Add(5 * time.Second)
+	for skippedTicks.Load()-skipped < 10 {
+		if time.Now().After(deadline) {
+			t.Errorf("ticker() paced %d ticks after a burst of skipped ones, want it to carry on", skippedTicks.Load()-skipped-3)
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(quit)
+	wg.Wait()
+	inflightSlots = nil
+}
+
+func Test_bucketIndexSLO(t *testing.T) {
+	setupTestLayout(t)
+
+	// 21 buckets of base 100^(1/19) from 1ms: 11ms is base^9.9, in bucket 10
+	const sloMs = 11
+	sloBucket := bucketIndex(sloMs)
+	if sloBucket != 10 {
+		t.Fatalf("bucketIndex(%v) = %d, want 10", float64(sloMs), sloBucket)
+	}
+
+	// attack buckets a response of exactly the SLO the same way
+	recordTiming(time.Now(), sloMs*time.Millisecond, true)
+	layoutMu.RLock()
+	tOk, _, _ := windowTimings()
+	layoutMu.RUnlock()
+	if got := tOk[sloBucket]; got != 1 {
+		t.Errorf("recordTiming(%vms) not counted in bucket %d", float64(sloMs), sloBucket)
+	}
+
+	tests := []struct {
+		name   string
+		counts map[int]int64
+		want   float64
+	}{
+		{"empty", nil, 0},
+		{"all faster", map[int]int64{0: 10, 9: 10}, 0},
+		{"slo bucket is not a breach", map[int]int64{5: 50, 10: 50}, 0},
+		{"quarter above", map[int]int64{0: 50, 10: 25, 11: 20, 20: 5}, 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := make([]int64, buckets)
+			for bkt, c := range tt.counts {
+				counts[bkt] = c
+			}
+
+			if got := sloBreach(counts, sloBucket); got != tt.want {
+				t.Errorf("sloBreach() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_durationRange(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+		wantErr  bool
+	}{
+		{"1s", time.Second, time.Second, false},
+		{"500ms-2s", 500 * time.Millisecond, 2 * time.Second, false},
+		{"0s", 0, 0, false},
+		{"2s-1s", 0, 0, true},
+		{"-1s", 0, 0, true},
+		{"soon", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var d durationRange
+			err := d.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if d.min != tt.min || d.max != tt.max {
+				t.Errorf("Set(%q) = %v-%v, want %v-%v", tt.value, d.min, d.max, tt.min, tt.max)
+			}
+			for i := 0; i < 100; i++ {
+				if r := d.random(); r < tt.min || r > tt.max {
+					t.Fatalf("random() = %v, want within %v-%v", r, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func Test_attackThinkTime(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	const pause = 100 * time.Millisecond
+	thinkTime = durationRange{pause, pause}
+
+	trgt := &targeter{requests: []request{{method: "GET", url: srv.URL}}}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, client, ch, quit)
+	}()
+
+	ch <- time.Now()
+	start := time.Now()
+	ch <- time.Now() // taken only once the worker is done thinking
+	elapsed := time.Since(start)
+
+	// the worker reads thinkTime until it is gone
+	close(quit)
+	<-done
+	thinkTime = durationRange{}
+
+	if elapsed < pause {
+		t.Errorf("attack() took the next tick after %v, want at least %v of think time", elapsed, pause)
+	}
+}
+
+func Test_totalRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      rateFlag
+		perWorker rateFlag
+		workers   uint
+		want      uint64
+		wantErr   bool
+	}{
+		{"-rate alone", 50, 0, 8, 50, false},
+		{"per worker times workers", 50, 20, 8, 160, false},
+		{"no workers", 50, 20, 0, 0, false},
+		{"overflow", 50, math.MaxInt64, 2, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := totalRate(tt.rate, tt.perWorker, tt.workers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("totalRate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("totalRate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// the ticker paces at the total
+	defer desiredRate.Store(0)
+	quit := make(chan struct{})
+	defer close(quit)
+
+	total, _ := totalRate(50, 20, 8)
+	ticker(total, 0, nil, quit)
+	deadline := time.Now().Add(time.Second)
+	for desiredRate.Load() != 160 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := desiredRate.Load(); got != 160 {
+		t.Errorf("desired rate = %d, want 20 per worker times 8 workers", got)
+	}
+}
+
+func Test_rateFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    uint64
+		wantErr bool
+	}{
+		{"50", 50, false},
+		{"10k", 10000, false},
+		{"2.5k", 2500, false},
+		{"10K", 10000, false},
+		{"1m", 1000000, false},
+		{"0", 0, false},
+		{"1.5", 0, true},
+		{"1.0005k", 0, true},
+		{"16.1k", 16100, false},
+		{"4.1m", 4100000, false},
+		{"0.001m", 1000, false},
+		{".5k", 500, false},
+		{"2.50k", 2500, false},
+		{"7.0", 7, false},
+		{"1.k", 1000, false},
+		{".k", 0, true},
+		{"1.2.3k", 0, true},
+		{"9223372036854775808", 0, true},
+		{"-5", 0, true},
+		{"k", 0, true},
+		{"10x", 0, true},
+		{"", 0, true},
+		{"infk", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var r rateFlag
+			err := r.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if uint64(r) != tt.want {
+				t.Errorf("Set(%q) = %d, want %d", tt.value, r, tt.want)
+			}
+		})
+	}
+}
+
+func Test_consumeBodyMaxRead(t *testing.T) {
+	const size = 4 << 20
+	payload := strings.Repeat("x", size)
+
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, payload)
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	maxBodyRead = 1024
+	defer func() { maxBodyRead = 0 }()
+
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+	before := bytesRead.Load()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := consumeBody(resp.Body, bodyRead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(body) != 1024 {
+			t.Errorf("consumeBody() read %d bytes, want the 1024 of -max-body-read", len(body))
+		}
+	}
+
+	if got := bytesRead.Load() - before; got != 2*size {
+		t.Errorf("consumeBody() counted %d bytes, want all %d received", got, 2*size)
+	}
+	if got := atomic.LoadInt64(&conns); got != 1 {
+		t.Errorf("consumeBody() with -max-body-read used %d connections, want 1 kept alive", got)
+	}
+}
+
+func Test_formatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1.0KB"},
+		{1536, "1.5KB"},
+		{5 << 20, "5.0MB"},
+		{3 << 30, "3.0GB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func Test_attackDrain(t *testing.T) {
+	setupTestLayout(t)
+
+	tests := []struct {
+		name          string
+		serverDelay   time.Duration
+		drain         time.Duration
+		wantResponses int64
+		wantAbandoned int64
+	}{
+		{"finishes within the drain window", 100 * time.Millisecond, time.Second, 1, 0},
+		{"abandoned after the drain window", 5 * time.Second, 50 * time.Millisecond, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			arrived := make(chan struct{}, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				select {
+				case <-time.After(tt.serverDelay):
+				case <-release:
+				}
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			inflightCtx, abandonInflight = context.WithCancel(context.Background())
+			defer func() { inflightCtx, abandonInflight = context.WithCancel(context.Background()) }()
+
+			trgt := &targeter{requests: []request{{method: "GET", url: srv.URL}}}
+			client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+			ch := make(chan time.Time, 1)
+			quit := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				attack(trgt, client, ch, quit)
+			}()
+
+			responses, abandonedBefore := responsesReceived.Load(), abandoned.Load()
+			ch <- time.Now()
+			<-arrived
+
+			// quit with the request in flight, as main does
+			close(quit)
+			timer := time.AfterFunc(tt.drain, abandonInflight)
+			defer timer.Stop()
+
+			select {
+			case <-done:
+			case <-time.After(tt.drain + time.Second):
+				t.Fatal("attack() didn't return within the drain window")
+			}
+
+			if got := responsesReceived.Load() - responses; got != tt.wantResponses {
+				t.Errorf("attack() counted %d responses, want %d", got, tt.wantResponses)
+			}
+			if got := abandoned.Load() - abandonedBefore; got != tt.wantAbandoned {
+				t.Errorf("attack() abandoned %d requests, want %d", got, tt.wantAbandoned)
+			}
+		})
+	}
+}
+
+func Test_parseUrlSeed(t *testing.T) {
+	defer func() { urlRand = nil }()
+
+	expand := func(seed int64) []string {
+		urlRand = rand.New(rand.NewSource(seed))
+		got, err := parseUrl("http://www.example.com/[r10;a-z]?q=[r5;0-9] 20")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	first, again, other := expand(42), expand(42), expand(43)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("parseUrl() with the same seed gave %v, then %v", first, again)
+	}
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("parseUrl() gave %v for different seeds", first)
+	}
+}
+
+func Test_attackDegraded(t *testing.T) {
+	setupTestLayout(t)
+	degradedMs = 20
+	defer func() { degradedMs = 0 }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fast" {
+			time.Sleep(30 * time.Millisecond)
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{requests: []request{
+		{method: "GET", url: srv.URL + "/fail"},
+		{method: "GET", url: srv.URL + "/fast"},
+		{method: "GET", url: srv.URL + "/slow"},
+	}}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, http.DefaultClient, ch, quit)
+	}()
+	for range trgt.requests {
+		ch <- time.Now()
+	}
+	close(quit)
+	<-done
+
+	layoutMu.RLock()
+	tOk, tDegraded, tBad := windowTimings()
+	layoutMu.RUnlock()
+
+	sum := func(counts []int64) (n int64) {
+		for _, c := range counts {
+			n += c
+		}
+		return n
+	}
+	if ok, degraded, bad := sum(tOk), sum(tDegraded), sum(tBad); ok != 1 || degraded != 1 || bad != 1 {
+		t.Errorf("attack() counted %d ok, %d degraded and %d bad, want the slow 200 degraded and one of each", ok, degraded, bad)
+	}
+}
+
+func Test_attackSoftDeadline(t *testing.T) {
+	setupTestLayout(t)
+	softDeadline = 20 * time.Millisecond
+	defer func() { softDeadline = 0 }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/straggler" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}))
+	defer srv.Close()
+
+	trgt := &targeter{requests: []request{
+		{method: "GET", url: srv.URL + "/straggler"},
+		{method: "GET", url: srv.URL + "/fast"},
+	}}
+
+	failed := errorsTotal.Load()
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, http.DefaultClient, ch, quit)
+	}()
+	start := time.Now()
+	for range trgt.requests {
+		ch <- time.Now()
+	}
+	close(quit)
+	<-done
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("attack() took %s, want the straggler canceled after %s", elapsed, softDeadline)
+	}
+	if got := requestsCanceled.Load(); got != 1 {
+		t.Errorf("attack() canceled %d requests, want 1", got)
+	}
+	if got, want := responsesReceived.Load(), int64(1); got != want {
+		t.Errorf("attack() received %d responses, want %d, not counting the canceled one", got, want)
+	}
+	if got := errorsTotal.Load() - failed; got != 0 {
+		t.Errorf("attack() counted %d errors, want the canceled request not to be one", got)
+	}
+}
+
+func Test_resetVariants(t *testing.T) {
+	tests := []struct {
+		name                   string
+		reset                  func()
+		wantTotals, wantWindow bool // still there after reset
+	}{
+		{"all", resetStats, false, false},
+		{"cumulative", resetCumulative, false, true},
+		{"window", resetWindow, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestLayout(t)
+			defer resetStats()
+			recentResults = newResultLog(5)
+			defer func() { recentResults = nil }()
+
+			requestsSent.Store(3)
+			responsesReceived.Store(3)
+			responses[200].Add(2)
+			responses[500].Add(1)
+			for _, ok := range []bool{true, true, false} {
+				recordTiming(time.Now(), 5*time.Millisecond, ok)
+			}
+			recentResults.add(result{method: "GET", url: "http://a/", status: 200})
+
+			tt.reset()
+
+			layoutMu.RLock()
+			var total int64
+			for i := range timingsTotal {
+				total += timingsTotal[i].Load()
+			}
+			layoutMu.RUnlock()
+			totals := requestsSent.Load() == 3 && responsesReceived.Load() == 3 && responses[200].Load() == 2 && total == 3
+			if !totals && (requestsSent.Load() != 0 || responsesReceived.Load() != 0 || responses[200].Load() != 0 || total != 0) {
+				t.Fatalf("reset left totals half cleared: sent %d, received %d, 200s %d, total %d", requestsSent.Load(), responsesReceived.Load(), responses[200].Load(), total)
+			}
+			if totals != tt.wantTotals {
+				t.Errorf("reset kept totals = %v, want %v", totals, tt.wantTotals)
+			}
+
+			ok, bad := windowTotals()
+			window := ok == 2 && bad == 1 && len(recentResults.recent()) == 1
+			if !window && (ok != 0 || bad != 0 || len(recentResults.recent()) != 0) {
+				t.Fatalf("reset left the window half cleared: %d ok, %d bad, %d in the tail", ok, bad, len(recentResults.recent()))
+			}
+			if window != tt.wantWindow {
+				t.Errorf("reset kept the window = %v, want %v", window, tt.wantWindow)
+			}
+		})
+	}
+}
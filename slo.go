@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// sloWatchdog ends a run once the p99 latency has stayed above the
+// objective for longer than the budget
+type sloWatchdog struct {
+	sloMs  float64
+	budget time.Duration
+	p99    func() float64 // p99 latency in ms of the latest responses
+	poll   time.Duration  // how often p99 is checked
+	since  time.Time      // when p99 went above sloMs, zero while within
+	worst  float64        // highest p99 since then
+}
+
+// windowP99 is the p99 latency in ms of the moving window on the plot, 0 if
+// it is empty
+func windowP99() float64 {
+	layoutMu.RLock()
+	defer layoutMu.RUnlock()
+
+	tOk, tDegraded, tBad := windowTimings()
+	for i := range tOk {
+		tOk[i] += tDegraded[i] + tBad[i]
+	}
+
+	return percentile(tOk, 0.99)
+}
+
+// observe takes the p99 at now, and is whether it has been above the
+// objective for the whole budget
+func (w *sloWatchdog) observe(now time.Time, p99 float64) bool {
+	if p99 <= w.sloMs {
+		w.since, w.worst = time.Time{}, 0
+		return false
+	}
+
+	if w.since.IsZero() {
+		w.since = now
+	}
+	if p99 > w.worst {
+		w.worst = p99
+	}
+
+	return now.Sub(w.since) >= w.budget
+}
+
+// watch checks the p99 every poll, and is true once the budget is spent,
+// false if quit is closed first
+func (w *sloWatchdog) watch(quit <-chan struct{}) bool {
+	tck := time.NewTicker(w.poll)
+	defer tck.Stop()
+
+	for {
+		select {
+		case now := <-tck.C:
+			if w.observe(now, w.p99()) {
+				return true
+			}
+		case <-quit:
+			return false
+		}
+	}
+}
+
+// breach describes the breach that spent the budget
+func (w *sloWatchdog) breach() string {
+	return fmt.Sprintf("slo-budget: p99 above -slo %s for %s, reaching %s",
+		displayUnit.format(w.sloMs), w.budget, displayUnit.format(w.worst))
+}
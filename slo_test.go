@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_sloWatchdogObserve(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name string
+		p99  []float64 // one a second
+		want int       // index of the first observation that spends the budget, -1 for none
+	}{
+		{"within", []float64{100, 150, 200, 120}, -1},
+		{"sustained", []float64{100, 300, 250, 400, 350}, 3},
+		{"recovers", []float64{300, 400, 150, 300, 400, 100}, -1},
+		{"breached again", []float64{300, 150, 300, 300, 300}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &sloWatchdog{sloMs: 200, budget: 2 * time.Second}
+			got := -1
+			for i, p99 := range tt.p99 {
+				if w.observe(start.Add(time.Duration(i)*time.Second), p99) {
+					got = i
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("observe() spent the budget at %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_sloWatchdogWatch(t *testing.T) {
+	setupTestLayout(t)
+
+	fill := func(ms float64, n int64) {
+		layoutMu.RLock()
+		defer layoutMu.RUnlock()
+
+		ok, _, _ := getTimingsSlot(time.Now())
+		ok[bucketIndex(ms)].Add(n)
+	}
+
+	fill(10, 100)
+	if got := windowP99(); got > 50 {
+		t.Fatalf("windowP99() of fast responses = %.1f, want at most 50", got)
+	}
+
+	quit := make(chan struct{})
+	w := &sloWatchdog{sloMs: 50, budget: 20 * time.Millisecond, p99: windowP99, poll: time.Millisecond}
+	done := make(chan bool, 1)
+	go func() { done <- w.watch(quit) }()
+
+	select {
+	case <-done:
+		t.Fatal("watch() ended while the window was within the objective")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fill(90, 100)
+	select {
+	case spent := <-done:
+		if !spent {
+			t.Error("watch() = false, want the budget spent")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch() didn't end on a window above the objective")
+	}
+	if w.worst <= 50 {
+		t.Errorf("worst p99 = %.1f, want above the objective", w.worst)
+	}
+
+	w = &sloWatchdog{sloMs: 50, budget: time.Minute, p99: windowP99, poll: time.Millisecond}
+	close(quit)
+	if w.watch(quit) {
+		t.Error("watch() after quit = true, want false")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// slowRequests catches requests slower than -warn-slow, nil when unset
+var slowRequests *slowLog
+
+// slowLog keeps the slowest of the requests taking longer than threshold,
+// and writes a line to w, if set, for every one of them
+type slowLog struct {
+	threshold time.Duration
+	keep      int
+
+	mu      sync.Mutex
+	w       io.Writer
+	slowest []result // slowest first, at most keep of them
+	count   int64
+}
+
+func newSlowLog(threshold time.Duration, keep int, w io.Writer) *slowLog {
+	return &slowLog{threshold: threshold, keep: keep, w: w}
+}
+
+// observe records r if it was slow
+func (l *slowLog) observe(r result, now time.Time) {
+	if r.elapsed <= l.threshold {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count++
+	if l.w != nil {
+		fmt.Fprintf(l.w, "%s slow: %s\n", now.Format(time.RFC3339Nano), r)
+	}
+
+	i := sort.Search(len(l.slowest), func(i int) bool { return l.slowest[i].elapsed < r.elapsed })
+	if i >= l.keep {
+		return
+	}
+	if len(l.slowest) < l.keep {
+		l.slowest = append(l.slowest, result{})
+	}
+	copy(l.slowest[i+1:], l.slowest[i:])
+	l.slowest[i] = r
+}
+
+// top gives how many requests were slow, and a copy of the slowest of them
+func (l *slowLog) top() (int64, []result) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.count, append([]result(nil), l.slowest...)
+}
+
+func (l *slowLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.count = 0
+	l.slowest = nil
+}
+
+// SlowRequest is one of the slowest requests in a Summary
+type SlowRequest struct {
+	Method  string  `json:"method"`
+	URL     string  `json:"url"`
+	Status  int     `json:"status"` // 0 if it failed without a response
+	Latency float64 `json:"latency_ms"`
+}
+
+// slowSummaries are the slowest requests caught, if -warn-slow is set
+func slowSummaries() []SlowRequest {
+	if slowRequests == nil {
+		return nil
+	}
+
+	_, slowest := slowRequests.top()
+	summaries := make([]SlowRequest, len(slowest))
+	for i, r := range slowest {
+		summaries[i] = SlowRequest{
+			Method:  r.method,
+			URL:     r.url,
+			Status:  r.status,
+			Latency: float64(r.elapsed) / float64(time.Millisecond),
+		}
+	}
+
+	return summaries
+}
+
+// writeSlowest writes how many requests were slow and the slowest of them
+func writeSlowest(w io.Writer, l *slowLog) {
+	count, slowest := l.top()
+	if count == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "%d requests slower than %v, the slowest:\n", count, l.threshold)
+	for _, r := range slowest {
+		fmt.Fprintf(w, "  %s\n", r)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_slowLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := newSlowLog(100*time.Millisecond, 2, &buf)
+
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, ms := range []int{50, 300, 100, 150, 500, 120} {
+		l.observe(result{method: "GET", url: fmt.Sprintf("http://127.0.0.1/%dms", ms), status: 200, elapsed: time.Duration(ms) * time.Millisecond}, now)
+	}
+
+	count, slowest := l.top()
+	if count != 4 {
+		t.Errorf("top() counted %d slow requests, want 4", count)
+	}
+	if len(slowest) != 2 || slowest[0].elapsed != 500*time.Millisecond || slowest[1].elapsed != 300*time.Millisecond {
+		t.Errorf("top() = %v, want the 500ms and 300ms requests", slowest)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[0] != "2024-01-02T15:04:05Z slow: GET http://127.0.0.1/300ms -> 200 (300.0 ms)" {
+		t.Errorf("observe() logged %q", lines)
+	}
+
+	l.reset()
+	if count, slowest := l.top(); count != 0 || len(slowest) != 0 {
+		t.Errorf("top() after reset() = %d, %v", count, slowest)
+	}
+}
+
+func Test_attackWarnSlow(t *testing.T) {
+	setupTestLayout(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	slowRequests = newSlowLog(50*time.Millisecond, 10, nil)
+	defer func() { slowRequests = nil }()
+
+	trgt := &targeter{requests: []request{{method: "GET", url: srv.URL + "/fast"}, {method: "GET", url: srv.URL + "/slow"}}}
+	client := &http.Client{Transport: newTransport(defaultIdleConnsPerHost, transportConfig{})}
+
+	ch := make(chan time.Time)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		attack(trgt, client, ch, quit)
+	}()
+	for i := 0; i < 4; i++ {
+		ch <- time.Now()
+	}
+	close(quit)
+	<-done
+
+	slowest := slowSummaries()
+	if len(slowest) != 2 {
+		t.Fatalf("slowSummaries() = %v, want the 2 slow requests", slowest)
+	}
+	for _, s := range slowest {
+		if s.URL != srv.URL+"/slow" || s.Status != 200 || s.Latency < 100 {
+			t.Errorf("slowSummaries() has %+v, want only /slow taking at least 100ms", s)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeSlowest(&buf, slowRequests)
+	if !strings.HasPrefix(buf.String(), "2 requests slower than 50ms, the slowest:\n") {
+		t.Errorf("writeSlowest() = %q", buf.String())
+	}
+}
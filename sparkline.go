@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// rateHistorySize bounds the achieved rate samples kept, one per second
+const rateHistorySize = 512
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// rateHistory is a bounded series of per second rate samples
+type rateHistory struct {
+	mu      sync.Mutex
+	samples []int64
+}
+
+func (h *rateHistory) add(sample int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples = append(h.samples, sample)
+	if len(h.samples) > rateHistorySize {
+		h.samples = append(h.samples[:0], h.samples[len(h.samples)-rateHistorySize:]...)
+	}
+}
+
+// recent returns a copy of the last n samples, oldest first
+func (h *rateHistory) recent(n int) []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n > len(h.samples) {
+		n = len(h.samples)
+	}
+
+	return append([]int64(nil), h.samples[len(h.samples)-n:]...)
+}
+
+const sparkLabel = "rate history: "
+
+// sparklineWidth is how many samples fit on a line of width
+func sparklineWidth(width int) int {
+	if n := width - 1 - len(sparkLabel); n > 0 {
+		return n
+	}
+
+	return 0
+}
+
+// renderSparkline writes samples as a line of bars scaled to top, the most
+// recent on the right, padded to width-1 visible characters
+func renderSparkline(w io.Writer, samples []int64, top int64, width int) {
+	n := sparklineWidth(width)
+	if n == 0 {
+		fmt.Fprint(w, strings.Repeat(" ", width-1))
+		return
+	}
+
+	if len(samples) > n {
+		samples = samples[len(samples)-n:]
+	}
+	for _, s := range samples {
+		if s > top {
+			top = s
+		}
+	}
+
+	bars := make([]rune, len(samples))
+	for i, s := range samples {
+		level := 0
+		if top > 0 && s > 0 {
+			level = int(float64(s) / float64(top) * float64(len(sparkBlocks)-1))
+		}
+		bars[i] = sparkBlocks[level]
+	}
+
+	fmt.Fprint(w, sparkLabel, paint(screenPalette.rate, string(bars)), strings.Repeat(" ", n-len(samples)))
+}
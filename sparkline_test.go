@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_renderSparkline(t *testing.T) {
+	defer func(p *palette) { screenPalette = p }(screenPalette)
+	screenPalette = palettes["mono"]
+
+	tests := []struct {
+		name    string
+		samples []int64
+		top     int64
+		width   int
+		want    string
+	}{
+		{"empty", nil, 100, 20, "rate history:      "},
+		{"scaled to desired", []int64{0, 50, 100}, 100, 20, "rate history: ▁▄█  "},
+		{"above desired", []int64{100, 200}, 100, 20, "rate history: ▄█   "},
+		{"dip", []int64{70, 70, 10, 70}, 70, 20, "rate history: ██▂█ "},
+		{"most recent kept", []int64{100, 0, 0, 0, 0, 0, 0, 0, 100}, 100, 20, "rate history: ▁▁▁▁█"},
+		{"no desired rate", []int64{0, 0}, 0, 20, "rate history: ▁▁   "},
+		{"too narrow", []int64{1, 2, 3}, 3, 10, "         "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			renderSparkline(&buf, tt.samples, tt.top, tt.width)
+
+			got := buf.String()
+			if got != tt.want {
+				t.Errorf("renderSparkline() = %q, want %q", got, tt.want)
+			}
+			if n := utf8.RuneCountInString(got); n != tt.width-1 {
+				t.Errorf("renderSparkline() is %d wide, want %d", n, tt.width-1)
+			}
+		})
+	}
+}
+
+func Test_rateHistory(t *testing.T) {
+	var h rateHistory
+	for i := int64(0); i < rateHistorySize+10; i++ {
+		h.add(i)
+	}
+
+	all := h.recent(rateHistorySize * 2)
+	if len(all) != rateHistorySize {
+		t.Fatalf("rateHistory kept %d samples, want %d", len(all), rateHistorySize)
+	}
+	if all[0] != 10 || all[len(all)-1] != rateHistorySize+9 {
+		t.Errorf("rateHistory kept %d..%d, want the most recent 10..%d", all[0], all[len(all)-1], rateHistorySize+9)
+	}
+
+	if got := h.recent(3); len(got) != 3 || got[2] != rateHistorySize+9 {
+		t.Errorf("recent(3) = %v, want the last 3 samples", got)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Summary is an overview of the run so far
+type Summary struct {
+	Time      time.Time `json:"time"`
+	Elapsed   float64   `json:"elapsed_seconds"`
+	Sent      int64     `json:"sent"`
+	Received  int64     `json:"received"`
+	OK        int64     `json:"ok"`
+	Errors    int64     `json:"errors"`
+	Invalid   int64     `json:"invalid"`               // failed validation, included in Errors
+	BadHeader int64     `json:"bad_headers,omitempty"` // without an -expect-header, included in Errors
+	Canceled  int64     `json:"canceled,omitempty"`    // at -max-duration-per-request, sent but not received
+	ErrorRate float64   `json:"error_rate"`
+	RPS       float64   `json:"rps"`
+	P50       float64   `json:"p50_ms"`
+	P90       float64   `json:"p90_ms"`
+	P99       float64   `json:"p99_ms"`
+
+	Endpoints []EndpointSummary `json:"endpoints,omitempty"`
+	Slowest   []SlowRequest     `json:"slowest,omitempty"` // with -warn-slow
+
+	// limit that ended the run, duration or requests, if one did
+	Ended string `json:"ended,omitempty"`
+
+	Labels labelFlags `json:"labels,omitempty"` // from -label
+}
+
+// buildSummary takes a snapshot of the counters
+func buildSummary(now time.Time) Summary {
+	s := Summary{
+		Time:      now,
+		Elapsed:   now.Sub(time.Unix(0, runStart.Load())).Seconds(),
+		Sent:      requestsSent.Load(),
+		Received:  responsesReceived.Load(),
+		OK:        responsesOk.Load(),
+		Invalid:   validationFailed.Load(),
+		BadHeader: headerMismatches.Load(),
+		Canceled:  requestsCanceled.Load(),
+		Ended:     endReason(),
+		Slowest:   slowSummaries(),
+		Labels:    runLabels,
+	}
+	s.Errors = s.Received - s.OK
+
+	if s.Received > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Received)
+	}
+
+	if s.Elapsed > 0 {
+		s.RPS = float64(s.Sent) / s.Elapsed
+	}
+
+	counts := make([]int64, len(timingsTotal))
+	for i := range timingsTotal {
+		counts[i] = timingsTotal[i].Load()
+	}
+	s.P50 = totalPercentile(counts, 0.50)
+	s.P90 = totalPercentile(counts, 0.90)
+	s.P99 = totalPercentile(counts, 0.99)
+	s.Endpoints = endpointSummaries()
+
+	return s
+}
+
+// String formats the summary as a single line
+func (s Summary) String() string {
+	return fmt.Sprintf("%s elapsed=%.0fs sent=%d recv=%d ok=%d err=%d err_rate=%.2f%% rps=%.1f p50=%s p90=%s p99=%s",
+		s.Time.Format(time.RFC3339), s.Elapsed, s.Sent, s.Received, s.OK, s.Errors, s.ErrorRate*100, s.RPS,
+		displayUnit.format(s.P50), displayUnit.format(s.P90), displayUnit.format(s.P99)) + s.labelsField() + s.endedField()
+}
+
+// labelsField is the labels part of String, empty without any
+func (s Summary) labelsField() string {
+	if len(s.Labels) == 0 {
+		return ""
+	}
+
+	return " " + s.Labels.String()
+}
+
+// endedField is the " ended=" part of String, empty while the run goes on
+func (s Summary) endedField() string {
+	if s.Ended == "" {
+		return ""
+	}
+
+	return " ended=" + s.Ended
+}
+
+// bucketUpperMs is the latency in ms at which bucket bkt ends. The last bucket is
+// open ended and reported as maxY. Must be called with layoutMu held.
+func bucketUpperMs(bkt uint) float64 {
+	if bkt >= buckets-1 {
+		return maxY
+	}
+
+	_, upper := bucketBoundsMs(bkt)
+	return upper
+}
+
+// percentile estimates the q-th quantile in ms from counts per latency
+// bucket, as the upper bound of the bucket it falls in. Must be called with
+// layoutMu held.
+func percentile(counts []int64, q float64) float64 {
+	return percentileOf(counts, q, bucketUpperMs)
+}
+
+// totalBuckets is the number of latency buckets of timingsTotal and the
+// endpoints. Unlike the plot's they don't depend on the terminal size, so
+// a resize leaves the totals as they are.
+const totalBuckets = 256
+
+// totalBase is what the totalBuckets bounds grow by, spanning -minY to -maxY
+func totalBase() float64 {
+	return math.Pow(maxY-minY, 1/float64(totalBuckets-2))
+}
+
+// totalIndex is the bucket of timingsTotal of a request taking elapsedMs
+func totalIndex(elapsedMs float64) int {
+	return logIndex(totalBase(), totalBuckets, elapsedMs)
+}
+
+// totalUpperMs is the latency in ms at which bucket bkt of timingsTotal
+// ends, maxY for the last one like bucketUpperMs
+func totalUpperMs(bkt uint) float64 {
+	if bkt >= totalBuckets-1 {
+		return maxY
+	}
+
+	_, upper := logBoundsMs(totalBase(), totalBuckets, bkt)
+	return upper
+}
+
+// totalPercentile is percentile for counts per bucket of timingsTotal
+func totalPercentile(counts []int64, q float64) float64 {
+	return percentileOf(counts, q, totalUpperMs)
+}
+
+// percentileOf estimates the q-th quantile as the upperMs of the bucket of
+// counts it falls in
+func percentileOf(counts []int64, q float64, upperMs func(bkt uint) float64) float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	rank := int64(math.Ceil(q * float64(total)))
+	var seen int64
+	for bkt, c := range counts {
+		seen += c
+		if seen >= rank {
+			return upperMs(uint(bkt))
+		}
+	}
+
+	return maxY
+}
+
+// snapshotter writes a summary line to w every interval until quit is closed
+func snapshotter(w io.Writer, interval time.Duration, quit <-chan struct{}) {
+	tck := time.NewTicker(interval)
+	defer tck.Stop()
+
+	for {
+		select {
+		case now := <-tck.C:
+			fmt.Fprintln(w, buildSummary(now))
+		case <-quit:
+			return
+		}
+	}
+}
+
+var csvReportHeader = []string{"timestamp", "p50_ms", "p90_ms", "p99_ms", "rps", "error_rate"}
+
+// csvHeader is the -report-csv header row, with a column per label
+func csvHeader(labels labelFlags) []string {
+	return append(append([]string{}, csvReportHeader...), labels.keys()...)
+}
+
+// totalsSnapshot are the counters at a point in time, for summaries of the
+// interval between two of them
+type totalsSnapshot struct {
+	time               time.Time
+	sent, received, ok int64
+	counts             []int64 // per bucket of timingsTotal
+}
+
+func takeTotals(now time.Time) totalsSnapshot {
+	t := totalsSnapshot{
+		time:     now,
+		sent:     requestsSent.Load(),
+		received: responsesReceived.Load(),
+		ok:       responsesOk.Load(),
+		counts:   make([]int64, len(timingsTotal)),
+	}
+	for i := range timingsTotal {
+		t.counts[i] = timingsTotal[i].Load()
+	}
+
+	return t
+}
+
+// since summarizes the interval from prev: the rate of requests sent in it,
+// and the error rate and percentiles of the responses received in it.
+// Counters reset in between count from zero.
+func (t totalsSnapshot) since(prev totalsSnapshot) Summary {
+	if t.sent < prev.sent || t.received < prev.received {
+		prev = totalsSnapshot{time: prev.time}
+	}
+
+	counts := make([]int64, len(t.counts))
+	for i, c := range t.counts {
+		if i < len(prev.counts) {
+			c -= prev.counts[i]
+		}
+		counts[i] = max(c, 0)
+	}
+
+	s := Summary{
+		Time:     t.time,
+		Elapsed:  t.time.Sub(prev.time).Seconds(),
+		Sent:     t.sent - prev.sent,
+		Received: t.received - prev.received,
+		OK:       t.ok - prev.ok,
+		P50:      totalPercentile(counts, 0.50),
+		P90:      totalPercentile(counts, 0.90),
+		P99:      totalPercentile(counts, 0.99),
+	}
+	s.Errors = s.Received - s.OK
+	if s.Elapsed > 0 {
+		s.RPS = float64(s.Sent) / s.Elapsed
+	}
+	if s.Received > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Received)
+	}
+
+	return s
+}
+
+// csvRow is the summary as a -report-csv row
+func (s Summary) csvRow() []string {
+	row := []string{
+		s.Time.Format(time.RFC3339),
+		strconv.FormatFloat(s.P50, 'f', 1, 64),
+		strconv.FormatFloat(s.P90, 'f', 1, 64),
+		strconv.FormatFloat(s.P99, 'f', 1, 64),
+		strconv.FormatFloat(s.RPS, 'f', 1, 64),
+		strconv.FormatFloat(s.ErrorRate, 'f', 4, 64),
+	}
+	for _, key := range s.Labels.keys() {
+		row = append(row, s.Labels[key])
+	}
+
+	return row
+}
+
+// csvReporter appends a CSV row of the last interval to w every interval
+// until quit is closed, after a header row if header is set
+func csvReporter(w io.Writer, header bool, interval time.Duration, quit <-chan struct{}) {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(csvHeader(runLabels))
+		cw.Flush()
+	}
+
+	tck := time.NewTicker(interval)
+	defer tck.Stop()
+
+	prev := takeTotals(time.Now())
+	for {
+		select {
+		case now := <-tck.C:
+			cur := takeTotals(now)
+			s := cur.since(prev)
+			s.Labels = runLabels
+			cw.Write(s.csvRow())
+			cw.Flush()
+			prev = cur
+		case <-quit:
+			return
+		}
+	}
+}
+
+// summaryEncoder writes the summary printed on exit, in a -report-format
+type summaryEncoder interface {
+	encode(w io.Writer, s Summary) error
+}
+
+var summaryEncoders = map[string]summaryEncoder{
+	"text": textSummary{},
+	"json": jsonSummary{},
+	"csv":  csvSummary{},
+}
+
+func lookupSummaryEncoder(format string) (summaryEncoder, error) {
+	enc, ok := summaryEncoders[format]
+	if !ok {
+		return nil, fmt.Errorf("invalid -report-format %q, must be one of text, json or csv", format)
+	}
+
+	return enc, nil
+}
+
+// textSummary is the summary as the single line snapshots are
+type textSummary struct{}
+
+func (textSummary) encode(w io.Writer, s Summary) error {
+	_, err := fmt.Fprintln(w, s)
+	return err
+}
+
+// jsonSummary is the summary as a JSON object on a line of its own, the same
+// as -save-summary writes
+type jsonSummary struct{}
+
+func (jsonSummary) encode(w io.Writer, s Summary) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+// csvSummary is the summary as a header and a row, with a column per total
+// and then per label. Endpoints and the slowest requests are left out.
+type csvSummary struct{}
+
+var csvSummaryHeader = []string{"timestamp", "elapsed_seconds", "sent", "received", "ok", "errors", "invalid", "canceled", "error_rate", "rps", "p50_ms", "p90_ms", "p99_ms", "ended"}
+
+func (csvSummary) encode(w io.Writer, s Summary) error {
+	row := []string{
+		s.Time.Format(time.RFC3339),
+		strconv.FormatFloat(s.Elapsed, 'f', 1, 64),
+		strconv.FormatInt(s.Sent, 10),
+		strconv.FormatInt(s.Received, 10),
+		strconv.FormatInt(s.OK, 10),
+		strconv.FormatInt(s.Errors, 10),
+		strconv.FormatInt(s.Invalid, 10),
+		strconv.FormatInt(s.Canceled, 10),
+		strconv.FormatFloat(s.ErrorRate, 'f', 4, 64),
+		strconv.FormatFloat(s.RPS, 'f', 1, 64),
+		strconv.FormatFloat(s.P50, 'f', 1, 64),
+		strconv.FormatFloat(s.P90, 'f', 1, 64),
+		strconv.FormatFloat(s.P99, 'f', 1, 64),
+		s.Ended,
+	}
+	for _, key := range s.Labels.keys() {
+		row = append(row, s.Labels[key])
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(append(append([]string{}, csvSummaryHeader...), s.Labels.keys()...))
+	cw.Write(row)
+	cw.Flush()
+
+	return cw.Error()
+}
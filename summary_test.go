@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe to write from one goroutine while another reads
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func setupTestLayout(t *testing.T) {
+	minY, maxY = 0, 100
+	l, err := computeLayout(80, 24, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyLayout(l)
+	resetStats()
+}
+
+func Test_percentile(t *testing.T) {
+	setupTestLayout(t)
+
+	counts := make([]int64, buckets)
+	counts[0] = 50
+	counts[5] = 40
+	counts[10] = 9
+	counts[buckets-1] = 1
+
+	tests := []struct {
+		q    float64
+		want float64
+	}{
+		{0.50, bucketUpperMs(0)},
+		{0.51, bucketUpperMs(5)},
+		{0.90, bucketUpperMs(5)},
+		{0.99, bucketUpperMs(10)},
+		{1, maxY},
+	}
+	for _, tt := range tests {
+		if got := percentile(counts, tt.q); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	if got := percentile(make([]int64, buckets), 0.5); got != 0 {
+		t.Errorf("percentile() without responses = %v, want 0", got)
+	}
+}
+
+func Test_buildSummary(t *testing.T) {
+	setupTestLayout(t)
+	defer resetStats()
+
+	start := time.Now()
+	runStart.Store(start.UnixNano())
+	requestsSent.Store(100)
+	responsesReceived.Store(100)
+	responsesOk.Store(90)
+	validationFailed.Store(2)
+	timingsTotal[3].Store(100)
+
+	s := buildSummary(start.Add(10 * time.Second))
+	if s.Sent != 100 || s.Received != 100 || s.OK != 90 || s.Errors != 10 || s.Invalid != 2 {
+		t.Errorf("buildSummary() totals = %+v", s)
+	}
+	if s.ErrorRate != 0.1 || s.RPS != 10 || s.Elapsed != 10 {
+		t.Errorf("buildSummary() rates = %+v", s)
+	}
+	if want := totalUpperMs(3); s.P50 != want || s.P99 != want {
+		t.Errorf("buildSummary() percentiles = %v/%v, want %v", s.P50, s.P99, want)
+	}
+}
+
+func Test_resizeKeepsTotals(t *testing.T) {
+	setupTestLayout(t)
+	defer setupTestLayout(t)
+
+	now := time.Now()
+	for ms := 1; ms <= 100; ms++ {
+		recordTiming(now, time.Duration(ms)*time.Millisecond, true)
+		recordEndpoint("GET /", time.Duration(ms)*time.Millisecond)
+	}
+	before := buildSummary(now)
+
+	for _, height := range []uint{60, 12} {
+		l, err := computeLayout(80, height, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyLayout(l)
+
+		got := buildSummary(now)
+		if got.P50 != before.P50 || got.P90 != before.P90 || got.P99 != before.P99 {
+			t.Errorf("percentiles after resizing to %d lines = %.2f/%.2f/%.2f, want %.2f/%.2f/%.2f",
+				height, got.P50, got.P90, got.P99, before.P50, before.P90, before.P99)
+		}
+		if !reflect.DeepEqual(got.Endpoints, before.Endpoints) {
+			t.Errorf("endpoints after resizing to %d lines = %+v, want %+v", height, got.Endpoints, before.Endpoints)
+		}
+	}
+}
+
+func Test_snapshotter(t *testing.T) {
+	setupTestLayout(t)
+
+	const interval = 20 * time.Millisecond
+
+	var out lockedBuffer
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		snapshotter(&out, interval, quit)
+		close(done)
+	}()
+
+	time.Sleep(5*interval + interval/2)
+	close(quit)
+	<-done
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 3 || len(lines) > 5 {
+		t.Fatalf("snapshotter() wrote %d lines in 5 intervals, want about 5", len(lines))
+	}
+
+	var last time.Time
+	for _, line := range lines {
+		if !strings.Contains(line, " sent=") || !strings.Contains(line, " p99=") {
+			t.Errorf("snapshotter() line %q is not a summary", line)
+		}
+
+		ts, err := time.Parse(time.RFC3339, strings.Fields(line)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ts.Before(last) {
+			t.Errorf("snapshotter() timestamps out of order")
+		}
+		last = ts
+	}
+}
+
+func Test_csvReporter(t *testing.T) {
+	setupTestLayout(t)
+
+	const interval = 20 * time.Millisecond
+
+	var out lockedBuffer
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		csvReporter(&out, true, interval, quit)
+		close(done)
+	}()
+
+	time.Sleep(5*interval + interval/2)
+	close(quit)
+	<-done
+	elapsed := time.Since(start)
+
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(rows[0], csvReportHeader) {
+		t.Errorf("csvReporter() header = %v, want %v", rows[0], csvReportHeader)
+	}
+
+	rows = rows[1:]
+	if want := int(elapsed / interval); len(rows) < want-2 || len(rows) > want {
+		t.Fatalf("csvReporter() wrote %d rows in %v, want one every %v", len(rows), elapsed, interval)
+	}
+	for _, row := range rows {
+		if len(row) != len(csvReportHeader) {
+			t.Errorf("csvReporter() row %v has %d columns, want %d", row, len(row), len(csvReportHeader))
+		}
+		if _, err := time.Parse(time.RFC3339, row[0]); err != nil {
+			t.Errorf("csvReporter() row timestamp: %s", err)
+		}
+	}
+
+	// appending to an existing report leaves out the header
+	var more lockedBuffer
+	quit = make(chan struct{})
+	go func() {
+		time.Sleep(interval + interval/2)
+		close(quit)
+	}()
+	csvReporter(&more, false, interval, quit)
+	if strings.Contains(more.String(), "timestamp") {
+		t.Errorf("csvReporter() without header wrote %q", more.String())
+	}
+}
+
+func Test_totalsSnapshotSince(t *testing.T) {
+	setupTestLayout(t)
+
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	counts := func(fastMs, slowMs float64, fast, slow int64) []int64 {
+		c := make([]int64, totalBuckets)
+		c[totalIndex(fastMs)] += fast
+		c[totalIndex(slowMs)] += slow
+		return c
+	}
+	first := totalsSnapshot{time: start, sent: 100, received: 100, ok: 100, counts: counts(10, 50, 100, 0)}
+
+	tests := []struct {
+		name string
+		cur  totalsSnapshot
+		want []string
+	}{
+		{
+			"interval only",
+			totalsSnapshot{time: start.Add(10 * time.Second), sent: 300, received: 300, ok: 250, counts: counts(10, 50, 200, 100)},
+			[]string{"2026-10-14T12:00:10Z", fmtMs(totalUpperMs(uint(totalIndex(10)))), fmtMs(totalUpperMs(uint(totalIndex(50)))), fmtMs(totalUpperMs(uint(totalIndex(50)))), "20.0", "0.2500"},
+		},
+		{
+			"nothing new",
+			totalsSnapshot{time: start.Add(10 * time.Second), sent: 100, received: 100, ok: 100, counts: counts(10, 50, 100, 0)},
+			[]string{"2026-10-14T12:00:10Z", "0.0", "0.0", "0.0", "0.0", "0.0000"},
+		},
+		{
+			"reset in between",
+			totalsSnapshot{time: start.Add(10 * time.Second), sent: 50, received: 50, ok: 50, counts: counts(10, 50, 0, 50)},
+			[]string{"2026-10-14T12:00:10Z", fmtMs(totalUpperMs(uint(totalIndex(50)))), fmtMs(totalUpperMs(uint(totalIndex(50)))), fmtMs(totalUpperMs(uint(totalIndex(50)))), "5.0", "0.0000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cur.since(first).csvRow(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("since().csvRow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fmtMs formats ms as csvRow does
+func fmtMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 1, 64)
+}
+
+func Test_summaryEncoders(t *testing.T) {
+	s := Summary{
+		Time:      time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+		Elapsed:   60,
+		Sent:      600,
+		Received:  598,
+		OK:        590,
+		Errors:    8,
+		Invalid:   3,
+		ErrorRate: 8.0 / 598,
+		RPS:       10,
+		P50:       12,
+		P90:       40,
+		P99:       110,
+		Endpoints: []EndpointSummary{{Endpoint: "GET http://a/"}},
+		Ended:     "duration",
+		Labels:    labelFlags{"env": "staging"},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", s.String() + "\n"},
+		{"csv", "timestamp,elapsed_seconds,sent,received,ok,errors,invalid,canceled,error_rate,rps,p50_ms,p90_ms,p99_ms,ended,env\n" +
+			"2026-10-14T12:00:00Z,60.0,600,598,590,8,3,0,0.0134,10.0,12.0,40.0,110.0,duration,staging\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			enc, err := lookupSummaryEncoder(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err := enc.encode(&buf, s); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("encode() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("json", func(t *testing.T) {
+		enc, err := lookupSummaryEncoder("json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := enc.encode(&buf, s); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Count(buf.String(), "\n") != 1 {
+			t.Errorf("encode() = %q, want a single line", buf.String())
+		}
+
+		var got Summary
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, s) {
+			t.Errorf("encode() decodes to %+v, want %+v", got, s)
+		}
+	})
+
+	if _, err := lookupSummaryEncoder("xml"); err == nil {
+		t.Error("lookupSummaryEncoder(xml), want an error")
+	}
+}
@@ -3,7 +3,17 @@ package main
 import (
     "bytes"
 	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -216,3 +226,486 @@ Got		%+v"`, test.expected[req].body, trgt.requests[req].body)
 		t.Logf("Failed %d/%d tests\n", failed, len(tests))
 	}
 }
+
+func TestNextRequestSeq(t *testing.T) {
+	trgt := targeter{
+		requests: []request{
+			request{
+				method: "POST",
+				url:    "http://127.0.0.1:5000/test/{{seq}}",
+				body:   []byte(`{"id": {{seq}}}`),
+			},
+		},
+	}
+	trgt.seq.Store(41)
+
+	for _, want := range []string{"41", "42"} {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if url := req.URL.String(); url != "http://127.0.0.1:5000/test/"+want {
+			t.Errorf("Expected URL ending in '%s', got '%s'", want, url)
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != `{"id": `+want+`}` {
+			t.Errorf("Expected body with id %s, got '%s'", want, body)
+		}
+	}
+}
+
+func TestNextRequestSeqConcurrent(t *testing.T) {
+	const workers, calls = 8, 100
+
+	trgt := targeter{
+		requests: []request{
+			request{
+				method: "GET",
+				url:    "http://127.0.0.1:5000/{{seq}}",
+			},
+		},
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < calls; i++ {
+				req, err := trgt.nextRequest()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				mu.Lock()
+				seen[req.URL.Path] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != workers*calls {
+		t.Errorf("Expected %d distinct sequence values, got %d", workers*calls, len(seen))
+	}
+	if !seen["/0"] || !seen[fmt.Sprintf("/%d", workers*calls-1)] {
+		t.Errorf("Expected sequence values 0 to %d", workers*calls-1)
+	}
+}
+
+func TestNextRequestChunked(t *testing.T) {
+	type received struct {
+		transferEncoding []string
+		contentLength    int64
+		body             string
+	}
+	got := make(chan received, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got <- received{r.TransferEncoding, r.ContentLength, string(body)}
+	}))
+	defer srv.Close()
+
+	for _, chunked := range []bool{false, true} {
+		trgt := targeter{
+			requests: []request{
+				request{
+					method: "POST",
+					url:    srv.URL,
+					body:   []byte(`{"foo": "bar"}`),
+				},
+			},
+			chunked: chunked,
+		}
+
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		r := <-got
+		if r.body != `{"foo": "bar"}` {
+			t.Errorf("Expected body to arrive intact, got '%s'", r.body)
+		}
+
+		isChunked := len(r.transferEncoding) == 1 && r.transferEncoding[0] == "chunked"
+		if chunked && (!isChunked || r.contentLength != -1) {
+			t.Errorf("Expected chunked request, got Transfer-Encoding %v and Content-Length %d", r.transferEncoding, r.contentLength)
+		}
+		if !chunked && (isChunked || r.contentLength != 14) {
+			t.Errorf("Expected Content-Length 14, got Transfer-Encoding %v and Content-Length %d", r.transferEncoding, r.contentLength)
+		}
+	}
+}
+
+func TestNewTargeterURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/targets.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "GET http://127.0.0.1:5000/a\n\nPOST http://127.0.0.1:5000/b\n$ hello\n")
+	}))
+	defer srv.Close()
+
+	trgt, err := newTargeter(srv.URL+"/targets.txt", "text", false, false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trgt.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(trgt.requests))
+	}
+	if trgt.requests[1].method != "POST" || string(trgt.requests[1].body) != "hello" {
+		t.Errorf("Expected POST with body 'hello', got %s with '%s'", trgt.requests[1].method, trgt.requests[1].body)
+	}
+
+	_, err = newTargeter(srv.URL+"/missing.txt", "text", false, false, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a 404 error for a missing targets file, got %v", err)
+	}
+}
+
+func TestNextRequestIdempotencyKey(t *testing.T) {
+	trgt := targeter{
+		requests: []request{
+			request{method: "POST", url: "http://127.0.0.1:5000/a"},
+			request{method: "GET", url: "http://127.0.0.1:5000/b"},
+			request{method: "PUT", url: "http://127.0.0.1:5000/c"},
+		},
+		idempotencyKey: "Idempotency-Key",
+	}
+	trgt.idx.Store(-1)
+
+	seen := map[string]bool{}
+	for i := 0; i < 30; i++ {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		key := req.Header.Get("Idempotency-Key")
+		if req.Method == "GET" {
+			if key != "" {
+				t.Errorf("Expected no idempotency key on GET, got '%s'", key)
+			}
+			continue
+		}
+
+		if len(key) != 36 {
+			t.Errorf("Expected a UUID idempotency key on %s, got '%s'", req.Method, key)
+		}
+		if seen[key] {
+			t.Errorf("Idempotency key '%s' used twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestNextRequestRepeat(t *testing.T) {
+	// built the way main builds it
+	trgt := targeter{}
+	err := trgt.readTargets(strings.NewReader("GET http://127.0.0.1:5000/a\n\nGET http://127.0.0.1:5000/b\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trgt.repeat = 3
+
+	expected := []string{"/a", "/a", "/a", "/b", "/b", "/b", "/a", "/a"}
+	for i, path := range expected {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if req.URL.Path != path {
+			t.Errorf("Call %d: expected '%s', got '%s'", i, path, req.URL.Path)
+		}
+	}
+}
+
+func TestNewTargeterTags(t *testing.T) {
+	f, err := ioutil.TempFile("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	fmt.Fprint(f, `# endpoints for the read and write scenarios
+#tag=read
+GET http://127.0.0.1:5000/a
+
+#tag=write
+POST http://127.0.0.1:5000/b
+$ hello
+#tag=read, write
+#tag=slow
+PUT http://127.0.0.1:5000/c
+
+GET http://127.0.0.1:5000/d
+`)
+	f.Close()
+
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+		err  bool
+	}{
+		{name: "no tags keeps all", want: []string{"/a", "/b", "/c", "/d"}},
+		{name: "one tag", tags: []string{"read"}, want: []string{"/a", "/c"}},
+		{name: "any of several tags", tags: []string{"write", "slow"}, want: []string{"/b", "/c"}},
+		{name: "no match", tags: []string{"admin"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trgt, err := newTargeter(f.Name(), "text", false, false, tt.tags, nil)
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error, got %d requests", len(trgt.requests))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, req := range trgt.requests {
+				got = append(got, strings.TrimPrefix(req.url, "http://127.0.0.1:5000"))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	trgt, err := newTargeter(f.Name(), "text", false, false, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(trgt.requests[2].tags, " "); got != "read write slow" {
+		t.Errorf("Expected tags 'read write slow' on the third target, got '%s'", got)
+	}
+	if string(trgt.requests[1].body) != "hello" {
+		t.Errorf("Expected body 'hello' before a #tag= line, got '%s'", trgt.requests[1].body)
+	}
+}
+
+func TestReadTargetsEnv(t *testing.T) {
+	t.Setenv("SLAPPER_BASE_URL", "http://127.0.0.1:5000")
+	t.Setenv("SLAPPER_NAME", "spam")
+
+	trgt := targeter{}
+	err := trgt.readTargets(strings.NewReader("POST ${SLAPPER_BASE_URL}/items\n$ {\"name\": \"${SLAPPER_NAME}\"}\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(trgt.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(trgt.requests))
+	}
+	if trgt.requests[0].url != "http://127.0.0.1:5000/items" {
+		t.Errorf("Expected url 'http://127.0.0.1:5000/items', got '%s'", trgt.requests[0].url)
+	}
+	if string(trgt.requests[0].body) != `{"name": "spam"}` {
+		t.Errorf(`Expected body '{"name": "spam"}', got '%s'`, trgt.requests[0].body)
+	}
+
+	err = trgt.readTargets(strings.NewReader("GET ${SLAPPER_UNSET}/items\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "SLAPPER_UNSET") {
+		t.Errorf("Expected an error naming SLAPPER_UNSET, got %v", err)
+	}
+}
+
+func TestReadTargetsMixedBodies(t *testing.T) {
+	input := `POST http://127.0.0.1:5000/json
+$ {"name": "spam"}
+POST http://127.0.0.1:5000/binary
+$64 AAEC/w==
+POST http://127.0.0.1:5000/encoded
+$ eyJhIjoxfQ==
+GET http://127.0.0.1:5000/none
+`
+	tests := []struct {
+		name       string
+		base64body bool
+		want       []string
+	}{
+		{"plain by default", false, []string{`{"name": "spam"}`, "\x00\x01\x02\xff", "eyJhIjoxfQ==", ""}},
+		{"base64 by default", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trgt := targeter{}
+			err := trgt.readTargets(strings.NewReader(input), tt.base64body)
+			if tt.want == nil {
+				// the json body isn't base64
+				if err == nil {
+					t.Error("Expected an error for a plain body with -base64body")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(trgt.requests) != len(tt.want) {
+				t.Fatalf("Expected %d requests, got %d", len(tt.want), len(trgt.requests))
+			}
+			for i, want := range tt.want {
+				if string(trgt.requests[i].body) != want {
+					t.Errorf("Expected body %q for %s, got %q", want, trgt.requests[i].url, trgt.requests[i].body)
+				}
+			}
+		})
+	}
+
+	trgt := targeter{}
+	if err := trgt.readTargets(strings.NewReader("POST http://127.0.0.1:5000/\n$64 {not base64}\n"), false); err == nil {
+		t.Error("Expected an error for a $64 body that isn't base64")
+	}
+
+	trgt = targeter{}
+	if err := trgt.readTargets(strings.NewReader("POST http://127.0.0.1:5000/a\n$ ZW5jb2RlZA==\nPOST http://127.0.0.1:5000/b\n$64 YmluYXJ5\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	if string(trgt.requests[0].body) != "encoded" || string(trgt.requests[1].body) != "binary" {
+		t.Errorf("Expected bodies 'encoded' and 'binary' with -base64body, got %q and %q", trgt.requests[0].body, trgt.requests[1].body)
+	}
+}
+
+func TestNewTargeterHosts(t *testing.T) {
+	dir := t.TempDir()
+	hostsFile := filepath.Join(dir, "hosts.txt")
+	targetsFile := filepath.Join(dir, "targets.txt")
+	os.WriteFile(hostsFile, []byte("# the fleet\nhttp://10.0.0.1:8080\n10.0.0.2:8080\n\nhttps://10.0.0.3/\n"), 0644)
+	os.WriteFile(targetsFile, []byte("GET /items/[1-2]\nPOST /items\n$ {\"name\": \"spam\"}\n"), 0644)
+
+	hosts, err := readHosts(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHosts := []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080", "https://10.0.0.3"}
+	if !reflect.DeepEqual(hosts, wantHosts) {
+		t.Fatalf("Expected hosts %v, got %v", wantHosts, hosts)
+	}
+
+	trgt, err := newTargeter(targetsFile, "text", false, false, nil, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 hosts times 3 paths, the hosts of each path after each other
+	if len(trgt.requests) != 9 {
+		t.Fatalf("Expected 9 requests, got %d", len(trgt.requests))
+	}
+	want := []string{
+		"GET http://10.0.0.1:8080/items/1", "GET http://10.0.0.2:8080/items/1", "GET https://10.0.0.3/items/1",
+		"GET http://10.0.0.1:8080/items/2", "GET http://10.0.0.2:8080/items/2", "GET https://10.0.0.3/items/2",
+		"POST http://10.0.0.1:8080/items", "POST http://10.0.0.2:8080/items", "POST https://10.0.0.3/items",
+	}
+	for i, req := range trgt.requests {
+		if got := req.method + " " + req.url; got != want[i] {
+			t.Errorf("Expected request %d to be %s, got %s", i, want[i], got)
+		}
+	}
+	if trgt.requests[6].endpoint != "POST http://10.0.0.1:8080/items" || string(trgt.requests[8].body) != `{"name": "spam"}` {
+		t.Errorf("Unexpected endpoint %q or body %q", trgt.requests[6].endpoint, trgt.requests[8].body)
+	}
+	if trgt.requests[0].endpoint != "GET http://10.0.0.1:8080/items/[1-2]" {
+		t.Errorf("Expected the range in the endpoint, got %q", trgt.requests[0].endpoint)
+	}
+
+	os.WriteFile(targetsFile, []byte("GET http://example.com/items\n"), 0644)
+	if _, err := newTargeter(targetsFile, "text", false, false, nil, hosts); err == nil {
+		t.Error("Expected an error for a full url with -hosts")
+	}
+}
+
+func TestNewTargeterSorted(t *testing.T) {
+	targets := []string{
+		"POST http://a/items\n$ first\n",
+		"GET http://b/[1-3]\n",
+		"GET http://a/z\n",
+		"POST http://a/items\n$ second\n",
+		"DELETE http://a/items/1\n",
+	}
+	want := []string{
+		"DELETE http://a/items/1", "GET http://a/z", "GET http://b/1", "GET http://b/2", "GET http://b/3",
+		"POST http://a/items first", "POST http://a/items second",
+	}
+
+	// the same targets in other orders, keeping the order of the two POSTs
+	orders := [][]int{{0, 1, 2, 3, 4}, {4, 2, 0, 1, 3}, {1, 0, 3, 4, 2}}
+	for _, order := range orders {
+		var file strings.Builder
+		for _, i := range order {
+			file.WriteString(targets[i])
+		}
+		f := filepath.Join(t.TempDir(), "targets.txt")
+		os.WriteFile(f, []byte(file.String()), 0644)
+
+		trgt, err := newTargeter(f, "text", false, true, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, req := range trgt.requests {
+			got = append(got, strings.TrimSpace(req.method+" "+req.url+" "+string(req.body)))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected targets in order %v to be sorted as %v, got %v", order, want, got)
+		}
+	}
+}
+
+func Test_shuffleRequests(t *testing.T) {
+	var requests []request
+	for i := 0; i < 50; i++ {
+		requests = append(requests, request{method: "GET", url: fmt.Sprintf("http://a/%d", i)})
+	}
+	urls := func(requests []request) []string {
+		var urls []string
+		for _, req := range requests {
+			urls = append(urls, req.url)
+		}
+		return urls
+	}
+	ordered := urls(requests)
+
+	shuffled := append([]request(nil), requests...)
+	shuffleRequests(shuffled, rand.New(rand.NewSource(7)))
+	got := urls(shuffled)
+	if reflect.DeepEqual(got, ordered) {
+		t.Error("shuffleRequests() kept the order")
+	}
+
+	sorted := append([]string(nil), got...)
+	sort.Strings(sorted)
+	want := append([]string(nil), ordered...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(sorted, want) {
+		t.Errorf("shuffleRequests() = %v, want the same targets as %v", got, ordered)
+	}
+
+	again := append([]request(nil), requests...)
+	shuffleRequests(again, rand.New(rand.NewSource(7)))
+	if !reflect.DeepEqual(urls(again), got) {
+		t.Error("shuffleRequests() with the same seed gave another order")
+	}
+}
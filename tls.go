@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// negotiatedTLS counts the TLS connections opened by negotiated version, 1.0
+// through 1.3
+var negotiatedTLS [4]counter
+
+func countTLSVersion(version uint16) {
+	if idx := int(version) - tls.VersionTLS10; idx >= 0 && idx < len(negotiatedTLS) {
+		negotiatedTLS[idx].Add(1)
+	}
+}
+
+// newTLSConfig builds the client TLS config from the -tls-min, -tls-max,
+// -ciphers and -sni flags. Empty values keep Go's defaults, and the server
+// name of a connection is then the host of its url.
+func newTLSConfig(min, max, ciphers, sni string) (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: true}
+
+	if sni != "" {
+		if net.ParseIP(sni) != nil || strings.ContainsAny(sni, ":/ ") {
+			return nil, fmt.Errorf("invalid -sni %q, must be a host name like api.example.com", sni)
+		}
+		cfg.ServerName = sni
+	}
+
+	if min != "" {
+		v, ok := tlsVersions[min]
+		if !ok {
+			return nil, fmt.Errorf("invalid -tls-min %q, must be one of 1.0, 1.1, 1.2 or 1.3", min)
+		}
+		cfg.MinVersion = v
+	}
+
+	if max != "" {
+		v, ok := tlsVersions[max]
+		if !ok {
+			return nil, fmt.Errorf("invalid -tls-max %q, must be one of 1.0, 1.1, 1.2 or 1.3", max)
+		}
+		cfg.MaxVersion = v
+	}
+
+	if cfg.MinVersion != 0 && cfg.MaxVersion != 0 && cfg.MinVersion > cfg.MaxVersion {
+		return nil, fmt.Errorf("-tls-min %s is above -tls-max %s", min, max)
+	}
+
+	if ciphers != "" {
+		suites := make(map[string]uint16)
+		for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[suite.Name] = suite.ID
+		}
+
+		for _, name := range strings.Split(ciphers, ",") {
+			id, ok := suites[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	return cfg, nil
+}
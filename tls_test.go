@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func Test_newTLSConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		min, max    string
+		ciphers     string
+		sni         string
+		wantMin     uint16
+		wantMax     uint16
+		wantCiphers []uint16
+		wantErr     bool
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:    "force TLS 1.2",
+			min:     "1.2",
+			max:     "1.2",
+			wantMin: tls.VersionTLS12,
+			wantMax: tls.VersionTLS12,
+		},
+		{
+			name:    "TLS 1.3 only",
+			min:     "1.3",
+			wantMin: tls.VersionTLS13,
+		},
+		{
+			name:        "cipher suites",
+			max:         "1.2",
+			ciphers:     "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			wantMax:     tls.VersionTLS12,
+			wantCiphers: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		},
+		{
+			name: "server name",
+			sni:  "api.example.com",
+		},
+		{
+			name:    "server name with a port",
+			sni:     "api.example.com:443",
+			wantErr: true,
+		},
+		{
+			name:    "server name is an address",
+			sni:     "203.0.113.5",
+			wantErr: true,
+		},
+		{
+			name:    "invalid min",
+			min:     "1.4",
+			wantErr: true,
+		},
+		{
+			name:    "invalid max",
+			max:     "tls13",
+			wantErr: true,
+		},
+		{
+			name:    "min above max",
+			min:     "1.3",
+			max:     "1.2",
+			wantErr: true,
+		},
+		{
+			name:    "unknown cipher",
+			ciphers: "TLS_NULL_WITH_NULL_NULL",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTLSConfig(tt.min, tt.max, tt.ciphers, tt.sni)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.InsecureSkipVerify {
+				t.Error("newTLSConfig() should skip verification")
+			}
+			if got.MinVersion != tt.wantMin || got.MaxVersion != tt.wantMax {
+				t.Errorf("newTLSConfig() versions = %x-%x, want %x-%x", got.MinVersion, got.MaxVersion, tt.wantMin, tt.wantMax)
+			}
+			if !reflect.DeepEqual(got.CipherSuites, tt.wantCiphers) {
+				t.Errorf("newTLSConfig() ciphers = %v, want %v", got.CipherSuites, tt.wantCiphers)
+			}
+			if got.ServerName != tt.sni {
+				t.Errorf("newTLSConfig() server name = %q, want %q", got.ServerName, tt.sni)
+			}
+		})
+	}
+}
+
+func Test_sni(t *testing.T) {
+	seen := make(chan string, 2)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			seen <- hello.ServerName
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	cfg, err := newTLSConfig("", "", "", "api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the url's host is an address, which is never sent as a server name
+	client := &http.Client{Transport: newTransport(1, transportConfig{tls: cfg})}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-seen; got != "api.example.com" {
+		t.Errorf("server name over the transport = %q, want api.example.com", got)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	conn, err := dialHTTP1(context.Background(), transportConfig{tls: cfg}, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if got := <-seen; got != "api.example.com" {
+		t.Errorf("server name dialing by hand = %q, want api.example.com", got)
+	}
+}
+
+func Test_countTLSVersion(t *testing.T) {
+	resetTLS := func() {
+		for i := range negotiatedTLS {
+			negotiatedTLS[i].Store(0)
+		}
+	}
+	resetTLS()
+	defer resetTLS()
+
+	countTLSVersion(tls.VersionTLS12)
+	countTLSVersion(tls.VersionTLS13)
+	countTLSVersion(tls.VersionTLS13)
+	countTLSVersion(tls.VersionSSL30)
+
+	want := []int64{0, 0, 1, 2}
+	for i := range negotiatedTLS {
+		if got := negotiatedTLS[i].Load(); got != want[i] {
+			t.Errorf("negotiatedTLS[1.%d] = %d, want %d", i, got, want[i])
+		}
+	}
+}
+
+func Test_countTLSVersionPerConnection(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, h2 := range []bool{false, true} {
+		for i := range negotiatedTLS {
+			negotiatedTLS[i].Store(0)
+		}
+
+		client := &http.Client{Transport: newTransport(1, transportConfig{http2: h2})}
+		for i := 0; i < 3; i++ {
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			req, _ = withConnTrace(req)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if got := negotiatedTLS[tls.VersionTLS13-tls.VersionTLS10].Load(); got != 1 {
+			t.Errorf("http2 %v: negotiatedTLS[1.3] = %d after 3 requests on one connection, want 1", h2, got)
+		}
+	}
+
+	for i := range negotiatedTLS {
+		negotiatedTLS[i].Store(0)
+	}
+}
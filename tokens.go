@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// template tokens substituted per request in urls, bodies and header values:
+// {{seq}}            the request's sequence number, the same everywhere in a request
+// {{uuid}}           a random version 4 UUID
+// {{rand}}           a random non-negative integer
+// {{rand:<min>-<max>}} a random integer from min to max, inclusive
+var tokenRegexp = regexp.MustCompile(`\{\{(seq|uuid|rand(?::(\d+)-(\d+))?)\}\}`)
+
+// tokenExpander substitutes the tokens of a single request
+type tokenExpander struct {
+	trgt   *targeter
+	seq    int64 // taken from the targeter on first use
+	hasSeq bool
+}
+
+// sequence is the request's sequence number
+func (t *tokenExpander) sequence() int64 {
+	if !t.hasSeq {
+		t.seq, t.hasSeq = t.trgt.seq.Add(1)-1, true
+	}
+
+	return t.seq
+}
+
+func (t *tokenExpander) expand(s string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+
+	return tokenRegexp.ReplaceAllStringFunc(s, func(token string) string {
+		m := tokenRegexp.FindStringSubmatch(token)
+		switch {
+		case m[1] == "seq":
+			return strconv.FormatInt(t.sequence(), 10)
+		case m[1] == "uuid":
+			return randomUUID()
+		case m[2] != "":
+			min, minErr := strconv.ParseUint(m[2], 10, 64)
+			max, maxErr := strconv.ParseUint(m[3], 10, 64)
+			if minErr != nil || maxErr != nil || max < min {
+				return token
+			}
+			return strconv.FormatUint(min+randomUpTo(max-min), 10)
+		default:
+			return strconv.FormatInt(rand.Int63(), 10)
+		}
+	})
+}
+
+func (t *tokenExpander) expandBytes(b []byte) []byte {
+	if !bytes.Contains(b, []byte("{{")) {
+		return b
+	}
+
+	return []byte(t.expand(string(b)))
+}
+
+// randomUpTo picks from 0 to n inclusive, n+1 overflowing for the widest ranges
+func randomUpTo(n uint64) uint64 {
+	if n < math.MaxInt64 {
+		return uint64(rand.Int63n(int64(n) + 1))
+	}
+
+	r := rand.Uint64()
+	for r > n {
+		r = rand.Uint64()
+	}
+
+	return r
+}
+
+func randomUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
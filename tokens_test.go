@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestNextRequestHeaderTokens(t *testing.T) {
+	trgt := targeter{
+		requests: []request{
+			request{
+				method: "GET",
+				url:    "http://127.0.0.1:5000/{{seq}}",
+			},
+		},
+		header: http.Header{
+			"X-Request-Id": []string{"{{uuid}}"},
+			"X-Shard":      []string{"{{rand:1-4}}"},
+			"X-Seq":        []string{"seq-{{seq}}"},
+			"X-Static":     []string{"static"},
+		},
+	}
+
+	uuids := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := req.Header.Get("X-Request-Id")
+		if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(id) {
+			t.Errorf("Expected a UUID, got '%s'", id)
+		}
+		uuids[id] = true
+
+		if shard, err := strconv.Atoi(req.Header.Get("X-Shard")); err != nil || shard < 1 || shard > 4 {
+			t.Errorf("Expected a shard from 1 to 4, got '%s'", req.Header.Get("X-Shard"))
+		}
+
+		if got, want := req.Header.Get("X-Seq"), "seq-"+strconv.Itoa(i); got != want {
+			t.Errorf("Expected header '%s', got '%s'", want, got)
+		}
+		if got, want := req.URL.Path, "/"+strconv.Itoa(i); got != want {
+			t.Errorf("Expected the header and url to share the sequence number, got '%s'", got)
+		}
+
+		if got := req.Header.Get("X-Static"); got != "static" {
+			t.Errorf("Expected untemplated header to stay, got '%s'", got)
+		}
+	}
+
+	if len(uuids) != 10 {
+		t.Errorf("Expected 10 distinct UUIDs, got %d", len(uuids))
+	}
+}
+
+func Test_tokenExpander_expand(t *testing.T) {
+	tokens := tokenExpander{trgt: &targeter{}}
+
+	tests := []struct {
+		in   string
+		want *regexp.Regexp
+	}{
+		{"no tokens", regexp.MustCompile(`^no tokens$`)},
+		{"{{rand}}", regexp.MustCompile(`^\d+$`)},
+		{"{{rand:7-7}}", regexp.MustCompile(`^7$`)},
+		{"{{rand:9-1}}", regexp.MustCompile(`^\{\{rand:9-1\}\}$`)},
+		{"{{rand:0-9223372036854775807}}", regexp.MustCompile(`^\d+$`)},
+		{"{{rand:0-18446744073709551615}}", regexp.MustCompile(`^\d+$`)},
+		{"{{rand:18446744073709551615-18446744073709551615}}", regexp.MustCompile(`^18446744073709551615$`)},
+		{"{{rand:0-18446744073709551616}}", regexp.MustCompile(`^\{\{rand:0-18446744073709551616\}\}$`)},
+		{"{{unknown}}", regexp.MustCompile(`^\{\{unknown\}\}$`)},
+		{"{{seq}}/{{seq}}", regexp.MustCompile(`^0/0$`)},
+	}
+	for _, tt := range tests {
+		if got := tokens.expand(tt.in); !tt.want.MatchString(got) {
+			t.Errorf("expand(%q) = %q, want match for %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_tokenExpander_expandBytes(t *testing.T) {
+	tokens := tokenExpander{trgt: &targeter{}}
+
+	body := []byte(`{"user": "foo", "items": [1, 2, 3]}`)
+	if allocs := testing.AllocsPerRun(100, func() { tokens.expandBytes(body) }); allocs != 0 {
+		t.Errorf("expandBytes() of a body without tokens made %v allocations, want none", allocs)
+	}
+
+	if got := string(tokens.expandBytes([]byte(`{"id": {{rand:7-7}}}`))); got != `{"id": 7}` {
+		t.Errorf("expandBytes() = %q, want the token expanded", got)
+	}
+}
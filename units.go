@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// latencyUnit is a unit latencies are shown in. They are tracked in
+// milliseconds whatever the unit.
+type latencyUnit struct {
+	name     string
+	perMs    float64 // of the unit in a millisecond
+	decimals int     // in summaries
+}
+
+var latencyUnits = map[string]latencyUnit{
+	"us": {"us", 1000, 0},
+	"ms": {"ms", 1, 1},
+	"s":  {"s", 0.001, 4},
+}
+
+// displayUnit is the unit of the histogram labels, stats line and summaries
+var displayUnit = latencyUnits["ms"]
+
+func lookupLatencyUnit(name string) (latencyUnit, error) {
+	unit, ok := latencyUnits[name]
+	if !ok {
+		return unit, fmt.Errorf("unknown latency unit %q, must be us, ms or s", name)
+	}
+
+	return unit, nil
+}
+
+// format is ms in the unit, with its name, e.g. 12.6ms
+func (u latencyUnit) format(ms float64) string {
+	return strconv.FormatFloat(ms*u.perMs, 'f', u.decimals, 64) + u.name
+}
+
+// labelFormat is the verb for histogram labels of a range ending at end,
+// already in the unit: whole numbers from 10 up, and more decimals below
+func labelFormat(end float64) string {
+	switch {
+	case end >= 10:
+		return "%3.0f"
+	case end >= 1:
+		return "%.1f"
+	default:
+		return "%.3f"
+	}
+}
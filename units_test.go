@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_latencyUnitLabels(t *testing.T) {
+	setupTestLayout(t)
+	defer func() { displayUnit = latencyUnits["ms"] }()
+
+	tests := []struct {
+		unit            string
+		first, mid, top string
+	}{
+		{"ms", "<1.0 ms:", "1.3-1.6 ms:", "100+ ms:"},
+		{"us", "<1000 us:", "1274-1624 us:", "100000+ us:"},
+		{"s", "<0.001  s:", "0.001-0.002  s:", "0.100+  s:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			unit, err := lookupLatencyUnit(tt.unit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			displayUnit = unit
+
+			var buf bytes.Buffer
+			layoutMu.RLock()
+			renderHistogram(&buf, palettes["mono"], make([]int64, buckets), make([]int64, buckets), make([]int64, buckets), int(buckets), 10, -1, false)
+			layoutMu.RUnlock()
+
+			rows := strings.Split(buf.String(), "\r\n")
+			for _, want := range []struct {
+				row   int
+				label string
+			}{{0, tt.first}, {2, tt.mid}, {int(buckets) - 1, tt.top}} {
+				if got := strings.TrimSpace(rows[want.row]); !strings.HasPrefix(got, want.label) {
+					t.Errorf("row %d = %q, want it labeled %q", want.row, got, want.label)
+				}
+			}
+		})
+	}
+
+	if _, err := lookupLatencyUnit("ns"); err == nil {
+		t.Error("lookupLatencyUnit(ns) didn't fail")
+	}
+}
+
+func Test_latencyUnitSummary(t *testing.T) {
+	defer func() { displayUnit = latencyUnits["ms"] }()
+
+	s := Summary{Time: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), P50: 0.25, P90: 1.5, P99: 12}
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{"ms", "p50=0.2ms p90=1.5ms p99=12.0ms"},
+		{"us", "p50=250us p90=1500us p99=12000us"},
+		{"s", "p50=0.0003s p90=0.0015s p99=0.0120s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			displayUnit = latencyUnits[tt.unit]
+			if got := s.String(); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("String() = %q, want it to end in %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// validator marks responses as failed when they don't look as expected,
+// e.g. a 200 carrying an error page
+type validator struct {
+	statuses *statusSet // nil accepts any status
+	contains []byte     // nil accepts any body
+	matches  *regexp.Regexp
+	headers  []headerExpectation // all must hold
+}
+
+// headerExpectation is a header responses must have, with one of its values
+// being value, or any value if value is empty
+type headerExpectation struct {
+	key, value string
+}
+
+// parseHeaderExpectation parses an -expect-header, "Key: Value" or just
+// "Key" for any value
+func parseHeaderExpectation(s string) (headerExpectation, error) {
+	key, value, _ := strings.Cut(s, ":")
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return headerExpectation{}, fmt.Errorf("invalid -expect-header %q, expected 'Key: Value' or 'Key'", s)
+	}
+
+	return headerExpectation{key: http.CanonicalHeaderKey(key), value: strings.TrimSpace(value)}, nil
+}
+
+// needsBody reports whether validation looks at response bodies
+func (v *validator) needsBody() bool {
+	return v.contains != nil || v.matches != nil
+}
+
+func (v *validator) valid(status int, body []byte) bool {
+	if v.statuses != nil && (status < 0 || status >= len(v.statuses) || !v.statuses[status]) {
+		return false
+	}
+
+	if v.contains != nil && !bytes.Contains(body, v.contains) {
+		return false
+	}
+
+	if v.matches != nil && !v.matches.Match(body) {
+		return false
+	}
+
+	return true
+}
+
+// validHeaders reports whether header has every expected header
+func (v *validator) validHeaders(header http.Header) bool {
+expectations:
+	for _, want := range v.headers {
+		values, ok := header[want.key]
+		if !ok {
+			return false
+		}
+		if want.value == "" {
+			continue
+		}
+		for _, value := range values {
+			if value == want.value {
+				continue expectations
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
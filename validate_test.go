@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func Test_validator_valid(t *testing.T) {
+	created := &statusSet{}
+	created.Set("201")
+
+	tests := []struct {
+		name   string
+		v      validator
+		status int
+		body   string
+		want   bool
+	}{
+		{
+			name:   "expected status",
+			v:      validator{statuses: created},
+			status: 201,
+			want:   true,
+		},
+		{
+			name:   "unexpected status",
+			v:      validator{statuses: created},
+			status: 200,
+			want:   false,
+		},
+		{
+			name:   "body contains",
+			v:      validator{contains: []byte(`"ok": true`)},
+			status: 200,
+			body:   `{"ok": true}`,
+			want:   true,
+		},
+		{
+			name:   "body doesn't contain",
+			v:      validator{contains: []byte(`"ok": true`)},
+			status: 200,
+			body:   `<html>Internal error</html>`,
+			want:   false,
+		},
+		{
+			name:   "body matches",
+			v:      validator{matches: regexp.MustCompile(`"id": \d+`)},
+			status: 200,
+			body:   `{"id": 42}`,
+			want:   true,
+		},
+		{
+			name:   "body doesn't match",
+			v:      validator{matches: regexp.MustCompile(`"id": \d+`)},
+			status: 200,
+			body:   `{"id": null}`,
+			want:   false,
+		},
+		{
+			name:   "status and body must both hold",
+			v:      validator{statuses: created, contains: []byte("created")},
+			status: 200,
+			body:   "created",
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.valid(tt.status, []byte(tt.body)); got != tt.want {
+				t.Errorf("valid() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_attackValidation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			io.WriteString(w, "<html>Something went wrong</html>")
+			return
+		}
+		io.WriteString(w, `{"status": "ok"}`)
+	}))
+	defer srv.Close()
+
+	responseValidator = &validator{contains: []byte(`"status": "ok"`)}
+	defer func() { responseValidator = nil }()
+
+	tests := []struct {
+		path        string
+		wantOk      int64
+		wantBad     int64
+		wantInvalid int64
+	}{
+		{path: "/", wantOk: 1},
+		{path: "/broken", wantBad: 1, wantInvalid: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			setupTestLayout(t)
+			attackOnce(t, srv.URL+tt.path)
+
+			if ok, bad := windowTotals(); ok != tt.wantOk || bad != tt.wantBad {
+				t.Errorf("attack() recorded ok/bad %d/%d, want %d/%d", ok, bad, tt.wantOk, tt.wantBad)
+			}
+			if invalid := validationFailed.Load(); invalid != tt.wantInvalid {
+				t.Errorf("attack() counted %d invalid responses, want %d", invalid, tt.wantInvalid)
+			}
+			if responses[200].Load() != 1 {
+				t.Errorf("attack() should still count the response by its status")
+			}
+		})
+	}
+}
+
+func Test_parseHeaderExpectation(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    headerExpectation
+		wantErr bool
+	}{
+		{in: "X-Cache: HIT", want: headerExpectation{key: "X-Cache", value: "HIT"}},
+		{in: "content-type:application/json", want: headerExpectation{key: "Content-Type", value: "application/json"}},
+		{in: "ETag", want: headerExpectation{key: "Etag"}},
+		{in: "X-Cache:", want: headerExpectation{key: "X-Cache"}},
+		{in: ": HIT", wantErr: true},
+		{in: "X Cache: HIT", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseHeaderExpectation(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHeaderExpectation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseHeaderExpectation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validator_validHeaders(t *testing.T) {
+	v := validator{headers: []headerExpectation{{key: "X-Cache", value: "HIT"}, {key: "Etag"}}}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{"all there", http.Header{"X-Cache": {"HIT"}, "Etag": {`"abc"`}}, true},
+		{"one of the values", http.Header{"X-Cache": {"MISS", "HIT"}, "Etag": {`"abc"`}}, true},
+		{"wrong value", http.Header{"X-Cache": {"MISS"}, "Etag": {`"abc"`}}, false},
+		{"missing header", http.Header{"X-Cache": {"HIT"}}, false},
+		{"none", http.Header{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := v.validHeaders(tt.header); got != tt.want {
+				t.Errorf("validHeaders() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_attackHeaderValidation(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hit" {
+			w.Header().Set("X-Cache", "HIT")
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	responseValidator = &validator{headers: []headerExpectation{{key: "X-Cache", value: "HIT"}}}
+	defer func() { responseValidator = nil }()
+
+	tests := []struct {
+		path          string
+		wantOk        int64
+		wantBad       int64
+		wantBadHeader int64
+	}{
+		{path: "/hit", wantOk: 1},
+		{path: "/miss", wantBad: 1, wantBadHeader: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			setupTestLayout(t)
+			attackOnce(t, srv.URL+tt.path)
+
+			if ok, bad := windowTotals(); ok != tt.wantOk || bad != tt.wantBad {
+				t.Errorf("attack() recorded ok/bad %d/%d, want %d/%d", ok, bad, tt.wantOk, tt.wantBad)
+			}
+			if n := headerMismatches.Load(); n != tt.wantBadHeader {
+				t.Errorf("attack() counted %d responses with bad headers, want %d", n, tt.wantBadHeader)
+			}
+			if invalid := validationFailed.Load(); invalid != 0 {
+				t.Errorf("attack() counted %d invalid responses, want header mismatches counted apart", invalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "net/http"
+
+// virtualUsers sends every round of the targets as one of a set of users,
+// taking turns, each with a session id of its own in a header, so that a
+// load balancer pinning sessions keeps sending a user to the same backend
+type virtualUsers struct {
+	header   string
+	sessions []string
+}
+
+// newVirtualUsers makes n users, with session ids fresh for the run, sent
+// in header
+func newVirtualUsers(n int, header string) *virtualUsers {
+	v := &virtualUsers{header: http.CanonicalHeaderKey(header), sessions: make([]string, n)}
+	for i := range v.sessions {
+		v.sessions[i] = randomUUID()
+	}
+
+	return v
+}
+
+// session is the session id of the user sending the idx'th request, of
+// rounds of targets requests
+func (v *virtualUsers) session(idx int64, targets int) string {
+	return v.sessions[int((idx/int64(targets))%int64(len(v.sessions)))]
+}
+
+// requestCount is how many requests trgt sends in a round
+func (trgt *targeter) requestCount() int {
+	trgt.mu.RLock()
+	defer trgt.mu.RUnlock()
+
+	return len(trgt.requests)
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func Test_nextRequestVirtualUsers(t *testing.T) {
+	setupTestLayout(t)
+
+	trgt := &targeter{
+		requests: []request{
+			{method: "GET", url: "http://a/login"},
+			{method: "GET", url: "http://a/cart"},
+			{method: "POST", url: "http://a/pay"},
+		},
+		vusers: newVirtualUsers(2, "x-session"),
+	}
+
+	// each user gets a whole round of the targets, from the first one, then
+	// it is the next one's turn
+	var sessions, paths []string
+	for i := 0; i < 12; i++ {
+		req, err := trgt.nextRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, req.Header.Get("X-Session"))
+		paths = append(paths, req.URL.Path)
+	}
+
+	users := map[string]bool{}
+	user := -1
+	var turns []string
+	for i, session := range sessions {
+		if session == "" {
+			t.Fatalf("request %d sent no session", i)
+		}
+		users[session] = true
+		if i == 0 || paths[i] == "/login" {
+			user++
+			turns = append(turns, session)
+		}
+		if session != turns[user] {
+			t.Errorf("request %d, %s, sent session %q, want %q of its round", i, paths[i], session, turns[user])
+		}
+	}
+	if len(users) != 2 {
+		t.Errorf("requests sent %d sessions, want the 2 of -vusers", len(users))
+	}
+	for i := 2; i < len(turns); i++ {
+		if turns[i] != turns[i-2] {
+			t.Errorf("round %d sent session %q, want %q again", i, turns[i], turns[i-2])
+		}
+	}
+}
+
+func Test_virtualUsersSession(t *testing.T) {
+	v := newVirtualUsers(3, "X-Session")
+	for idx := int64(0); idx < 40; idx++ {
+		if got, want := v.session(idx, 4), v.sessions[(idx/4)%3]; got != want {
+			t.Errorf("session(%d, 4) = %q, want %q", idx, got, want)
+		}
+	}
+	if v.session(13, 4) != v.session(1, 4) || v.session(12, 4) != v.session(0, 4) {
+		t.Error("session() not stable for the same user")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import "sync"
+
+// workersStep is how many workers a key press starts or stops
+const workersStep = 4
+
+// workerPool runs the attackers, as many as asked for at any time. Every
+// worker has a quit channel of its own, so the pool can be shrunk while the
+// run goes on.
+type workerPool struct {
+	run       func(quit <-chan struct{}) // a worker, until quit is closed
+	perWorker int64                      // requests/s every worker adds to the rate, 0 if the rate is not per worker
+
+	mu      sync.Mutex
+	quits   []chan struct{} // of the running workers, in the order started
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// attackers are the workers of the run
+var attackers *workerPool
+
+func newWorkerPool(run func(quit <-chan struct{}), perWorker int64) *workerPool {
+	return &workerPool{run: run, perWorker: perWorker}
+}
+
+// resize starts delta workers, or stops -delta of them, the latest started
+// first, and gives how many were started, or stopped if negative. A pool
+// with workers keeps at least one, and stays empty once stopped.
+func (p *workerPool) resize(delta int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return 0
+	}
+
+	before := len(p.quits)
+	n := before + delta
+	if n < 1 {
+		n = min(1, before)
+	}
+
+	for len(p.quits) < n {
+		quit := make(chan struct{})
+		p.quits = append(p.quits, quit)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.run(quit)
+		}()
+	}
+
+	for len(p.quits) > n {
+		close(p.quits[len(p.quits)-1])
+		p.quits = p.quits[:len(p.quits)-1]
+	}
+	workerCount.Store(int64(n))
+
+	return n - before
+}
+
+// size is the number of workers running
+func (p *workerPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.quits)
+}
+
+// stop stops every worker, for good
+func (p *workerPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, quit := range p.quits {
+		close(quit)
+	}
+	p.quits = nil
+	p.stopped = true
+	workerCount.Store(0)
+}
+
+// wait waits for the stopped workers to be done with their requests
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
+
+// scaleWorkers starts or stops delta attackers, and with -rate-per-worker
+// changes the rate by as much as the workers started or stopped make up
+func scaleWorkers(delta int, rateChanger chan<- rateChange) {
+	n := attackers.resize(delta)
+	if n != 0 && attackers.perWorker > 0 {
+		rateChanger <- rateChange{int64(n) * attackers.perWorker, "workers"}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// goroutinesBack waits for the number of goroutines to drop to want, and
+// gives how many are left if it doesn't. Goroutines other tests left behind
+// may be done by then, so fewer are fine.
+func goroutinesBack(want int) int {
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > want && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	return runtime.NumGoroutine()
+}
+
+func Test_workerPoolResize(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	var running counter
+	p := newWorkerPool(func(quit <-chan struct{}) {
+		running.Add(1)
+		defer running.Add(-1)
+		<-quit
+	}, 0)
+
+	steps := []struct {
+		delta int
+		want  int // started, or stopped if negative
+		size  int
+	}{
+		{8, 8, 8},
+		{4, 4, 12},
+		{-4, -4, 8},
+		{-20, -7, 1}, // keeps one
+		{-1, 0, 1},
+		{3, 3, 4},
+	}
+	for _, step := range steps {
+		if got := p.resize(step.delta); got != step.want {
+			t.Errorf("resize(%d) = %d, want %d", step.delta, got, step.want)
+		}
+		if got := p.size(); got != step.size {
+			t.Errorf("size() after resize(%d) = %d, want %d", step.delta, got, step.size)
+		}
+		if got := workerCount.Load(); got != int64(step.size) {
+			t.Errorf("workerCount after resize(%d) = %d, want %d", step.delta, got, step.size)
+		}
+		if got := goroutinesBack(base + step.size); got > base+step.size {
+			t.Errorf("%d goroutines after resize(%d), want %d", got, step.delta, base+step.size)
+		}
+	}
+
+	p.stop()
+	p.wait()
+	if got := running.Load(); got != 0 {
+		t.Errorf("%d workers still running after stop()", got)
+	}
+	if got := p.resize(4); got != 0 {
+		t.Errorf("resize() after stop() = %d, want 0", got)
+	}
+	if got := goroutinesBack(base); got > base {
+		t.Errorf("%d goroutines after stop(), want %d", got, base)
+	}
+}
+
+func Test_scaleWorkers(t *testing.T) {
+	defer func(old *workerPool) { attackers = old }(attackers)
+
+	tests := []struct {
+		name      string
+		perWorker int64
+		delta     int
+		want      []rateChange
+	}{
+		{"fixed rate", 0, workersStep, nil},
+		{"per worker up", 25, workersStep, []rateChange{{workersStep * 25, "workers"}}},
+		{"per worker down", 25, -workersStep, []rateChange{{-workersStep * 25, "workers"}}},
+		{"per worker last one", 25, -100, []rateChange{{-7 * 25, "workers"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attackers = newWorkerPool(func(quit <-chan struct{}) { <-quit }, tt.perWorker)
+			attackers.resize(8)
+			defer attackers.wait()
+			defer attackers.stop()
+
+			rateChanger := make(chan rateChange, 1)
+			scaleWorkers(tt.delta, rateChanger)
+			close(rateChanger)
+
+			var got []rateChange
+			for c := range rateChanger {
+				got = append(got, c)
+			}
+			if len(got) != len(tt.want) || len(got) > 0 && got[0] != tt.want[0] {
+				t.Errorf("scaleWorkers() changed the rate by %v, want %v", got, tt.want)
+			}
+		})
+	}
+}